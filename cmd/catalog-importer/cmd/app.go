@@ -19,6 +19,7 @@ import (
 	"github.com/go-kit/log/level"
 	"github.com/google/go-cmp/cmp"
 	"github.com/incident-io/catalog-importer/v2/config"
+	"github.com/incident-io/catalog-importer/v2/profile"
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
 )
@@ -34,6 +35,12 @@ var (
 	noColor   = app.Flag("no-color", "Disable colored output").Default("false").Bool()
 	logFormat = app.Flag("log-format", "Format in which to emit logs (either json or logfmt)").Default("logfmt").String()
 
+	// Profiles, read before parsing the rest of the command line (see applyProfile)
+	profileFlag      = app.Flag("profile", "Named profile providing the API endpoint, key and default flags (e.g. staging)").Envar(profileEnvar)
+	profilesFileFlag = app.Flag("profiles-file", "File holding named profiles").Default(profile.DefaultPath()).Envar(profilesFileEnvar)
+	profileName      = profileFlag.String()
+	profilesFile     = profilesFileFlag.String()
+
 	// Init
 	initCmd     = app.Command("init", "Initialises a new config from a template")
 	initOptions = new(InitOptions).Bind(initCmd)
@@ -50,6 +57,18 @@ var (
 	sync        = app.Command("sync", "Sync data from catalog sources into incident.io")
 	syncOptions = new(SyncOptions).Bind(sync)
 
+	// Snapshot
+	snapshotCmd     = app.Command("snapshot", "Saves the catalog types managed by a sync ID, and all their entries, to a file")
+	snapshotOptions = new(SnapshotOptions).Bind(snapshotCmd)
+
+	// Restore
+	restoreCmd     = app.Command("restore", "Restores catalog types and their entries from a snapshot")
+	restoreOptions = new(RestoreOptions).Bind(restoreCmd)
+
+	// Drift
+	driftCmd     = app.Command("drift", "Compares source, catalog and what was last synced, failing if the catalog was changed outside the importer")
+	driftOptions = new(DriftOptions).Bind(driftCmd)
+
 	// Source
 	sourceCmd     = app.Command("source", "Loads and prints the catalog entries from source, for debugging")
 	sourceOptions = new(SourceOptions).Bind(sourceCmd)
@@ -65,10 +84,26 @@ var (
 	// Backstage
 	backstageCmd     = app.Command("backstage", "Syncs catalog entries directly from Backstage API into incident.io")
 	backstageOptions = new(BackstageOptions).Bind(backstageCmd)
+
+	// Profiles
+	profilesCmd         = app.Command("profiles", "Manage named profiles of incident.io connections")
+	profilesListCmd     = profilesCmd.Command("list", "Lists the profiles in the profiles file")
+	profilesListOptions = new(ProfilesListOptions).Bind(profilesListCmd)
+	profilesTestCmd     = profilesCmd.Command("test", "Checks each profile can connect to incident.io, showing who its API key identifies as")
+	profilesTestOptions = new(ProfilesTestOptions).Bind(profilesTestCmd)
+)
+
+const (
+	profileEnvar      = "CATALOG_IMPORTER_PROFILE"
+	profilesFileEnvar = "CATALOG_IMPORTER_PROFILES_FILE"
 )
 
 func Run(ctx context.Context) (err error) {
-	command := kingpin.MustParse(app.Parse(os.Args[1:]))
+	args, err := applyProfile(ctx, os.Args[1:])
+	if err != nil {
+		app.Fatalf("applying profile: %s", err)
+	}
+	command := kingpin.MustParse(app.Parse(args))
 
 	switch *logFormat {
 	case "json":
@@ -110,6 +145,12 @@ func Run(ctx context.Context) (err error) {
 		return typesOptions.Run(ctx, logger)
 	case sync.FullCommand():
 		return syncOptions.Run(ctx, logger, nil)
+	case snapshotCmd.FullCommand():
+		return snapshotOptions.Run(ctx, logger)
+	case restoreCmd.FullCommand():
+		return restoreOptions.Run(ctx, logger)
+	case driftCmd.FullCommand():
+		return driftOptions.Run(ctx, logger)
 	case sourceCmd.FullCommand():
 		return sourceOptions.Run(ctx, logger)
 	case jsonnetCmd.FullCommand():
@@ -118,6 +159,10 @@ func Run(ctx context.Context) (err error) {
 		return validateOptions.Run(ctx, logger)
 	case backstageCmd.FullCommand():
 		return backstageOptions.Run(ctx, logger)
+	case profilesListCmd.FullCommand():
+		return profilesListOptions.Run(ctx, logger)
+	case profilesTestCmd.FullCommand():
+		return profilesTestOptions.Run(ctx, logger)
 	default:
 		return fmt.Errorf("unrecognised command: %s", command)
 	}
@@ -22,13 +22,7 @@ type BackstageOptions struct {
 }
 
 func (opt *BackstageOptions) Bind(cmd *kingpin.CmdClause) *BackstageOptions {
-	cmd.Flag("api-endpoint", "Endpoint of the incident.io API").
-		Default("https://api.incident.io").
-		Envar("INCIDENT_ENDPOINT").
-		StringVar(&opt.APIEndpoint)
-	cmd.Flag("api-key", "API key for incident.io").
-		Envar("INCIDENT_API_KEY").
-		StringVar(&opt.APIKey)
+	bindAPIFlags(cmd, &opt.APIEndpoint, &opt.APIKey)
 	cmd.Flag("backstage-endpoint", "Endpoint of the Backstage entries API").
 		Default("http://localhost:7007/api/catalog/entities/by-query").
 		Envar("BACKSTAGE_ENDPOINT").
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/fatih/color"
+	kitlog "github.com/go-kit/log"
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/config"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+	"github.com/incident-io/catalog-importer/v2/source"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+type DriftOptions struct {
+	ConfigFile                string
+	APIEndpoint               string
+	APIKey                    string
+	Targets                   []string
+	SyncIDSuffix              string
+	StateFile                 string
+	SampleLength              int
+	CatalogEntriesAPIPageSize int
+}
+
+func (opt *DriftOptions) Bind(cmd *kingpin.CmdClause) *DriftOptions {
+	cmd.Flag("config", "Config file in either Jsonnet, YAML or JSON (e.g. importer.jsonnet)").
+		StringVar(&opt.ConfigFile)
+	bindAPIFlags(cmd, &opt.APIEndpoint, &opt.APIKey)
+	cmd.Flag("target", `Restrict running to only these outputs (e.g. Custom["Customer"])`).
+		StringsVar(&opt.Targets)
+	cmd.Flag("sync-id-suffix", "Append this to the config's sync_id, such as when a profile points at a different workspace (e.g. -staging)").
+		StringVar(&opt.SyncIDSuffix)
+	cmd.Flag("state-file", "File recording what sync last applied to each entry ({sync_id} is replaced with the config's sync ID)").
+		Default(".catalog-importer-state.{sync_id}.json").
+		StringVar(&opt.StateFile)
+	cmd.Flag("sample-length", "How many character to sample when logging about invalid source entries (for --debug only)").
+		Default("256").
+		IntVar(&opt.SampleLength)
+	cmd.Flag("catalog-entries-api-page-size", "The page size to use when listing catalog entries from the API").
+		Envar("CATALOG_ENTRIES_API_PAGE_SIZE").
+		Default("250").
+		IntVar(&opt.CatalogEntriesAPIPageSize)
+
+	return opt
+}
+
+func (opt *DriftOptions) Run(ctx context.Context, logger kitlog.Logger) error {
+	cfg, err := loadConfigOrError(ctx, opt.ConfigFile)
+	if err != nil {
+		return err
+	}
+	if opt.SyncIDSuffix != "" {
+		cfg = cfg.WithSyncIDSuffix(opt.SyncIDSuffix)
+	}
+	if len(opt.Targets) > 0 {
+		OUT("⊕ Filtering config to targets (%s)", strings.Join(opt.Targets, ", "))
+		cfg = cfg.Filter(opt.Targets)
+	}
+
+	state, err := loadState(opt.StateFile, cfg.SyncID)
+	if err != nil {
+		return err
+	}
+	if len(state.Outputs) == 0 {
+		OUT("⚠ No state found in %s, so differences can't be attributed to source or catalog", syncIDFilename(opt.StateFile, cfg.SyncID))
+	}
+
+	if opt.APIKey == "" {
+		return fmt.Errorf("no API key provided as --api-key or in INCIDENT_API_KEY")
+	}
+
+	// We only ever read, so make sure we can't change anything.
+	cl, err := client.New(ctx, opt.APIKey, opt.APIEndpoint, Version(), logger, client.WithReadOnly())
+	if err != nil {
+		return err
+	}
+
+	result, err := cl.CatalogV3ListTypesWithResponse(ctx)
+	if err != nil {
+		return errors.Wrap(err, "listing catalog types")
+	}
+	OUT("✔ Connected to incident.io API (%s)", opt.APIEndpoint)
+
+	counts := map[reconcile.DriftKind]int{}
+	for _, pipeline := range cfg.Pipelines {
+		OUT("\n↻ Loading data from sources... (%s)", strings.Join(lo.Map(pipeline.Outputs, func(op *output.Output, _ int) string {
+			return op.TypeName
+		}), ", "))
+		sourcedEntries, sourcedOrigins, err := loadPipelineSources(ctx, logger, pipeline, opt.SampleLength, nil)
+		if err != nil {
+			return err
+		}
+
+		for idx, outputType := range pipeline.Outputs {
+			drifts, err := opt.detectDrift(ctx, logger, cl, cfg, state, result.JSON200.CatalogTypes, outputType, sourcedEntries, sourcedOrigins)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("outputs.%d (type_name='%s')", idx, outputType.TypeName))
+			}
+
+			OUT("\n  ↻ %s (%d entries differ)", outputType.TypeName, len(drifts))
+			for _, drift := range drifts {
+				counts[drift.Kind]++
+
+				lineColor := lo.Ternary(drift.Kind == reconcile.DriftSourceChanged, color.FgYellow, color.FgRed)
+				OUT("    %s", color.New(lineColor).Sprint(drift.String()))
+			}
+		}
+	}
+
+	OUT("\n✔ Found %d source changes waiting to be synced, %d entries drifted in the catalog, and %d conflicting",
+		counts[reconcile.DriftSourceChanged], counts[reconcile.DriftCatalogDrifted], counts[reconcile.DriftConflicting])
+
+	// Source changes are expected between syncs, but anything changed in the catalog will
+	// be overwritten by the next sync, which is worth alerting on.
+	if drifted := counts[reconcile.DriftCatalogDrifted] + counts[reconcile.DriftConflicting]; drifted > 0 {
+		return fmt.Errorf("found %d entries that were changed in the catalog since they were last synced", drifted)
+	}
+
+	return nil
+}
+
+// detectDrift compares the entries we'd build for the output from source against those in
+// the catalog and what we last applied.
+func (opt *DriftOptions) detectDrift(ctx context.Context, logger kitlog.Logger, cl *client.ClientWithResponses, cfg *config.Config, state *reconcile.State, catalogTypes []client.CatalogTypeV3, outputType *output.Output, sourcedEntries []source.Entry, sourcedOrigins []string) ([]reconcile.Drift, error) {
+	entries, origins, err := output.CollectWithOrigins(ctx, logger, outputType, sourcedEntries, sourcedOrigins)
+	if err != nil {
+		return nil, err
+	}
+
+	entryModels, err := output.MarshalEntries(ctx, logger, outputType, entries)
+	if err != nil {
+		return nil, err
+	}
+	for entryIdx, entryModel := range entryModels {
+		entryModel.Origin = origins[entryIdx]
+	}
+
+	entryModels, _, err = output.ResolveDuplicates(outputType, entryModels)
+	if err != nil {
+		return nil, err
+	}
+
+	// The catalog type may not have been created yet, in which case it has no entries.
+	// Partitioned types may have been created by another importer we share them with.
+	catalogEntries := []client.CatalogEntryV3{}
+	catalogType, ok := lo.Find(catalogTypes, func(catalogType client.CatalogTypeV3) bool {
+		managed := catalogType.Annotations[AnnotationSyncID] == cfg.SyncID || outputType.Partition != nil
+		return catalogType.TypeName == outputType.TypeName && managed
+	})
+	if ok {
+		_, allEntries, err := reconcile.GetEntries(ctx, cl, catalogType.Id, opt.CatalogEntriesAPIPageSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "listing entries")
+		}
+
+		// Entries outside our partition belong to other importers, so can't drift from us.
+		for _, entry := range allEntries {
+			owned, err := outputType.OwnsEntry(ctx, logger, entry)
+			if err != nil {
+				return nil, errors.Wrap(err, "checking entry partition")
+			}
+			if owned {
+				catalogEntries = append(catalogEntries, entry)
+			}
+		}
+
+		// Entries the on_missing policy is keeping around after they left source won't be
+		// touched by the next sync, so aren't drift either.
+		retained := lo.SliceToMap(reconcile.RetainedEntries(outputType, &catalogType, catalogEntries, entryModels, time.Now()),
+			func(entry client.CatalogEntryV3) (string, bool) {
+				return entry.Id, true
+			})
+		catalogEntries = lo.Reject(catalogEntries, func(entry client.CatalogEntryV3, _ int) bool {
+			return retained[entry.Id]
+		})
+	}
+
+	applied, _ := state.Applied(outputType.TypeName)
+
+	return reconcile.DetectDrift(
+		applied,
+		reconcile.AppliedEntriesFromCatalog(outputType, catalogEntries),
+		reconcile.AppliedEntriesFromModels(outputType, entryModels),
+	), nil
+}
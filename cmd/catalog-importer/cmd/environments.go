@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/incident-io/catalog-importer/v2/config"
+	"github.com/incident-io/catalog-importer/v2/source"
+	"github.com/pkg/errors"
+)
+
+// runEnvironments syncs the config into each of the requested environments in turn,
+// sharing the entries loaded from source between them.
+func (opt *SyncOptions) runEnvironments(ctx context.Context, logger kitlog.Logger) error {
+	if opt.ConfigFile == "" {
+		return errors.New("No config file set! (--config)")
+	}
+
+	environments, err := config.LoadEnvironments(opt.ConfigFile)
+	if err != nil {
+		return errors.Wrap(err, "loading environments")
+	}
+	if len(environments) == 0 {
+		return errors.New("config has no environments, so --env and --all-envs can't be used")
+	}
+
+	names := []string{opt.Env}
+	if opt.AllEnvs {
+		names = config.EnvironmentNames(environments)
+	}
+
+	cache := newSourceCache()
+	for _, name := range names {
+		cfg, env, err := config.LoadEnvironment(opt.ConfigFile, name)
+		if err != nil {
+			return errors.Wrap(err, "loading config")
+		}
+		if err := cfg.Validate(); err != nil {
+			data, _ := json.MarshalIndent(err, "", "  ")
+			return fmt.Errorf("validating config for environment %s:\n%s", name, string(data))
+		}
+
+		envOpt := *opt
+		envOpt.Env, envOpt.AllEnvs = "", false
+		envOpt.sourceCache = cache
+		if env.APIEndpoint != "" {
+			envOpt.APIEndpoint = env.APIEndpoint
+		}
+		if env.APIKey != "" {
+			envOpt.APIKey = string(env.APIKey)
+		}
+
+		// Each environment has its own sync ID, so needs its own local files.
+		envOpt.CheckpointFile = environmentFilename(opt.CheckpointFile, name)
+		envOpt.StateFile = environmentFilename(opt.StateFile, name)
+		envOpt.SnapshotFile = environmentFilename(opt.SnapshotFile, name)
+
+		BANNER("Environment: %s (sync_id=%s, endpoint=%s)", name, cfg.SyncID, envOpt.APIEndpoint)
+		if err := envOpt.Run(ctx, kitlog.With(logger, "environment", name), cfg); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("environment %s", name))
+		}
+	}
+
+	return nil
+}
+
+// environmentFilename includes the environment name in a filename, before its extension
+// (e.g. state.json becomes state.prod.json).
+func environmentFilename(filename, name string) string {
+	if filename == "" {
+		return ""
+	}
+
+	ext := filepath.Ext(filename)
+	return fmt.Sprintf("%s.%s%s", strings.TrimSuffix(filename, ext), name, ext)
+}
+
+// sourceCache remembers what each source loaded, so syncing several environments from
+// the same config only loads each source once. A nil cache loads sources every time.
+type sourceCache struct {
+	entries map[string][]*source.SourceEntry // by source config
+}
+
+func newSourceCache() *sourceCache {
+	return &sourceCache{entries: map[string][]*source.SourceEntry{}}
+}
+
+// Load returns the entries of the source, loading them if we haven't already.
+func (c *sourceCache) Load(ctx context.Context, logger kitlog.Logger, src *source.Source) ([]*source.SourceEntry, error) {
+	if c == nil {
+		return src.Load(ctx, logger)
+	}
+
+	// Environments can vary their sources through ext vars, so we only reuse entries for
+	// sources whose config is identical.
+	key, err := json.Marshal(src)
+	if err != nil {
+		return nil, errors.Wrap(err, "building source cache key")
+	}
+
+	if entries, ok := c.entries[string(key)]; ok {
+		logger.Log("msg", "reusing entries already loaded from source")
+		return entries, nil
+	}
+
+	entries, err := src.Load(ctx, logger)
+	if err != nil {
+		return nil, err
+	}
+	c.entries[string(key)] = entries
+
+	return entries, nil
+}
@@ -31,13 +31,7 @@ type ImportOptions struct {
 }
 
 func (opt *ImportOptions) Bind(cmd *kingpin.CmdClause) *ImportOptions {
-	cmd.Flag("api-endpoint", "Endpoint of the incident.io API").
-		Default("https://api.incident.io").
-		Envar("INCIDENT_ENDPOINT").
-		StringVar(&opt.APIEndpoint)
-	cmd.Flag("api-key", "API key for incident.io").
-		Envar("INCIDENT_API_KEY").
-		StringVar(&opt.APIKey)
+	bindAPIFlags(cmd, &opt.APIEndpoint, &opt.APIKey)
 	cmd.Flag("run-sync", "Actually run the sync using the config produced by the import").
 		BoolVar(&opt.RunSync)
 	cmd.Flag("run-sync-dry-run", "If --run-sync, whether to do so in dry-run").
@@ -88,6 +82,9 @@ func (opt *ImportOptions) Run(ctx context.Context, logger kitlog.Logger) error {
 	attributes := map[string]*output.Attribute{}
 	for _, entry := range entries {
 		for key, value := range entry {
+			if key == source.MetaKey {
+				continue // provenance, not part of the file
+			}
 			_, alreadyAdded := attributes[key]
 			if alreadyAdded {
 				continue
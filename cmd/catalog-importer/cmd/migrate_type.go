@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// typeMigration moves the entries of a catalog type we manage onto the type that replaces
+// it, when an output's type_name changes and previous_type_name is set.
+type typeMigration struct {
+	Output *output.Output
+	From   client.CatalogTypeV3
+}
+
+// getTypeMigrations finds the outputs whose previous type still exists, and so need
+// migrating.
+func getTypeMigrations(outputs []*output.Output, existingCatalogTypes []client.CatalogTypeV3) []typeMigration {
+	migrations := []typeMigration{}
+	for _, op := range outputs {
+		if !op.PreviousTypeName.Valid {
+			continue
+		}
+
+		from, ok := lo.Find(existingCatalogTypes, func(catalogType client.CatalogTypeV3) bool {
+			return catalogType.TypeName == op.PreviousTypeName.String
+		})
+		if ok {
+			migrations = append(migrations, typeMigration{Output: op, From: from})
+		}
+	}
+
+	return migrations
+}
+
+// referencingAttributes returns the IDs of attributes in the catalog type that reference
+// entries of the given type.
+func referencingAttributes(catalogType client.CatalogTypeV3, typeName string) []string {
+	attributeIDs := []string{}
+	for _, attr := range catalogType.Schema.Attributes {
+		isDerived := attr.BacklinkAttribute != nil || attr.Path != nil
+		if attr.Type == typeName && !isDerived {
+			attributeIDs = append(attributeIDs, attr.Id)
+		}
+	}
+
+	return attributeIDs
+}
+
+// isMigrated returns true if the entries of the old type have already been copied to the
+// new one, and only removing the old type remains.
+func isMigrated(from client.CatalogTypeV3, to *client.CatalogTypeV3) bool {
+	return to != nil && to.Annotations[AnnotationMigratedFrom] == from.Id
+}
+
+// migrateCatalogType copies the schema and entries of the old type onto the new one,
+// points any references in our other catalog types at the copied entries, and finally
+// removes the old type.
+//
+// Entries can't keep their IDs when moving type, so references made by ID are rewritten
+// to the ID of the copy. References by external ID or alias work as before.
+//
+// Once copied, we record the migration on the new type so that if the old type can't be
+// removed yet, later syncs don't copy its entries again.
+//
+// It returns the IDs of the catalog types whose schema it changed.
+func migrateCatalogType(ctx context.Context, logger kitlog.Logger, cl *client.ClientWithResponses, migration typeMigration, to *client.CatalogTypeV3, existingCatalogTypes, allCatalogTypes []client.CatalogTypeV3, pageSize int) ([]string, error) {
+	from := migration.From
+	logger = kitlog.With(logger, "from_type_name", from.TypeName, "to_type_name", to.TypeName)
+	entriesClient := reconcile.EntriesClientFromClient(cl)
+
+	// We can only change the types we manage, so if anything else references the old type
+	// we must leave it in place.
+	blockers := []string{}
+	for _, catalogType := range allCatalogTypes {
+		_, managed := lo.Find(existingCatalogTypes, func(existing client.CatalogTypeV3) bool {
+			return existing.Id == catalogType.Id
+		})
+		if !managed && len(referencingAttributes(catalogType, from.TypeName)) > 0 {
+			blockers = append(blockers, catalogType.TypeName)
+		}
+	}
+
+	affected := []string{}
+	if isMigrated(from, to) {
+		logger.Log("msg", "entries already copied to new catalog type, skipping to removing the old type")
+	} else {
+		var err error
+		affected, err = copyCatalogType(ctx, logger, cl, entriesClient, from, to, existingCatalogTypes, pageSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(blockers) > 0 {
+		logger.Log("msg", "old catalog type is referenced by types we don't manage, not removing it",
+			"referenced_by", fmt.Sprintf("%v", blockers))
+		OUT("    ⚠ Not removing %s, as it is still referenced by %v", from.TypeName, blockers)
+		return affected, nil
+	}
+
+	logger.Log("msg", "removing old catalog type", "catalog_type_id", from.Id)
+	if _, err := cl.CatalogV3DestroyTypeWithResponse(ctx, from.Id); err != nil {
+		return nil, errors.Wrap(err, "removing old catalog type")
+	}
+	OUT("    ⌫ %s", from.TypeName)
+
+	return affected, nil
+}
+
+// copyCatalogType does the work of a migration up until removing the old type, returning
+// the IDs of the catalog types whose schema it changed.
+func copyCatalogType(ctx context.Context, logger kitlog.Logger, cl *client.ClientWithResponses, entriesClient reconcile.EntriesClient, from client.CatalogTypeV3, to *client.CatalogTypeV3, existingCatalogTypes []client.CatalogTypeV3, pageSize int) ([]string, error) {
+	// Copy the old schema onto the new type, so there's somewhere to put the values of
+	// every attribute, including those only ever set from the dashboard. The schema sync
+	// that follows removes any the output doesn't declare, which the destructive schema
+	// check has already refused unless allowed.
+	attributes := lo.Map(to.Schema.Attributes, func(attr client.CatalogTypeAttributeV3, _ int) client.CatalogTypeAttributePayloadV3 {
+		return attributeToPayload(attr)
+	})
+	copiedAttributeIDs, selfReferences := []string{}, []string{}
+	for _, attr := range from.Schema.Attributes {
+		if attr.BacklinkAttribute != nil || attr.Path != nil {
+			continue // derived, so they'll be recreated from config
+		}
+		if attr.Type == from.TypeName {
+			attr.Type = to.TypeName
+			selfReferences = append(selfReferences, attr.Id)
+		} else {
+			copiedAttributeIDs = append(copiedAttributeIDs, attr.Id)
+		}
+
+		_, exists := lo.Find(attributes, func(existing client.CatalogTypeAttributePayloadV3) bool {
+			return lo.FromPtr(existing.Id) == attr.Id
+		})
+		if !exists {
+			attributes = append(attributes, attributeToPayload(attr))
+		}
+	}
+
+	logger.Log("msg", "copying schema to new catalog type")
+	_, err := cl.CatalogV3UpdateTypeSchemaWithResponse(ctx, to.Id, client.CatalogV3UpdateTypeSchemaJSONRequestBody{
+		Version:    to.Schema.Version,
+		Attributes: attributes,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "copying schema to new catalog type")
+	}
+
+	// Self-references can only be set once every entry has been copied, as they may point
+	// at entries we haven't copied yet.
+	entries, entryIDs, err := reconcile.CopyEntries(ctx, logger, entriesClient, from.Id, to.Id, copiedAttributeIDs, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	OUT("    ✔ Copied %d entries", len(entries))
+	if len(selfReferences) > 0 {
+		if err := reconcile.RewriteReferences(ctx, logger, entriesClient, to.Id, entries, selfReferences, entryIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	affected := []string{to.Id}
+	for _, catalogType := range existingCatalogTypes {
+		if catalogType.Id == from.Id || catalogType.Id == to.Id {
+			continue
+		}
+		if len(referencingAttributes(catalogType, from.TypeName)) == 0 {
+			continue
+		}
+
+		// Earlier migrations in this sync may have changed the schema since we listed it,
+		// so reload the type to get its current version.
+		result, err := cl.CatalogV3ShowTypeWithResponse(ctx, catalogType.Id)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("reloading %s", catalogType.TypeName))
+		}
+		catalogType := result.JSON200.CatalogType
+		attributeIDs := referencingAttributes(catalogType, from.TypeName)
+		if len(attributeIDs) == 0 {
+			continue
+		}
+
+		// Changing the attribute's type clears its values, so take a snapshot first.
+		_, snapshot, err := reconcile.GetEntries(ctx, cl, catalogType.Id, pageSize)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("listing entries of %s", catalogType.TypeName))
+		}
+
+		logger.Log("msg", "pointing references at new catalog type", "catalog_type_id", catalogType.Id, "attribute_ids", fmt.Sprintf("%v", attributeIDs))
+		_, err = cl.CatalogV3UpdateTypeSchemaWithResponse(ctx, catalogType.Id, client.CatalogV3UpdateTypeSchemaJSONRequestBody{
+			Version: catalogType.Schema.Version,
+			Attributes: lo.Map(catalogType.Schema.Attributes, func(attr client.CatalogTypeAttributeV3, _ int) client.CatalogTypeAttributePayloadV3 {
+				if lo.Contains(attributeIDs, attr.Id) {
+					attr.Type = to.TypeName
+				}
+
+				return attributeToPayload(attr)
+			}),
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("updating references in %s", catalogType.TypeName))
+		}
+
+		if err := reconcile.RewriteReferences(ctx, logger, entriesClient, catalogType.Id, snapshot, attributeIDs, entryIDs); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("updating references in %s", catalogType.TypeName))
+		}
+
+		OUT("    ✔ Updated references in %s", catalogType.TypeName)
+		affected = append(affected, catalogType.Id)
+	}
+
+	// Record that we've finished copying, so we never do it twice.
+	annotations := map[string]string{}
+	for key, value := range to.Annotations {
+		annotations[key] = value
+	}
+	annotations[AnnotationMigratedFrom] = from.Id
+	if err := entriesClient.UpdateTypeAnnotations(ctx, to, annotations); err != nil {
+		return nil, errors.Wrap(err, "recording migration on new catalog type")
+	}
+
+	return affected, nil
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/samber/lo"
+)
+
+// getPartitions parses the partitions annotation of a catalog type, returning a map of
+// sync ID to the attribute IDs that importer declared.
+func getPartitions(catalogType *client.CatalogTypeV3) map[string][]string {
+	partitions := map[string][]string{}
+	if value, ok := catalogType.Annotations[AnnotationPartitions]; ok {
+		_ = json.Unmarshal([]byte(value), &partitions) // if this is corrupt, start over
+	}
+
+	return partitions
+}
+
+// isPartitioned returns true if the catalog type has been declared as partitioned, and
+// so may be shared between several importers.
+func isPartitioned(catalogType *client.CatalogTypeV3) bool {
+	_, ok := catalogType.Annotations[AnnotationPartitions]
+	return ok
+}
+
+// hasOtherPartitions returns true if importers other than this sync ID have pushed
+// entries into the catalog type.
+func hasOtherPartitions(catalogType *client.CatalogTypeV3, syncID string) bool {
+	for otherSyncID := range getPartitions(catalogType) {
+		if otherSyncID != syncID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkUnpartitionedOutputs refuses to sync outputs that don't declare a partition into
+// a catalog type other importers have registered partitions on. Without a partition an
+// output owns every entry in its type, so would delete the entries of the others.
+func checkUnpartitionedOutputs(outputs []*output.Output, catalogTypes []client.CatalogTypeV3, syncID string) error {
+	for _, op := range outputs {
+		if op.Partition != nil {
+			continue
+		}
+
+		catalogType, ok := lo.Find(catalogTypes, func(catalogType client.CatalogTypeV3) bool {
+			return catalogType.TypeName == op.TypeName
+		})
+		if !ok || !hasOtherPartitions(&catalogType, syncID) {
+			continue
+		}
+
+		others := lo.Without(lo.Keys(getPartitions(&catalogType)), syncID)
+		sort.Strings(others)
+
+		return fmt.Errorf("catalog type %s is shared with other importers (sync IDs %s), so output must declare a partition to avoid deleting their entries",
+			op.TypeName, strings.Join(others, ", "))
+	}
+
+	return nil
+}
+
+// getPartitionedAnnotations builds the annotations for a catalog type that is shared
+// between importers.
+//
+// Unlike getAnnotations, we preserve the existing owner of the type and record the
+// attributes we declare alongside those of the other importers.
+func getPartitionedAnnotations(catalogType *client.CatalogTypeV3, syncID string, model *output.CatalogTypeModel) map[string]string {
+	annotations := map[string]string{}
+	for key, value := range catalogType.Annotations {
+		annotations[key] = value
+	}
+	if _, ok := annotations[AnnotationSyncID]; !ok {
+		annotations[AnnotationSyncID] = syncID
+	}
+	annotations[AnnotationLastSyncAt] = time.Now().Format(time.RFC3339)
+	annotations[AnnotationVersion] = Version()
+
+	partitions := getPartitions(catalogType)
+	partitions[syncID] = lo.Map(model.Attributes, func(attr client.CatalogTypeAttributePayloadV3, _ int) string {
+		return lo.FromPtr(attr.Id)
+	})
+	sort.Strings(partitions[syncID])
+
+	partitionsJSON, _ := json.Marshal(partitions)
+	annotations[AnnotationPartitions] = string(partitionsJSON)
+
+	return annotations
+}
+
+// mergePartitionedAttributes returns the schema for a shared catalog type, which is our
+// attributes plus any that other importers sharing the type have declared.
+//
+// Where two importers declare the same attribute, ours wins.
+func mergePartitionedAttributes(catalogType *client.CatalogTypeV3, syncID string, attributes []client.CatalogTypeAttributePayloadV3) []client.CatalogTypeAttributePayloadV3 {
+	declared := map[string]bool{}
+	for otherSyncID, attributeIDs := range getPartitions(catalogType) {
+		if otherSyncID == syncID {
+			continue
+		}
+		for _, attributeID := range attributeIDs {
+			declared[attributeID] = true
+		}
+	}
+
+	merged := append([]client.CatalogTypeAttributePayloadV3{}, attributes...)
+	for _, existing := range catalogType.Schema.Attributes {
+		if !declared[existing.Id] {
+			continue
+		}
+
+		_, ours := lo.Find(attributes, func(attr client.CatalogTypeAttributePayloadV3) bool {
+			return lo.FromPtr(attr.Id) == existing.Id
+		})
+		if ours {
+			continue
+		}
+
+		merged = append(merged, attributeToPayload(existing))
+	}
+
+	return merged
+}
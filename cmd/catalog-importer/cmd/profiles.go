@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/fatih/color"
+	kitlog "github.com/go-kit/log"
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/profile"
+	"github.com/pkg/errors"
+	"github.com/rodaine/table"
+	"github.com/samber/lo"
+)
+
+// bindAPIFlags declares the flags every command that talks to incident.io needs. A
+// profile can provide both, see applyProfile.
+func bindAPIFlags(cmd *kingpin.CmdClause, endpoint, key *string) {
+	cmd.Flag("api-endpoint", "Endpoint of the incident.io API").
+		Default("https://api.incident.io").
+		Envar("INCIDENT_ENDPOINT").
+		StringVar(endpoint)
+	cmd.Flag("api-key", "API key for incident.io").
+		Envar("INCIDENT_API_KEY").
+		StringVar(key)
+}
+
+// applyProfile adds the flags from the selected profile to the command-line arguments,
+// so they are parsed as if the user had passed them.
+//
+// Flags the user passed explicitly win over the profile, but the profile wins over
+// environment variables: choosing a profile shouldn't be undone by an INCIDENT_API_KEY
+// left in your shell.
+func applyProfile(ctx context.Context, args []string) ([]string, error) {
+	// Find which command we're running and what flags were given, without setting any
+	// values. If the arguments don't parse, leave it to the real parse to complain.
+	parsed, err := app.ParseContext(args)
+	if err != nil || parsed.SelectedCommand == nil {
+		return args, nil
+	}
+
+	name, filename := os.Getenv(profileEnvar), os.Getenv(profilesFileEnvar)
+	if filename == "" {
+		filename = profile.DefaultPath()
+	}
+	given := map[string]bool{}
+	for _, element := range parsed.Elements {
+		flag, ok := element.Clause.(*kingpin.FlagClause)
+		if !ok {
+			continue
+		}
+		given[flag.Model().Name] = true
+
+		switch flag {
+		case profileFlag:
+			name = *element.Value
+		case profilesFileFlag:
+			filename = *element.Value
+		}
+	}
+
+	// The profiles commands check the profiles themselves, so shouldn't apply one.
+	if strings.HasPrefix(parsed.SelectedCommand.FullCommand(), profilesCmd.FullCommand()) {
+		return args, nil
+	}
+
+	file, err := profile.Load(filename)
+	if err != nil {
+		return nil, err
+	}
+	selected, err := file.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if selected == nil {
+		return args, nil
+	}
+
+	values := lo.Assign(selected.Flags)
+	if selected.APIEndpoint != "" {
+		values["api-endpoint"] = selected.APIEndpoint
+	}
+	// Environments set their own sync ID suffix, which takes precedence over the profile's.
+	usesEnvironments := given["env"] || given["all-envs"] || values["env"] != "" || values["all-envs"] == "true"
+	if selected.SyncIDSuffix != "" && !usesEnvironments {
+		values["sync-id-suffix"] = selected.SyncIDSuffix
+	}
+
+	// Only resolve the key if we'll use it, as it might mean running a command.
+	if parsed.SelectedCommand.GetFlag("api-key") != nil && !given["api-key"] {
+		key, err := selected.APIKey.Resolve(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading API key for profile")
+		}
+		values["api-key"] = key
+	}
+
+	// Profiles are shared between commands, so flags the command doesn't have are ignored.
+	var profileArgs []string
+	for _, flagName := range lo.Keys(values) {
+		flag := parsed.SelectedCommand.GetFlag(flagName)
+		if flag == nil {
+			flag = app.GetFlag(flagName)
+		}
+		if flag == nil || given[flagName] {
+			continue
+		}
+
+		switch value := values[flagName]; {
+		case flag.Model().IsBoolFlag() && value == "true":
+			profileArgs = append(profileArgs, "--"+flagName)
+		case flag.Model().IsBoolFlag() && value == "false":
+			profileArgs = append(profileArgs, "--no-"+flagName)
+		default:
+			profileArgs = append(profileArgs, fmt.Sprintf("--%s=%s", flagName, value))
+		}
+	}
+	sort.Strings(profileArgs)
+
+	// Anything after -- is an argument, so our flags must go before it.
+	idx := lo.IndexOf(args, "--")
+	if idx < 0 {
+		idx = len(args)
+	}
+
+	return append(append(append([]string{}, args[:idx]...), profileArgs...), args[idx:]...), nil
+}
+
+type ProfilesListOptions struct{}
+
+func (opt *ProfilesListOptions) Bind(cmd *kingpin.CmdClause) *ProfilesListOptions {
+	return opt
+}
+
+func (opt *ProfilesListOptions) Run(ctx context.Context, logger kitlog.Logger) error {
+	file, err := profile.Load(*profilesFile)
+	if err != nil {
+		return err
+	}
+	if len(file.Profiles) == 0 {
+		OUT("No profiles found in %s", *profilesFile)
+		return nil
+	}
+
+	headerFmt := color.New(color.Bold).SprintfFunc()
+	columnFmt := color.New(color.FgYellow).SprintfFunc()
+
+	tbl := table.New("Name", "API endpoint", "API key", "Sync ID suffix", "Flags")
+	tbl.WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt)
+
+	for _, name := range file.Names() {
+		p := file.Profiles[name]
+
+		endpoint := p.APIEndpoint
+		if endpoint == "" {
+			endpoint = "https://api.incident.io"
+		}
+		flags := lo.Map(lo.Keys(p.Flags), func(flag string, _ int) string {
+			return fmt.Sprintf("--%s=%s", flag, p.Flags[flag])
+		})
+		sort.Strings(flags)
+		if name == file.Default {
+			name += " (default)"
+		}
+
+		tbl.AddRow(name, endpoint, p.APIKey.String(), p.SyncIDSuffix, strings.Join(flags, " "))
+	}
+
+	tbl.Print()
+
+	return nil
+}
+
+type ProfilesTestOptions struct {
+	Names   []string
+	Timeout time.Duration
+}
+
+func (opt *ProfilesTestOptions) Bind(cmd *kingpin.CmdClause) *ProfilesTestOptions {
+	cmd.Arg("profile", "Profiles to test, defaulting to all of them").
+		StringsVar(&opt.Names)
+	cmd.Flag("timeout", "How long to wait for each profile to respond, as the client retries failed requests").
+		Default("30s").
+		DurationVar(&opt.Timeout)
+
+	return opt
+}
+
+func (opt *ProfilesTestOptions) Run(ctx context.Context, logger kitlog.Logger) error {
+	file, err := profile.Load(*profilesFile)
+	if err != nil {
+		return err
+	}
+
+	names := opt.Names
+	if len(names) == 0 {
+		names = file.Names()
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no profiles found in %s", *profilesFile)
+	}
+
+	var failed []string
+	for _, name := range names {
+		p, err := file.Get(name)
+		if err != nil {
+			return err
+		}
+
+		identity, err := testProfile(ctx, logger, p, opt.Timeout)
+		if err != nil {
+			ALWAYS_OUT("%s", color.RedString("✖ %s: %s", name, err))
+			failed = append(failed, name)
+			continue
+		}
+
+		roles := lo.Map(identity.Roles, func(role client.IdentityV1Roles, _ int) string {
+			return string(role)
+		})
+		OUT("%s: %s (%s, roles=%s)", color.GreenString("✔ %s", name),
+			identity.Name, identity.DashboardUrl, strings.Join(roles, ","))
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("profiles failed: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// testProfile checks the profile's API key works against its endpoint, returning who it
+// identifies as.
+func testProfile(ctx context.Context, logger kitlog.Logger, p *profile.Profile, timeout time.Duration) (*client.IdentityV1, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	key, err := p.APIKey.Resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := p.APIEndpoint
+	if endpoint == "" {
+		endpoint = "https://api.incident.io"
+	}
+
+	cl, err := client.New(ctx, key, endpoint, Version(), logger, client.WithReadOnly())
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := cl.UtilitiesV1IdentityWithResponse(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result.JSON200.Identity, nil
+}
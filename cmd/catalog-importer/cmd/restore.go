@@ -0,0 +1,301 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	kitlog "github.com/go-kit/log"
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/lock"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+type RestoreOptions struct {
+	From                      string
+	APIEndpoint               string
+	APIKey                    string
+	DryRun                    bool
+	CatalogEntriesAPIPageSize int
+	Lock                      lock.Options
+}
+
+func (opt *RestoreOptions) Bind(cmd *kingpin.CmdClause) *RestoreOptions {
+	cmd.Flag("from", "Snapshot file to restore from, as written by the snapshot command").
+		Required().
+		StringVar(&opt.From)
+	bindAPIFlags(cmd, &opt.APIEndpoint, &opt.APIKey)
+	cmd.Flag("dry-run", "Only calculate the changes needed and print the diff, don't actually make changes").
+		BoolVar(&opt.DryRun)
+	cmd.Flag("catalog-entries-api-page-size", "The page size to use when listing catalog entries from the API").
+		Default("250").
+		IntVar(&opt.CatalogEntriesAPIPageSize)
+	bindLockFlags(cmd, &opt.Lock)
+
+	return opt
+}
+
+func (opt *RestoreOptions) Run(ctx context.Context, logger kitlog.Logger) error {
+	snapshot, err := reconcile.LoadSnapshot(opt.From)
+	if err != nil {
+		return err
+	}
+	OUT("✔ Loaded snapshot of %d catalog types with %d entries (sync_id=%s, taken_at=%s)",
+		len(snapshot.CatalogTypes), snapshot.EntryCount(), snapshot.SyncID, snapshot.TakenAt.Format(time.RFC3339))
+
+	// Restoring reconciles entries by external ID, which would delete any live entries
+	// without one rather than restore them. Refuse before changing anything.
+	for _, snapshotted := range snapshot.CatalogTypes {
+		if count := snapshotted.EntriesWithoutExternalID(); count > 0 {
+			return fmt.Errorf("catalog type %s has %d entries without an external ID, which can't be restored, refusing to restore it",
+				snapshotted.CatalogType.TypeName, count)
+		}
+	}
+
+	if opt.APIKey == "" {
+		return fmt.Errorf("no API key provided as --api-key or in INCIDENT_API_KEY")
+	}
+
+	cl, err := client.New(ctx, opt.APIKey, opt.APIEndpoint, Version(), logger)
+	if err != nil {
+		return err
+	}
+
+	// A restore makes the same sort of changes as a sync, so must not overlap with one.
+	// As in a sync, we take the lock before looking at the catalog.
+	if !opt.DryRun {
+		syncLock, err := acquireSyncLock(ctx, logger, cl, snapshot.SyncID, opt.Lock)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			// Our context may have been cancelled, but we still want to release the lock.
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			if err := syncLock.Release(ctx); err != nil {
+				logger.Log("msg", "failed to release sync lock, it will expire on its own", "error", err)
+			}
+		}()
+
+		// If we lose the lock, a sync may be running, so stop making changes.
+		var cancel context.CancelFunc
+		ctx, cancel = syncLock.Context(ctx)
+		defer cancel()
+	}
+
+	result, err := cl.CatalogV3ListTypesWithResponse(ctx)
+	if err != nil {
+		return errors.Wrap(err, "listing catalog types")
+	}
+	OUT("✔ Connected to incident.io API (%s)", opt.APIEndpoint)
+
+	// Find the live version of each snapshotted type, refusing to touch any that now
+	// belong to someone else.
+	catalogTypes := map[string]*client.CatalogTypeV3{} // by type name
+	for _, snapshotted := range snapshot.CatalogTypes {
+		live, ok := lo.Find(result.JSON200.CatalogTypes, func(catalogType client.CatalogTypeV3) bool {
+			return catalogType.TypeName == snapshotted.CatalogType.TypeName
+		})
+		if !ok {
+			continue
+		}
+		if syncID := live.Annotations[AnnotationSyncID]; syncID != snapshot.SyncID {
+			return fmt.Errorf("catalog type %s is no longer managed by sync ID '%s' (sync_id='%s'), refusing to restore it",
+				live.TypeName, snapshot.SyncID, syncID)
+		}
+
+		catalogTypes[live.TypeName] = &live
+	}
+
+	if opt.DryRun {
+		OUT("\n↻ Dry run, printing the changes a restore would make...")
+		entriesClient := newEntriesClient(cl, result.JSON200.CatalogTypes, true)
+		liveEntryIDs, err := opt.listLiveEntryIDs(ctx, entriesClient, catalogTypes)
+		if err != nil {
+			return err
+		}
+		for _, snapshotted := range snapshot.CatalogTypes {
+			catalogType, ok := catalogTypes[snapshotted.CatalogType.TypeName]
+			if !ok {
+				OUT("\n  ↻ %s (would be created with %d entries)", snapshotted.CatalogType.TypeName, len(snapshotted.Entries))
+				continue
+			}
+
+			OUT("\n  ↻ %s (id=%s)", catalogType.TypeName, catalogType.Id)
+			DIFF("    ", catalogType.Schema.Attributes, snapshotted.CatalogType.Schema.Attributes)
+			if err := opt.restoreEntries(ctx, logger, entriesClient, snapshot, snapshotted, catalogType, true, liveEntryIDs); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	OUT("\n↻ Restoring catalog types...")
+	for _, snapshotted := range snapshot.CatalogTypes {
+		restored, err := restoreCatalogType(ctx, logger, cl, snapshotted.CatalogType, catalogTypes[snapshotted.CatalogType.TypeName])
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("restoring catalog type %s", snapshotted.CatalogType.TypeName))
+		}
+
+		catalogTypes[restored.TypeName] = restored
+		OUT("  ✔ %s (id=%s)", restored.TypeName, restored.Id)
+	}
+
+	// As in a sync, derived attributes can reference attributes of other types that don't
+	// exist yet, so we restore the schemas without new derived attributes first.
+	OUT("\n↻ Restoring catalog type schemas...")
+	for _, withDerived := range []bool{false, true} {
+		for _, snapshotted := range snapshot.CatalogTypes {
+			catalogType := catalogTypes[snapshotted.CatalogType.TypeName]
+
+			attributes := []client.CatalogTypeAttributePayloadV3{}
+			for _, attr := range snapshotted.CatalogType.Schema.Attributes {
+				_, inCurrentSchema := lo.Find(catalogType.Schema.Attributes, func(existing client.CatalogTypeAttributeV3) bool {
+					return existing.Id == attr.Id
+				})
+				isDerived := attr.BacklinkAttribute != nil || attr.Path != nil
+				if withDerived || !isDerived || inCurrentSchema {
+					attributes = append(attributes, attributeToPayload(attr))
+				}
+			}
+
+			logger.Log("msg", "restoring catalog type schema", "catalog_type_id", catalogType.Id, "with_derived", withDerived)
+			result, err := cl.CatalogV3UpdateTypeSchemaWithResponse(ctx, catalogType.Id, client.CatalogV3UpdateTypeSchemaJSONRequestBody{
+				Version:    catalogType.Schema.Version,
+				Attributes: attributes,
+			})
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("restoring schema of %s", catalogType.TypeName))
+			}
+
+			catalogTypes[catalogType.TypeName] = &result.JSON200.CatalogType
+			if withDerived {
+				OUT("  ✔ %s (id=%s)", catalogType.TypeName, catalogType.Id)
+			}
+		}
+	}
+
+	// Entries can reference entries of other snapshotted types, which may need recreating
+	// first. So we restore everything but those references, then fill them in once all
+	// entries exist.
+	OUT("\n↻ Restoring catalog entries...")
+	entriesClient := reconcile.EntriesClientFromClient(cl)
+	liveEntryIDs := map[string]bool{}
+	for _, withReferences := range []bool{false, true} {
+		if withReferences {
+			liveEntryIDs, err = opt.listLiveEntryIDs(ctx, entriesClient, catalogTypes)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, snapshotted := range snapshot.CatalogTypes {
+			catalogType := catalogTypes[snapshotted.CatalogType.TypeName]
+			if err := opt.restoreEntries(ctx, logger, entriesClient, snapshot, snapshotted, catalogType, withReferences, liveEntryIDs); err != nil {
+				return err
+			}
+			if withReferences {
+				OUT("  ✔ %s (%d entries)", catalogType.TypeName, len(snapshotted.Entries))
+			}
+		}
+	}
+
+	OUT("\n✔ Restored %d catalog types from %s", len(snapshot.CatalogTypes), opt.From)
+	return nil
+}
+
+// restoreEntries reconciles the entries of a catalog type against the snapshot.
+func (opt *RestoreOptions) restoreEntries(ctx context.Context, logger kitlog.Logger, entriesClient reconcile.EntriesClient, snapshot *reconcile.Snapshot, snapshotted reconcile.SnapshotCatalogType, catalogType *client.CatalogTypeV3, withReferences bool, liveEntryIDs map[string]bool) error {
+	outputType := snapshot.Output(snapshotted, withReferences)
+	entryModels, _ := snapshot.EntryModels(snapshotted, outputType, liveEntryIDs)
+
+	logger.Log("msg", "restoring catalog entries", "catalog_type_id", catalogType.Id, "with_references", withReferences)
+	err := reconcile.Entries(ctx, logger, entriesClient, outputType, catalogType, entryModels, nil, opt.CatalogEntriesAPIPageSize)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("restoring entries of %s", catalogType.TypeName))
+	}
+
+	return nil
+}
+
+// listLiveEntryIDs returns the IDs of every entry that currently exists in the given
+// catalog types. References to these entries can be restored as they are, while those to
+// entries we've had to recreate must be made by external ID.
+func (opt *RestoreOptions) listLiveEntryIDs(ctx context.Context, entriesClient reconcile.EntriesClient, catalogTypes map[string]*client.CatalogTypeV3) (map[string]bool, error) {
+	liveEntryIDs := map[string]bool{}
+	for _, catalogType := range catalogTypes {
+		_, entries, err := entriesClient.GetEntries(ctx, catalogType.Id, opt.CatalogEntriesAPIPageSize)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("listing entries of %s", catalogType.TypeName))
+		}
+		for _, entry := range entries {
+			liveEntryIDs[entry.Id] = true
+		}
+	}
+
+	return liveEntryIDs, nil
+}
+
+// restoreCatalogType puts the catalog type back as it was in the snapshot, creating it if
+// it no longer exists. Schemas are restored separately.
+func restoreCatalogType(ctx context.Context, logger kitlog.Logger, cl *client.ClientWithResponses, snapshotted client.CatalogTypeV3, live *client.CatalogTypeV3) (*client.CatalogTypeV3, error) {
+	var color, icon *string
+	if snapshotted.Color != "" {
+		color = lo.ToPtr(string(snapshotted.Color))
+	}
+	if snapshotted.Icon != "" {
+		icon = lo.ToPtr(string(snapshotted.Icon))
+	}
+	categories := lo.Map(snapshotted.Categories, func(category client.CatalogTypeV3Categories, _ int) string {
+		return string(category)
+	})
+
+	if live == nil {
+		logger.Log("msg", "catalog type no longer exists, creating", "catalog_type_name", snapshotted.TypeName)
+		result, err := cl.CatalogV3CreateTypeWithResponse(ctx, client.CatalogCreateTypePayloadV3{
+			Name:        snapshotted.Name,
+			Description: snapshotted.Description,
+			Ranked:      lo.ToPtr(snapshotted.Ranked),
+			TypeName:    lo.ToPtr(snapshotted.TypeName),
+			Categories: lo.ToPtr(lo.Map(categories, func(category string, _ int) client.CatalogCreateTypePayloadV3Categories {
+				return client.CatalogCreateTypePayloadV3Categories(category)
+			})),
+			Annotations:         lo.ToPtr(snapshotted.Annotations),
+			Color:               (*client.CatalogCreateTypePayloadV3Color)(color),
+			Icon:                (*client.CatalogCreateTypePayloadV3Icon)(icon),
+			UseNameAsIdentifier: lo.ToPtr(snapshotted.UseNameAsIdentifier),
+			SourceRepoUrl:       snapshotted.SourceRepoUrl,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "creating catalog type")
+		}
+
+		return &result.JSON201.CatalogType, nil
+	}
+
+	logger.Log("msg", "restoring catalog type", "catalog_type_id", live.Id)
+	result, err := cl.CatalogV3UpdateTypeWithResponse(ctx, live.Id, client.CatalogV3UpdateTypeJSONRequestBody{
+		Name:        snapshotted.Name,
+		Description: snapshotted.Description,
+		Ranked:      lo.ToPtr(snapshotted.Ranked),
+		Categories: lo.ToPtr(lo.Map(categories, func(category string, _ int) client.CatalogUpdateTypePayloadV3Categories {
+			return client.CatalogUpdateTypePayloadV3Categories(category)
+		})),
+		Annotations:         lo.ToPtr(snapshotted.Annotations),
+		Color:               (*client.CatalogUpdateTypePayloadV3Color)(color),
+		Icon:                (*client.CatalogUpdateTypePayloadV3Icon)(icon),
+		UseNameAsIdentifier: lo.ToPtr(snapshotted.UseNameAsIdentifier),
+		SourceRepoUrl:       snapshotted.SourceRepoUrl,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "updating catalog type")
+	}
+
+	return &result.JSON200.CatalogType, nil
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alecthomas/kingpin/v2"
+	kitlog "github.com/go-kit/log"
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+type SnapshotOptions struct {
+	ConfigFile                string
+	SyncID                    string
+	SyncIDSuffix              string
+	APIEndpoint               string
+	APIKey                    string
+	Out                       string
+	CatalogEntriesAPIPageSize int
+}
+
+func (opt *SnapshotOptions) Bind(cmd *kingpin.CmdClause) *SnapshotOptions {
+	cmd.Flag("config", "Config file in either Jsonnet, YAML or JSON (e.g. importer.jsonnet), used to find the sync ID").
+		StringVar(&opt.ConfigFile)
+	cmd.Flag("sync-id", "Sync ID whose catalog types should be snapshotted, if not using --config").
+		StringVar(&opt.SyncID)
+	cmd.Flag("sync-id-suffix", "Append this to the sync_id from --config, such as when a profile points at a different workspace (e.g. -staging)").
+		StringVar(&opt.SyncIDSuffix)
+	bindAPIFlags(cmd, &opt.APIEndpoint, &opt.APIKey)
+	cmd.Flag("out", "File to write the snapshot to (e.g. snapshot.json)").
+		Required().
+		StringVar(&opt.Out)
+	cmd.Flag("catalog-entries-api-page-size", "The page size to use when listing catalog entries from the API").
+		Default("250").
+		IntVar(&opt.CatalogEntriesAPIPageSize)
+
+	return opt
+}
+
+func (opt *SnapshotOptions) Run(ctx context.Context, logger kitlog.Logger) error {
+	syncID := opt.SyncID
+	if syncID == "" {
+		cfg, err := loadConfigOrError(ctx, opt.ConfigFile)
+		if err != nil {
+			return err
+		}
+
+		syncID = cfg.SyncID + opt.SyncIDSuffix
+	}
+
+	if opt.APIKey == "" {
+		return fmt.Errorf("no API key provided as --api-key or in INCIDENT_API_KEY")
+	}
+
+	cl, err := client.New(ctx, opt.APIKey, opt.APIEndpoint, Version(), logger)
+	if err != nil {
+		return err
+	}
+
+	result, err := cl.CatalogV3ListTypesWithResponse(ctx)
+	if err != nil {
+		return errors.Wrap(err, "listing catalog types")
+	}
+	OUT("✔ Connected to incident.io API (%s)", opt.APIEndpoint)
+
+	return takeSnapshot(ctx, logger, cl, syncID, managedCatalogTypes(result.JSON200.CatalogTypes, syncID), opt.CatalogEntriesAPIPageSize, opt.Out)
+}
+
+// managedCatalogTypes returns the catalog types that belong to the given sync ID.
+func managedCatalogTypes(catalogTypes []client.CatalogTypeV3, syncID string) []client.CatalogTypeV3 {
+	return lo.Filter(catalogTypes, func(catalogType client.CatalogTypeV3, _ int) bool {
+		return catalogType.Annotations[AnnotationSyncID] == syncID
+	})
+}
+
+// takeSnapshot writes a snapshot of the given catalog types and all their entries to a
+// file, so they can be restored with the restore command.
+func takeSnapshot(ctx context.Context, logger kitlog.Logger, cl *client.ClientWithResponses, syncID string, catalogTypes []client.CatalogTypeV3, pageSize int, filename string) error {
+	OUT("\n↻ Taking snapshot of %d catalog types to %s...", len(catalogTypes), filename)
+	snapshot, err := reconcile.TakeSnapshot(ctx, logger, reconcile.EntriesClientFromClient(cl), syncID, catalogTypes, pageSize)
+	if err != nil {
+		return errors.Wrap(err, "taking snapshot")
+	}
+	for _, catalogType := range snapshot.CatalogTypes {
+		OUT("  ✔ %s (%d entries)", catalogType.CatalogType.TypeName, len(catalogType.Entries))
+	}
+
+	if err := snapshot.Save(filename); err != nil {
+		return err
+	}
+	OUT("✔ Saved snapshot with %d entries to %s", snapshot.EntryCount(), filename)
+
+	return nil
+}
@@ -2,9 +2,11 @@ package cmd
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -19,6 +21,7 @@ import (
 
 	"github.com/incident-io/catalog-importer/v2/client"
 	"github.com/incident-io/catalog-importer/v2/config"
+	"github.com/incident-io/catalog-importer/v2/lock"
 	"github.com/incident-io/catalog-importer/v2/output"
 	"github.com/incident-io/catalog-importer/v2/reconcile"
 	"github.com/incident-io/catalog-importer/v2/source"
@@ -36,18 +39,24 @@ type SyncOptions struct {
 	SourceRepoUrl             string
 	CatalogEntriesAPIPageSize int
 	NoProgress                bool
+	Resume                    bool
+	CheckpointFile            string
+	AllowSchemaDestruction    bool
+	Lock                      lock.Options
+	SnapshotFile              string
+	StateFile                 string
+	Env                       string
+	AllEnvs                   bool
+	SyncIDSuffix              string
+
+	// sourceCache is shared between environments, so we only load each source once.
+	sourceCache *sourceCache
 }
 
 func (opt *SyncOptions) Bind(cmd *kingpin.CmdClause) *SyncOptions {
 	cmd.Flag("config", "Config file in either Jsonnet, YAML or JSON (e.g. importer.jsonnet)").
 		StringVar(&opt.ConfigFile)
-	cmd.Flag("api-endpoint", "Endpoint of the incident.io API").
-		Default("https://api.incident.io").
-		Envar("INCIDENT_ENDPOINT").
-		StringVar(&opt.APIEndpoint)
-	cmd.Flag("api-key", "API key for incident.io").
-		Envar("INCIDENT_API_KEY").
-		StringVar(&opt.APIKey)
+	bindAPIFlags(cmd, &opt.APIEndpoint, &opt.APIKey)
 	cmd.Flag("source-repo-url", "URL of repo where catalog is being managed").
 		Envar("SOURCE_REPO_URL").
 		StringVar(&opt.SourceRepoUrl)
@@ -63,23 +72,42 @@ func (opt *SyncOptions) Bind(cmd *kingpin.CmdClause) *SyncOptions {
 		BoolVar(&opt.Prune)
 	cmd.Flag("allow-delete-all", "Allow removing all entries from a catalog entry").
 		BoolVar(&opt.AllowDeleteAll)
+	cmd.Flag("allow-schema-destruction", "Allow schema changes that lose attribute values, such as removing an attribute or changing its type").
+		BoolVar(&opt.AllowSchemaDestruction)
 	cmd.Flag("catalog-entries-api-page-size", "The page size to use when listing catalog entries from the API").
 		Envar("CATALOG_ENTRIES_API_PAGE_SIZE").
 		Default("250").
 		IntVar(&opt.CatalogEntriesAPIPageSize)
 	cmd.Flag("no-progress", "Disable progress bars (useful for cron jobs and output redirection)").
 		BoolVar(&opt.NoProgress)
+	cmd.Flag("resume", "Resume an interrupted sync from its checkpoint, skipping work that already completed").
+		BoolVar(&opt.Resume)
+	cmd.Flag("checkpoint-file", "Where to record sync progress, so an interrupted sync can be resumed with --resume ({sync_id} is replaced with the config's sync ID, empty to disable)").
+		Default(".catalog-importer-checkpoint.{sync_id}.json").
+		StringVar(&opt.CheckpointFile)
+	bindLockFlags(cmd, &opt.Lock)
+	cmd.Flag("snapshot-file", "Before making any changes, snapshot the catalog types managed by this sync ID to this file, so they can be put back with the restore command").
+		StringVar(&opt.SnapshotFile)
+	cmd.Flag("state-file", "Where to record what was last applied to each entry, which the drift command compares against ({sync_id} is replaced with the config's sync ID, empty to disable)").
+		Default(".catalog-importer-state.{sync_id}.json").
+		StringVar(&opt.StateFile)
+	cmd.Flag("env", "Sync into this environment from the config's environments (e.g. prod)").
+		StringVar(&opt.Env)
+	cmd.Flag("all-envs", "Sync into every environment in the config's environments, loading sources only once").
+		BoolVar(&opt.AllEnvs)
+	cmd.Flag("sync-id-suffix", "Append this to the config's sync_id, such as when a profile points at a different workspace (e.g. -staging)").
+		StringVar(&opt.SyncIDSuffix)
 
 	return opt
 }
 
 func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *config.Config) error {
-	if opt.Prune && opt.DryRun {
-		return errors.New("cannot use --dry-run with --prune")
-	}
 	if opt.Prune && len(opt.Targets) > 0 {
 		return errors.New("cannot use --targets with --prune")
 	}
+	if opt.Resume && opt.DryRun {
+		return errors.New("cannot use --dry-run with --resume")
+	}
 
 	// If you're dry-running, and you have set --quiet, you're going to have a bad
 	// time because the whole point of a dry run is to produce output!
@@ -88,6 +116,22 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 		OUT("WARNING: --quiet has been ignored because --dry-run is set")
 	}
 
+	if opt.Env != "" || opt.AllEnvs {
+		if cfg != nil {
+			return errors.New("environments can only be used when loading config with --config")
+		}
+		if opt.Env != "" && opt.AllEnvs {
+			return errors.New("cannot use --env with --all-envs")
+		}
+		// Environments set their own sync ID suffix, so another on top would sync into a
+		// sync ID that no environment declares. Profiles leave theirs out for environments.
+		if opt.SyncIDSuffix != "" {
+			return errors.New("cannot use --sync-id-suffix with --env or --all-envs, set sync_id_suffix on the environment instead")
+		}
+
+		return opt.runEnvironments(ctx, logger)
+	}
+
 	// Load config if it hasn't been provided.
 	if cfg == nil {
 		var err error
@@ -96,6 +140,9 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 			return err
 		}
 	}
+	if opt.SyncIDSuffix != "" {
+		cfg = cfg.WithSyncIDSuffix(opt.SyncIDSuffix)
+	}
 	{
 		if len(opt.Targets) > 0 {
 			OUT("⊕ Filtering config to targets (%s)", strings.Join(opt.Targets, ", "))
@@ -109,6 +156,36 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 		OUT("✔ Loaded config (%d pipelines, %d sources, %d outputs)", len(cfg.Pipelines), outputs, sources)
 	}
 
+	// Record our progress as we go, so an interrupted sync can be resumed. Dry-runs don't
+	// make any changes, so have nothing to checkpoint.
+	var checkpoint *reconcile.Checkpoint
+	if !opt.DryRun {
+		var err error
+		checkpoint, err = opt.loadCheckpoint(logger, cfg)
+		if err != nil {
+			return err
+		}
+
+		// If we exit early, flush whatever progress we made so it can be resumed. This is a
+		// no-op once the sync has completed and the checkpoint removed.
+		defer func() {
+			if err := checkpoint.Save(); err != nil {
+				logger.Log("msg", "failed to save checkpoint", "error", err)
+			}
+		}()
+	}
+
+	// Record what we apply to each entry, so the drift command can tell source changes
+	// apart from edits made in the catalog.
+	var state *reconcile.State
+	if !opt.DryRun && opt.StateFile != "" {
+		var err error
+		state, err = loadState(opt.StateFile, cfg.SyncID)
+		if err != nil {
+			return err
+		}
+	}
+
 	clientOptions := []client.ClientOption{}
 	if opt.DryRun {
 		OUT("⛨ --dry-run is set, building a read-only client")
@@ -121,6 +198,31 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 		return err
 	}
 
+	// Take the lock for our sync ID before we look at the catalog, so overlapping syncs
+	// can't interleave their changes, and anything we read is from after the last sync
+	// finished. Dry-runs don't change anything, so don't need it.
+	if !opt.DryRun {
+		syncLock, err := acquireSyncLock(ctx, logger, cl, cfg.SyncID, opt.Lock)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			// Our context may have been cancelled, but we still want to release the lock.
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			if err := syncLock.Release(ctx); err != nil {
+				logger.Log("msg", "failed to release sync lock, it will expire on its own", "error", err)
+			}
+		}()
+
+		// If we lose the lock, another sync may be running, so stop making changes.
+		var cancel context.CancelFunc
+		ctx, cancel = syncLock.Context(ctx)
+		defer cancel()
+	}
+
 	// Load existing catalog types
 	result, err := cl.CatalogV3ListTypesWithResponse(ctx)
 	if err != nil {
@@ -130,6 +232,7 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 
 	existingCatalogTypes := []client.CatalogTypeV3{}
 	unmanagedCatalogTypes := []client.CatalogTypeV3{}
+	sharedCatalogTypes := []client.CatalogTypeV3{}
 	for _, catalogType := range result.JSON200.CatalogTypes {
 		logger := kitlog.With(logger,
 			"catalog_type_id", catalogType.Id,
@@ -146,8 +249,17 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 				level.Debug(logger).Log("msg", "ignoring catalog type as it managed elsewhere")
 			}
 		} else if syncID != cfg.SyncID {
-			logger.Log("msg", "ignoring catalog type as it is managed by a different importer",
-				"catalog_type_sync_id", syncID)
+			_, declaredPartitioned := lo.Find(cfg.AllOutputTypes(), func(model *output.CatalogTypeModel) bool {
+				return model.Partitioned && model.TypeName == catalogType.TypeName
+			})
+			if declaredPartitioned && isPartitioned(&catalogType) {
+				logger.Log("msg", "catalog type is managed by a different importer but partitioned, sharing it",
+					"catalog_type_sync_id", syncID)
+				sharedCatalogTypes = append(sharedCatalogTypes, catalogType)
+			} else {
+				logger.Log("msg", "ignoring catalog type as it is managed by a different importer",
+					"catalog_type_sync_id", syncID)
+			}
 		} else {
 			existingCatalogTypes = append(existingCatalogTypes, catalogType)
 		}
@@ -160,9 +272,107 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 	OUT("✔ Found %d catalog types, with %d that match our sync ID (%s)",
 		len(result.JSON200.CatalogTypes), len(existingCatalogTypes), cfg.SyncID)
 
+	if err := checkUnpartitionedOutputs(cfg.Outputs(), existingCatalogTypes, cfg.SyncID); err != nil {
+		return err
+	}
+
+	// Outputs whose type_name has changed need their old type migrating to the new one,
+	// which we do once the new type exists.
+	typeMigrations := getTypeMigrations(cfg.Outputs(), existingCatalogTypes)
+	migratingTypeNames := map[string]string{} // old type name to new
+	for _, migration := range typeMigrations {
+		migratingTypeNames[migration.From.TypeName] = migration.Output.TypeName
+	}
+
+	// Removing an attribute, or changing its type, destroys the values it held. That's easy
+	// to do by accident when editing config, so we refuse unless explicitly allowed. We
+	// check before changing anything, so refusing never leaves a sync half-applied.
+	{
+		refused := []string{}
+		for _, model := range cfg.AllOutputTypes() {
+			// If we're about to create the type, it has no values to lose.
+			catalogTypes := []client.CatalogTypeV3{}
+			catalogType, ok := lo.Find(slices.Concat(existingCatalogTypes, unmanagedCatalogTypes, sharedCatalogTypes), func(catalogType client.CatalogTypeV3) bool {
+				return catalogType.TypeName == model.TypeName
+			})
+			if ok {
+				catalogTypes = append(catalogTypes, catalogType)
+			}
+
+			// Migrating a type copies its whole schema onto the new type, so any attribute of
+			// the old type we don't declare is removed by the schema sync that follows.
+			migration, migrating := lo.Find(typeMigrations, func(migration typeMigration) bool {
+				return migration.Output.TypeName == model.TypeName
+			})
+			if migrating && !(ok && isMigrated(migration.From, &catalogType)) {
+				catalogTypes = append(catalogTypes, migration.From)
+			}
+
+			changes := []reconcile.SchemaChange{}
+			for _, catalogType := range catalogTypes {
+				attributes := getSchemaAttributes(model, &catalogType, cfg.SyncID)
+				renames := reconcile.PendingRenames(&catalogType, model.RenamedAttributes, attributes)
+
+				changes = append(changes, reconcile.DestructiveSchemaChanges(&catalogType, attributes, renames, migratingTypeNames)...)
+			}
+			changes = lo.UniqBy(changes, reconcile.SchemaChange.String)
+			if len(changes) == 0 {
+				continue
+			}
+
+			allowed := opt.AllowSchemaDestruction || model.AllowSchemaDestruction
+			if opt.DryRun || allowed {
+				OUT(color.New(color.FgRed).Sprintf("\n⚠ %s has destructive schema changes%s:", model.TypeName,
+					lo.Ternary(allowed, " (allowed)", " (will be refused without --allow-schema-destruction)")))
+				for _, change := range changes {
+					OUT(color.New(color.FgRed).Sprintf("  - %s", change.String()))
+				}
+			}
+			if !allowed {
+				for _, change := range changes {
+					refused = append(refused, fmt.Sprintf("%s %s", model.TypeName, change.String()))
+				}
+			}
+		}
+
+		if len(refused) > 0 && !opt.DryRun {
+			return fmt.Errorf("refusing destructive schema changes (use --allow-schema-destruction, or set allow_schema_destruction on the output, to allow them):\n  %s",
+				strings.Join(refused, "\n  "))
+		}
+	}
+
+	// Take a snapshot before we change anything, so a bad sync can be undone.
+	if opt.SnapshotFile != "" && !opt.DryRun {
+		err := takeSnapshot(ctx, logger, cl, cfg.SyncID, existingCatalogTypes, opt.CatalogEntriesAPIPageSize, opt.SnapshotFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Enum types are generated from an output's attributes, so when the attribute is
+	// removed the enum type is left behind until it's pruned.
+	outputTypeNames := lo.Map(cfg.AllOutputTypes(), func(model *output.CatalogTypeModel, _ int) string {
+		return model.TypeName
+	})
+	isOrphanedEnum := func(catalogType client.CatalogTypeV3) bool {
+		parentTypeName, ok := getEnumParent(catalogType)
+		return ok && lo.Contains(outputTypeNames, parentTypeName) && !lo.Contains(outputTypeNames, catalogType.TypeName)
+	}
+	if !opt.Prune {
+		for _, existingCatalogType := range existingCatalogTypes {
+			if isOrphanedEnum(existingCatalogType) {
+				OUT("⚠ %s is an enum whose attribute is no longer in config, use --prune to remove it", existingCatalogType.TypeName)
+			}
+		}
+	}
+
 	// Remove unmanaged types
 	if opt.Prune {
-		OUT("\n↻ Prune enabled (--prune), removing types that are no longer in config...")
+		if opt.DryRun {
+			OUT("\n↻ Prune enabled (--prune), finding types that are no longer in config...")
+		} else {
+			OUT("\n↻ Prune enabled (--prune), removing types that are no longer in config...")
+		}
 
 		toDestroy := []client.CatalogTypeV3{}
 	nextCatalogType:
@@ -172,11 +382,22 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 				"catalog_type_id", existingCatalogType.Id,
 			)
 
-			for _, confType := range cfg.AllOutputTypes() {
-				if confType.TypeName == existingCatalogType.TypeName {
-					level.Debug(logger).Log("catalog type already exists")
-					continue nextCatalogType
-				}
+			if lo.Contains(outputTypeNames, existingCatalogType.TypeName) {
+				level.Debug(logger).Log("catalog type already exists")
+				continue nextCatalogType
+			}
+
+			// Types being migrated are removed once their entries have been copied.
+			if newTypeName, ok := migratingTypeNames[existingCatalogType.TypeName]; ok {
+				logger.Log("msg", "catalog type is being migrated, not removing", "new_type_name", newTypeName)
+				continue nextCatalogType
+			}
+
+			// If other importers push entries into this type, it isn't ours to remove.
+			if hasOtherPartitions(&existingCatalogType, cfg.SyncID) {
+				logger.Log("msg", "catalog type is no longer in config but is shared with other importers, not removing")
+				OUT("  ⚠ %s is shared with other importers, not removing", existingCatalogType.TypeName)
+				continue nextCatalogType
 			}
 
 			toDestroy = append(toDestroy, existingCatalogType)
@@ -186,12 +407,29 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 			OUT("  ✔ Nothing to remove!")
 		} else {
 			for _, catalogType := range toDestroy {
-				logger.Log("msg", "found catalog type for this sync ID that is no longer in config, removing")
+				label := catalogType.TypeName
+				if isOrphanedEnum(catalogType) {
+					label = fmt.Sprintf("%s (orphaned enum of %s)", label, catalogType.Annotations[AnnotationEnumOf])
+				}
+
+				// When previewing, show how many entries we'd lose to help judge whether this
+				// is what you want.
+				if opt.DryRun {
+					_, entries, err := reconcile.GetEntries(ctx, cl, catalogType.Id, opt.CatalogEntriesAPIPageSize)
+					if err != nil {
+						return errors.Wrap(err, "listing entries of catalog type to remove")
+					}
+					OUT(color.New(color.FgRed).Sprintf("  ⌫ %s would be removed, along with %d entries", label, len(entries)))
+					continue
+				}
+
+				logger.Log("msg", "found catalog type for this sync ID that is no longer in config, removing",
+					"catalog_type_id", catalogType.Id, "type_name", catalogType.TypeName)
 				_, err := cl.CatalogV3DestroyTypeWithResponse(ctx, catalogType.Id)
 				if err != nil {
 					return errors.Wrap(err, "removing catalog type")
 				}
-				OUT("  ⌫ %s", catalogType.TypeName)
+				OUT("  ⌫ %s", label)
 			}
 		}
 	}
@@ -216,6 +454,13 @@ createCatalogType:
 			}
 		}
 
+		for _, sharedCatalogType := range sharedCatalogTypes {
+			if model.TypeName == sharedCatalogType.TypeName {
+				level.Debug(logger).Log("catalog type already exists, and is shared with another importer")
+				continue createCatalogType
+			}
+		}
+
 		var createdCatalogType client.CatalogTypeV3
 		if opt.DryRun {
 			logger.Log("msg", "catalog type does not already exist, simulating create for --dry-run")
@@ -255,13 +500,21 @@ createCatalogType:
 				icon = &val
 			}
 
+			annotations := getAnnotations(cfg.SyncID)
+			if model.Partitioned {
+				annotations = getPartitionedAnnotations(&client.CatalogTypeV3{}, cfg.SyncID, model)
+			}
+			if model.SourceAttribute != nil {
+				annotations[AnnotationEnumOf] = getEnumOf(model)
+			}
+
 			result, err := cl.CatalogV3CreateTypeWithResponse(ctx, client.CatalogCreateTypePayloadV3{
 				Name:                model.Name,
 				Description:         model.Description,
 				Ranked:              &model.Ranked,
 				TypeName:            lo.ToPtr(model.TypeName),
 				Categories:          lo.ToPtr(categories),
-				Annotations:         lo.ToPtr(getAnnotations(cfg.SyncID)),
+				Annotations:         lo.ToPtr(annotations),
 				Color:               color,
 				Icon:                icon,
 				UseNameAsIdentifier: lo.ToPtr(model.UseNameAsIdentifier),
@@ -295,6 +548,12 @@ createCatalogType:
 				break
 			}
 		}
+		for _, sharedCatalogType := range sharedCatalogTypes {
+			if model.TypeName == sharedCatalogType.TypeName {
+				catalogType = &sharedCatalogType
+				break
+			}
+		}
 
 		if catalogType == nil {
 			return fmt.Errorf("could not find catalog type for model '%s', this is a bug in the importer", model.TypeName)
@@ -303,6 +562,52 @@ createCatalogType:
 		catalogTypesByOutput[model.TypeName] = catalogType
 	}
 
+	schemaAttributes := func(model *output.CatalogTypeModel) []client.CatalogTypeAttributePayloadV3 {
+		return getSchemaAttributes(model, catalogTypesByOutput[model.TypeName], cfg.SyncID)
+	}
+
+	if len(typeMigrations) > 0 {
+		OUT("\n↻ Migrating catalog types to their new type names...")
+		for _, migration := range typeMigrations {
+			OUT("  ↻ %s → %s", migration.From.TypeName, migration.Output.TypeName)
+			if opt.DryRun && isMigrated(migration.From, catalogTypesByOutput[migration.Output.TypeName]) {
+				OUT("    entries already copied, would remove %s unless types we don't manage still reference it",
+					migration.From.TypeName)
+				continue
+			}
+			if opt.DryRun {
+				_, entries, err := reconcile.GetEntries(ctx, cl, migration.From.Id, opt.CatalogEntriesAPIPageSize)
+				if err != nil {
+					return errors.Wrap(err, "listing entries")
+				}
+
+				OUT("    would copy %d entries, update references in our other catalog types, then remove %s",
+					len(entries), migration.From.TypeName)
+				continue
+			}
+
+			affected, err := migrateCatalogType(ctx, logger, cl, migration, catalogTypesByOutput[migration.Output.TypeName],
+				existingCatalogTypes, result.JSON200.CatalogTypes, opt.CatalogEntriesAPIPageSize)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("migrating %s to %s", migration.From.TypeName, migration.Output.TypeName))
+			}
+
+			// The migration changed the schema of these types, so reload them before we sync
+			// their schemas from config.
+			for _, catalogType := range catalogTypesByOutput {
+				if !lo.Contains(affected, catalogType.Id) {
+					continue
+				}
+
+				result, err := cl.CatalogV3ShowTypeWithResponse(ctx, catalogType.Id)
+				if err != nil {
+					return errors.Wrap(err, "reloading catalog type")
+				}
+				*catalogType = result.JSON200.CatalogType
+			}
+		}
+	}
+
 	OUT("\n↻ Syncing catalog type schemas...")
 	if opt.DryRun {
 		for _, model := range cfg.AllOutputTypes() {
@@ -325,7 +630,7 @@ createCatalogType:
 				Version:    updatedCatalogType.Schema.Version,
 				Attributes: []client.CatalogTypeAttributeV3{},
 			}
-			for _, attr := range model.Attributes {
+			for _, attr := range schemaAttributes(model) {
 				var path *[]client.CatalogTypeAttributePathItemV3
 
 				if attr.Path != nil {
@@ -353,6 +658,12 @@ createCatalogType:
 				})
 			}
 			OUT("  ✔ %s (id=%s)", model.TypeName, catalogType.Id)
+			for _, rename := range reconcile.PendingRenames(catalogType, model.RenamedAttributes, schemaAttributes(model)) {
+				OUT("    ↻ would migrate values of attribute %s to %s", rename.From.Id, lo.FromPtr(rename.To.Id))
+			}
+			for _, typeChange := range reconcile.PendingTypeChanges(catalogType, schemaAttributes(model), migratingTypeNames) {
+				OUT("    ↻ would convert values of attribute %s to its new type", typeChange.From.Id)
+			}
 
 			// We only have attribute names in the response for a path attribute, not the
 			// request. To avoid erroneous diffs, we strip the attribute names from any
@@ -369,14 +680,26 @@ createCatalogType:
 			DIFF("  ", catalogTypeToCompare, updatedCatalogType)
 		}
 	} else {
+		// If we're resuming, we can skip any schemas that we synced last time provided they
+		// haven't been changed since.
+		schemaSynced := map[string]bool{}
+		for _, model := range cfg.AllOutputTypes() {
+			schemaSynced[model.TypeName] = checkpoint.SchemaSynced(catalogTypesByOutput[model.TypeName])
+		}
+
 		// Update all the type schemas except for new derived attributes, which could reference
 		// attributes that don't exist yet.
 		catalogTypeVersions := map[string]int64{}
 		for _, model := range cfg.AllOutputTypes() {
 			catalogType := catalogTypesByOutput[model.TypeName]
+			if schemaSynced[model.TypeName] {
+				catalogTypeVersions[catalogType.Id] = catalogType.Schema.Version
+				OUT("  ✔ %s (id=%s, unchanged since checkpoint)", model.TypeName, catalogType.Id)
+				continue
+			}
 
 			attributesWithoutNewDerived := []client.CatalogTypeAttributePayloadV3{}
-			for _, attr := range model.Attributes {
+			for _, attr := range schemaAttributes(model) {
 				isBacklink := *attr.Mode == client.CatalogTypeAttributePayloadV3ModeBacklink
 				isPath := *attr.Mode == client.CatalogTypeAttributePayloadV3ModePath
 				if isBacklink || isPath {
@@ -405,13 +728,30 @@ createCatalogType:
 				icon = &val
 			}
 
+			annotations := getAnnotations(cfg.SyncID)
+			if model.Partitioned || isPartitioned(catalogType) {
+				annotations = getPartitionedAnnotations(catalogType, cfg.SyncID, model)
+			}
+			if model.SourceAttribute != nil {
+				annotations[AnnotationEnumOf] = getEnumOf(model)
+			}
+
+			// Preserve the record of when entries went missing, which powers grace periods
+			// for outputs with on_missing.delete_after.
+			if missingSince, ok := catalogType.Annotations[reconcile.AnnotationMissingSince]; ok {
+				annotations[reconcile.AnnotationMissingSince] = missingSince
+			}
+			if migratedFrom, ok := catalogType.Annotations[AnnotationMigratedFrom]; ok {
+				annotations[AnnotationMigratedFrom] = migratedFrom
+			}
+
 			logger.Log("msg", "updating catalog type", "catalog_type_id", catalogType.Id)
 			result, err := cl.CatalogV3UpdateTypeWithResponse(ctx, catalogType.Id, client.CatalogV3UpdateTypeJSONRequestBody{
 				Name:                model.Name,
 				Description:         model.Description,
 				Ranked:              &model.Ranked,
 				Categories:          lo.ToPtr(categories),
-				Annotations:         lo.ToPtr(getAnnotations(cfg.SyncID)),
+				Annotations:         lo.ToPtr(annotations),
 				Color:               color,
 				Icon:                icon,
 				UseNameAsIdentifier: lo.ToPtr(model.UseNameAsIdentifier),
@@ -422,12 +762,56 @@ createCatalogType:
 			}
 
 			version := result.JSON200.CatalogType.Schema.Version
+
+			// Changing the type of an attribute would lose its values, so we first copy them
+			// into an attribute of the old type. They're converted to the new type below,
+			// just like the values of a renamed attribute.
+			renamesFrom := catalogType
+			if typeChanges := reconcile.PendingTypeChanges(catalogType, attributesWithoutNewDerived, migratingTypeNames); len(typeChanges) > 0 {
+				attributesWithPrevious := lo.Map(catalogType.Schema.Attributes, func(attr client.CatalogTypeAttributeV3, _ int) client.CatalogTypeAttributePayloadV3 {
+					return attributeToPayload(attr)
+				})
+				for _, typeChange := range typeChanges {
+					_, exists := lo.Find(catalogType.Schema.Attributes, func(attr client.CatalogTypeAttributeV3) bool {
+						return attr.Id == lo.FromPtr(typeChange.To.Id)
+					})
+					if !exists {
+						attributesWithPrevious = append(attributesWithPrevious, typeChange.To)
+					}
+					OUT("  ↻ %s: keeping the values of attribute %s while its type changes", model.TypeName, typeChange.From.Id)
+				}
+
+				logger.Log("msg", "adding attributes to keep values while their type changes", "catalog_type_id", catalogType.Id)
+				schema, err := cl.CatalogV3UpdateTypeSchemaWithResponse(ctx, catalogType.Id, client.CatalogV3UpdateTypeSchemaJSONRequestBody{
+					Version:    version,
+					Attributes: attributesWithPrevious,
+				})
+				if err != nil {
+					return errors.Wrap(err, "updating catalog type schema")
+				}
+				version = schema.JSON200.CatalogType.Schema.Version
+
+				err = reconcile.MigrateAttributes(ctx, logger, reconcile.EntriesClientFromClient(cl), catalogType.Id, typeChanges, opt.CatalogEntriesAPIPageSize)
+				if err != nil {
+					return errors.Wrap(err, fmt.Sprintf("keeping values of attributes changing type in %s", model.TypeName))
+				}
+				renamesFrom = &schema.JSON200.CatalogType
+			}
+
+			// Renamed attributes must keep their old definition in the schema until we've
+			// copied their values across, otherwise the values are lost.
+			renames := reconcile.PendingRenames(renamesFrom, model.RenamedAttributes, attributesWithoutNewDerived)
+			attributesWithRenamed := append([]client.CatalogTypeAttributePayloadV3{}, attributesWithoutNewDerived...)
+			for _, rename := range renames {
+				attributesWithRenamed = append(attributesWithRenamed, attributeToPayload(rename.From))
+			}
+
 			logger.Log("msg", "updating catalog type schema", "catalog_type_id", catalogType.Id, "version", version)
-			schemaJSON, _ := json.Marshal(attributesWithoutNewDerived)
+			schemaJSON, _ := json.Marshal(attributesWithRenamed)
 			level.Debug(logger).Log("msg", "updating catalog type schema", "catalog_type_id", catalogType.Id, "schema", schemaJSON)
 			schema, err := cl.CatalogV3UpdateTypeSchemaWithResponse(ctx, catalogType.Id, client.CatalogV3UpdateTypeSchemaJSONRequestBody{
 				Version:    version,
-				Attributes: attributesWithoutNewDerived,
+				Attributes: attributesWithRenamed,
 			})
 			if err != nil {
 				return errors.Wrap(err, "updating catalog type schema")
@@ -435,6 +819,28 @@ createCatalogType:
 
 			catalogTypeVersions[catalogType.Id] = schema.JSON200.CatalogType.Schema.Version
 
+			if len(renames) > 0 {
+				for _, rename := range renames {
+					OUT("  ↻ %s: migrating attribute %s to %s", model.TypeName, rename.From.Id, lo.FromPtr(rename.To.Id))
+				}
+				err := reconcile.MigrateAttributes(ctx, logger, reconcile.EntriesClientFromClient(cl), catalogType.Id, renames, opt.CatalogEntriesAPIPageSize)
+				if err != nil {
+					return errors.Wrap(err, fmt.Sprintf("migrating renamed attributes of %s", model.TypeName))
+				}
+
+				// Now the values are safe, we can drop the old attributes.
+				logger.Log("msg", "removing renamed attributes from catalog type schema", "catalog_type_id", catalogType.Id)
+				schema, err := cl.CatalogV3UpdateTypeSchemaWithResponse(ctx, catalogType.Id, client.CatalogV3UpdateTypeSchemaJSONRequestBody{
+					Version:    catalogTypeVersions[catalogType.Id],
+					Attributes: attributesWithoutNewDerived,
+				})
+				if err != nil {
+					return errors.Wrap(err, "updating catalog type schema")
+				}
+
+				catalogTypeVersions[catalogType.Id] = schema.JSON200.CatalogType.Schema.Version
+			}
+
 			OUT("  ✔ %s (id=%s)", model.TypeName, catalogType.Id)
 		}
 
@@ -442,6 +848,9 @@ createCatalogType:
 		OUT("\n↻ Syncing derived attributes...")
 		for _, model := range cfg.AllOutputTypes() {
 			catalogType := catalogTypesByOutput[model.TypeName]
+			if schemaSynced[model.TypeName] {
+				continue
+			}
 
 			hasNewDerived := false
 			for _, attr := range model.Attributes {
@@ -462,55 +871,43 @@ createCatalogType:
 			version := catalogTypeVersions[catalogType.Id]
 			logger.Log("msg", "updating catalog type schema: creating derived attribute(s)", "catalog_type_id", catalogType.Id, "version", version)
 
-			_, err = cl.CatalogV3UpdateTypeSchemaWithResponse(ctx, catalogType.Id, client.CatalogV3UpdateTypeSchemaJSONRequestBody{
+			schema, err := cl.CatalogV3UpdateTypeSchemaWithResponse(ctx, catalogType.Id, client.CatalogV3UpdateTypeSchemaJSONRequestBody{
 				Version:    version,
-				Attributes: model.Attributes,
+				Attributes: schemaAttributes(model),
 			})
 			if err != nil {
 				return errors.Wrap(err, "updating catalog type schema")
 			}
 
+			catalogTypeVersions[catalogType.Id] = schema.JSON200.CatalogType.Schema.Version
+
 			OUT("  ✔ %s (id=%s)", model.TypeName, catalogType.Id)
 		}
+
+		for _, model := range cfg.AllOutputTypes() {
+			catalogType := catalogTypesByOutput[model.TypeName]
+			if err := checkpoint.MarkSchemaSynced(catalogType.Id, catalogTypeVersions[catalogType.Id]); err != nil {
+				return err
+			}
+		}
 	}
 
-	for _, pipeline := range cfg.Pipelines {
+	for pipelineIdx, pipeline := range cfg.Pipelines {
 		OUT("\n↻ Syncing pipeline... (%s)", strings.Join(lo.Map(pipeline.Outputs, func(op *output.Output, _ int) string {
 			return op.TypeName
 		}), ", "))
 
-		// Load entries from source
-		sourcedEntries := []source.Entry{}
-		{
-			OUT("\n  ↻ Loading data from sources...")
-			for _, source := range pipeline.Sources {
-
-				sourceLabel := lo.Must(source.Backend()).String()
-
-				sourceEntries, err := source.Load(ctx, logger)
-				if err != nil {
-					return errors.Wrap(err, fmt.Sprintf("loading entries from source: %s", sourceLabel))
-				}
-
-				for _, sourceEntry := range sourceEntries {
-					parsedEntries, err := sourceEntry.Parse()
-					if err != nil {
-						sample := string(sourceEntry.Content)
-						if len(sample) > opt.SampleLength {
-							sample = sample[:opt.SampleLength]
-						}
-						logger.Log(
-							"source", sourceEntry.Origin,
-							"error", errors.Wrap(err, "parsing source entry"),
-							"sample", sample,
-						)
-					}
-
-					sourcedEntries = append(sourcedEntries, parsedEntries...)
-				}
+		if checkpoint.PipelineCompleted(pipelineIdx) {
+			OUT("  ✔ Already synced (from checkpoint)")
+			continue
+		}
 
-				OUT("    ✔ %s (found %d entries)", sourceLabel, len(sourcedEntries))
-			}
+		// Load entries from source, tracking where each came from so we can explain any
+		// problems with the entries we build from them.
+		OUT("\n  ↻ Loading data from sources...")
+		sourcedEntries, sourcedOrigins, err := loadPipelineSources(ctx, logger, pipeline, opt.SampleLength, opt.sourceCache)
+		if err != nil {
+			return err
 		}
 
 		OUT("\n  ↻ Syncing entries...")
@@ -518,7 +915,7 @@ createCatalogType:
 			OUT("\n    ↻ %s", outputType.TypeName)
 
 			// Filter source for each of the output types
-			entries, err := output.Collect(ctx, logger, outputType, sourcedEntries)
+			entries, origins, err := output.CollectWithOrigins(ctx, logger, outputType, sourcedEntries, sourcedOrigins)
 			if err != nil {
 				return errors.Wrap(err, fmt.Sprintf("outputs.%d (type_name='%s')", idx, outputType.TypeName))
 			}
@@ -529,6 +926,29 @@ createCatalogType:
 			if err != nil {
 				return errors.Wrap(err, fmt.Sprintf("outputs.%d (type_name='%s')", idx, outputType.TypeName))
 			}
+			for entryIdx, entryModel := range entryModels {
+				entryModel.Origin = origins[entryIdx]
+			}
+
+			// Entries sharing an external ID would otherwise race each other in reconcile, so
+			// pick one according to the output's policy.
+			entryModels, duplicates, err := output.ResolveDuplicates(outputType, entryModels)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("outputs.%d (type_name='%s')", idx, outputType.TypeName))
+			}
+			if len(duplicates) > 0 {
+				OUT("      ⚠ Resolved %d duplicate external IDs (on_duplicate=%s)", len(duplicates),
+					lo.Ternary(outputType.OnDuplicate.Valid, outputType.OnDuplicate.String, "unset, so the last entry wins"))
+				for _, duplicate := range duplicates {
+					OUT("        %s", duplicate.String())
+				}
+			}
+
+			// Check the entries are valid before we touch the API, reporting every problem at
+			// once rather than failing part-way through the reconcile.
+			if err := output.ValidateEntries(outputType, entryModels); err != nil {
+				return errors.Wrap(err, fmt.Sprintf("outputs.%d (type_name='%s'): validating entries", idx, outputType.TypeName))
+			}
 
 			// As a precaution, error if we think there are no entries for this output and we
 			// haven't explicitly permitted deleting all entries.
@@ -537,17 +957,24 @@ createCatalogType:
 			}
 
 			// This can be reused for both model and enum types.
-			entriesClient := newEntriesClient(cl, existingCatalogTypes, opt.DryRun)
+			entriesClient := checkpoint.WrapEntriesClient(logger, newEntriesClient(cl, existingCatalogTypes, opt.DryRun))
 
-			{
+			if catalogType := catalogTypesByOutput[outputType.TypeName]; checkpoint.OutputCompleted(catalogType) {
+				OUT("      ✔ Already synced (from checkpoint)")
+			} else {
 				logger.Log("msg", "reconciling catalog entries", "output", outputType.TypeName)
-				catalogType := catalogTypesByOutput[outputType.TypeName]
 
 				showProgress := !opt.DryRun && !opt.NoProgress
 				err = reconcile.Entries(ctx, logger, entriesClient, outputType, catalogType, entryModels, newEntriesProgress(showProgress), opt.CatalogEntriesAPIPageSize)
 				if err != nil {
 					return errors.Wrap(err, fmt.Sprintf("outputs (type_name = '%s'): reconciling catalog entries", outputType.TypeName))
 				}
+				if err := state.Record(outputType.TypeName, reconcile.AppliedEntriesFromModels(outputType, entryModels)); err != nil {
+					return err
+				}
+				if err := checkpoint.MarkOutputCompleted(catalogType); err != nil {
+					return err
+				}
 			}
 
 			// Process enum attributes, which require generating from the result of the parent
@@ -580,18 +1007,170 @@ createCatalogType:
 
 				OUT("\n    ↻ %s (enum)", enumModel.TypeName)
 				catalogType := catalogTypesByOutput[enumModel.TypeName]
+				if checkpoint.OutputCompleted(catalogType) {
+					OUT("      ✔ Already synced (from checkpoint)")
+					continue
+				}
+
 				showProgress := !opt.DryRun && !opt.NoProgress
-				err := reconcile.Entries(ctx, logger, entriesClient, outputType, catalogType, enumModels, newEntriesProgress(showProgress), opt.CatalogEntriesAPIPageSize)
+				err := reconcile.Entries(ctx, logger, entriesClient, outputType.ForEnum(), catalogType, enumModels, newEntriesProgress(showProgress), opt.CatalogEntriesAPIPageSize)
 				if err != nil {
 					return errors.Wrap(err,
 						fmt.Sprintf("outputs (type_name = '%s'): enum for attribute (id = '%s'): %s: reconciling catalog entries",
 							outputType.TypeName, enumModel.SourceAttribute.ID, enumModel.TypeName))
 				}
+				if err := checkpoint.MarkOutputCompleted(catalogType); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := checkpoint.MarkPipelineCompleted(pipelineIdx); err != nil {
+			return err
+		}
+	}
+
+	// We've finished, so there's nothing left to resume.
+	return checkpoint.Remove()
+}
+
+// loadCheckpoint returns the checkpoint that this sync should record progress into. That
+// is a fresh checkpoint unless we've been asked to resume from one that was produced by
+// an identical config.
+func (opt *SyncOptions) loadCheckpoint(logger kitlog.Logger, cfg *config.Config) (*reconcile.Checkpoint, error) {
+	if opt.CheckpointFile == "" {
+		return nil, nil // checkpointing is disabled
+	}
+	filename := syncIDFilename(opt.CheckpointFile, cfg.SyncID)
+
+	configJSON, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "hashing config")
+	}
+	configHash := fmt.Sprintf("%x", sha256.Sum256(configJSON))
+
+	if opt.Resume {
+		checkpoint, err := reconcile.LoadCheckpoint(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case checkpoint == nil:
+			OUT("⚠ No checkpoint found at %s, starting from scratch", filename)
+		case !checkpoint.Matches(cfg.SyncID, configHash):
+			OUT("⚠ Checkpoint at %s was produced by a different config, starting from scratch", filename)
+		default:
+			logger.Log("msg", "resuming from checkpoint", "checkpoint_file", filename)
+			OUT("✔ Resuming from checkpoint (%s)", filename)
+			return checkpoint, nil
+		}
+	}
+
+	return reconcile.NewCheckpoint(filename, cfg.SyncID, configHash), nil
+}
+
+// loadState loads what previous syncs applied to each entry. State left behind by a
+// different sync ID is no use to us, so we warn and start afresh rather than fail.
+func loadState(filename, syncID string) (*reconcile.State, error) {
+	filename = syncIDFilename(filename, syncID)
+
+	state, err := reconcile.LoadState(filename, syncID)
+	if errors.Is(err, reconcile.ErrStateSyncIDMismatch) {
+		OUT("⚠ State at %s was recorded by a different sync ID, starting afresh", filename)
+		return reconcile.NewState(filename, syncID), nil
+	}
+
+	return state, err
+}
+
+// syncIDFilename replaces {sync_id} in a filename with the sync ID, so several configs
+// can be synced from the same directory without sharing local files.
+func syncIDFilename(filename, syncID string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, syncID)
+
+	return strings.ReplaceAll(filename, "{sync_id}", safe)
+}
+
+// loadPipelineSources loads the entries of every source in the pipeline, returning them
+// alongside the origin of each. If a cache is given, sources that were already loaded
+// are reused.
+func loadPipelineSources(ctx context.Context, logger kitlog.Logger, pipeline *config.Pipeline, sampleLength int, cache *sourceCache) ([]source.Entry, []string, error) {
+	sourcedEntries, sourcedOrigins := []source.Entry{}, []string{}
+	for _, source := range pipeline.Sources {
+		sourceLabel := lo.Must(source.Backend()).String()
+
+		sourceEntries, err := cache.Load(ctx, logger, source)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, fmt.Sprintf("loading entries from source: %s", sourceLabel))
+		}
+
+		for _, sourceEntry := range sourceEntries {
+			parsedEntries, err := sourceEntry.Parse()
+			if err != nil {
+				sample := string(sourceEntry.Content)
+				if len(sample) > sampleLength {
+					sample = sample[:sampleLength]
+				}
+				logger.Log(
+					"source", sourceEntry.Origin,
+					"error", errors.Wrap(err, "parsing source entry"),
+					"sample", sample,
+				)
+			}
+
+			sourcedEntries = append(sourcedEntries, parsedEntries...)
+			for range parsedEntries {
+				sourcedOrigins = append(sourcedOrigins, sourceEntry.Origin)
 			}
 		}
+
+		OUT("    ✔ %s (found %d entries)", sourceLabel, len(sourcedEntries))
+	}
+
+	return sourcedEntries, sourcedOrigins, nil
+}
+
+// bindLockFlags declares the flags controlling the sync lock, for every command that
+// makes changes under a sync ID.
+func bindLockFlags(cmd *kingpin.CmdClause, opts *lock.Options) {
+	cmd.Flag("lock-ttl", "How long the sync lock lasts if not renewed, after which another sync may break it").
+		Default(lock.DefaultTTL.String()).
+		DurationVar(&opts.TTL)
+	cmd.Flag("wait-for-lock", "How long to wait for another sync with the same sync ID to finish, rather than failing immediately (e.g. 15m)").
+		Default("0s").
+		DurationVar(&opts.Wait)
+	cmd.Flag("break-lock", "Take the sync lock even if another sync holds it, for when a sync died without releasing it").
+		BoolVar(&opts.Break)
+}
+
+// acquireSyncLock takes the lock for this sync ID, waiting for or breaking any existing
+// lock as requested.
+func acquireSyncLock(ctx context.Context, logger kitlog.Logger, cl *client.ClientWithResponses, syncID string, opts lock.Options) (*lock.Lock, error) {
+	store, err := lock.StoreFromClient(ctx, cl)
+	if err != nil {
+		return nil, errors.Wrap(err, "preparing sync lock")
+	}
+
+	if opts.Wait > 0 {
+		OUT("⧗ Acquiring sync lock (waiting up to %s)...", opts.Wait)
+	}
+	syncLock, err := lock.Acquire(ctx, logger, store, syncID, opts)
+	if err != nil {
+		if errors.Is(err, lock.ErrLocked) {
+			return nil, errors.Wrap(err, "another sync with this sync ID is running (use --wait-for-lock to wait for it, or --break-lock if it has died)")
+		}
+
+		return nil, errors.Wrap(err, "acquiring sync lock")
 	}
 
-	return nil
+	OUT("✔ Acquired sync lock (holder=%s)", syncLock.Lease().Holder)
+	return syncLock, nil
 }
 
 // newEntriesClient will return a client that speaks to the real API if dry-run is false,
@@ -636,6 +1215,10 @@ func newEntriesClient(cl *client.ClientWithResponses, existingCatalogTypes []cli
 			DIFF("      ", *entry, client.CatalogEntryV3{})
 			return nil
 		},
+		UpdateTypeAnnotations: func(ctx context.Context, catalogType *client.CatalogTypeV3, annotations map[string]string) error {
+			DIFF("      ", catalogType.Annotations, annotations)
+			return nil
+		},
 		Create: func(ctx context.Context, payload client.CatalogCreateEntryPayloadV3) (*client.CatalogEntryV3, error) {
 			DIFF("      ", client.CatalogCreateEntryPayloadV3{}, payload)
 			entry := &client.CatalogEntryV3{
@@ -767,6 +1350,11 @@ func newEntriesProgress(showBars bool) *reconcile.EntriesProgress {
 				updateBar.Add(1)
 			}
 		},
+		OnArchiveStart: func(total int) {
+			if total > 0 {
+				OUT("      ✔ Archiving entries missing from source... (%d entries to archive)", total)
+			}
+		},
 	}
 }
 
@@ -774,8 +1362,42 @@ var (
 	AnnotationSyncID     = "incident.io/catalog-importer/sync-id"
 	AnnotationLastSyncAt = "incident.io/catalog-importer/last-sync-at"
 	AnnotationVersion    = "incident.io/catalog-importer/version"
+
+	// AnnotationPartitions is set on catalog types that are shared between importers,
+	// recording the attributes each sync ID has declared as a JSON object.
+	AnnotationPartitions = "incident.io/catalog-importer/partitions"
+
+	// AnnotationEnumOf is set on enum types we generate, recording the output type name
+	// and attribute ID the enum was generated from (e.g. Custom["Service"].tier).
+	AnnotationEnumOf = "incident.io/catalog-importer/enum-of"
+
+	// AnnotationMigratedFrom is set on a catalog type once the entries of its previous
+	// type have been copied over, recording the ID of the previous type.
+	AnnotationMigratedFrom = "incident.io/catalog-importer/migrated-from"
 )
 
+// getEnumOf builds the value of the enum-of annotation for an enum model.
+func getEnumOf(model *output.CatalogTypeModel) string {
+	return fmt.Sprintf("%s.%s", model.ParentTypeName, model.SourceAttribute.ID)
+}
+
+// getEnumParent returns the output type name that an enum type was generated from, if
+// it was generated by the importer.
+func getEnumParent(catalogType client.CatalogTypeV3) (string, bool) {
+	enumOf, ok := catalogType.Annotations[AnnotationEnumOf]
+	if !ok {
+		return "", false
+	}
+
+	// The attribute ID comes after the last dot.
+	idx := strings.LastIndex(enumOf, ".")
+	if idx < 0 {
+		return "", false
+	}
+
+	return enumOf[:idx], true
+}
+
 func getAnnotations(syncID string) map[string]string {
 	return map[string]string{
 		AnnotationSyncID:     syncID,
@@ -784,6 +1406,43 @@ func getAnnotations(syncID string) map[string]string {
 	}
 }
 
+// getSchemaAttributes returns the attributes we should declare on the catalog type for
+// this model.
+//
+// Partitioned types may be shared with other importers, in which case the schema must
+// include the attributes they've declared too. That's true even if our output isn't
+// partitioned, as other importers may have registered partitions on a type we own.
+func getSchemaAttributes(model *output.CatalogTypeModel, catalogType *client.CatalogTypeV3, syncID string) []client.CatalogTypeAttributePayloadV3 {
+	if !model.Partitioned && !isPartitioned(catalogType) {
+		return model.Attributes
+	}
+
+	return mergePartitionedAttributes(catalogType, syncID, model.Attributes)
+}
+
+// attributeToPayload converts an attribute from a catalog type's schema into the payload
+// that would declare it as-is.
+func attributeToPayload(attr client.CatalogTypeAttributeV3) client.CatalogTypeAttributePayloadV3 {
+	var path *[]client.CatalogTypeAttributePathItemPayloadV3
+	if attr.Path != nil {
+		path = lo.ToPtr(lo.Map(*attr.Path, func(item client.CatalogTypeAttributePathItemV3, _ int) client.CatalogTypeAttributePathItemPayloadV3 {
+			return client.CatalogTypeAttributePathItemPayloadV3{
+				AttributeId: item.AttributeId,
+			}
+		}))
+	}
+
+	return client.CatalogTypeAttributePayloadV3{
+		Id:                lo.ToPtr(attr.Id),
+		Name:              attr.Name,
+		Type:              attr.Type,
+		Array:             attr.Array,
+		Mode:              lo.ToPtr(client.CatalogTypeAttributePayloadV3Mode(attr.Mode)),
+		BacklinkAttribute: attr.BacklinkAttribute,
+		Path:              path,
+	}
+}
+
 func newProgressBar(total int64, opts ...progressbar.Option) *progressbar.ProgressBar {
 	return progressbar.NewOptions64(
 		total,
@@ -19,13 +19,7 @@ type TypesOptions struct {
 }
 
 func (opt *TypesOptions) Bind(cmd *kingpin.CmdClause) *TypesOptions {
-	cmd.Flag("api-endpoint", "Endpoint of the incident.io API").
-		Default("https://api.incident.io").
-		Envar("INCIDENT_ENDPOINT").
-		StringVar(&opt.APIEndpoint)
-	cmd.Flag("api-key", "API key for incident.io").
-		Envar("INCIDENT_API_KEY").
-		StringVar(&opt.APIKey)
+	bindAPIFlags(cmd, &opt.APIEndpoint, &opt.APIKey)
 
 	return opt
 }
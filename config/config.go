@@ -28,6 +28,8 @@ func init() {
 type Config struct {
 	SyncID    string      `json:"sync_id,omitempty"`
 	Pipelines []*Pipeline `json:"pipelines"`
+	// Environments are the incident.io workspaces this config can be synced into, by name.
+	Environments map[string]*Environment `json:"environments,omitempty"`
 }
 
 func (c Config) Validate() error {
@@ -36,6 +38,7 @@ func (c Config) Validate() error {
 			Error("must provide a sync_id to track which resources are managed by this config, and to support clean-up when an output is removed")),
 		validation.Field(&c.Pipelines, validation.Required, validation.Length(1, 0).
 			Error("must specify at least one pipeline")),
+		validation.Field(&c.Environments),
 	)
 }
 
@@ -62,6 +65,14 @@ func (c Config) Filter(typeNames []string) *Config {
 	return &clone
 }
 
+// WithSyncIDSuffix returns a copy of the config whose sync ID has the suffix appended, so
+// the same config can be synced into several workspaces without their types clashing.
+func (c Config) WithSyncIDSuffix(suffix string) *Config {
+	c.SyncID += suffix
+
+	return &c
+}
+
 func (c Config) AllOutputTypes() []*output.CatalogTypeModel {
 	types := []*output.CatalogTypeModel{}
 	for _, outputType := range c.Outputs() {
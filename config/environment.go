@@ -0,0 +1,136 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/google/go-jsonnet"
+	"github.com/incident-io/catalog-importer/v2/source"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// EnvironmentExtVar is the jsonnet external variable that holds the name of the
+// environment being loaded, for configs that need to vary between them.
+const EnvironmentExtVar = "environment"
+
+// Environment is an incident.io workspace that the same config can be synced into, such
+// as a staging and a production organisation.
+type Environment struct {
+	APIEndpoint  string            `json:"api_endpoint,omitempty"`   // defaults to --api-endpoint
+	APIKey       source.Credential `json:"api_key,omitempty"`        // e.g. $(INCIDENT_API_KEY_PROD)
+	SyncIDSuffix string            `json:"sync_id_suffix,omitempty"` // appended to the sync_id
+	Targets      []string          `json:"targets,omitempty"`        // as if passed to --target
+	ExtVars      map[string]string `json:"ext_vars,omitempty"`       // jsonnet external variables
+}
+
+func (e Environment) Validate() error {
+	return validation.ValidateStruct(&e,
+		validation.Field(&e.ExtVars, validation.By(func(value interface{}) error {
+			if _, ok := e.ExtVars[EnvironmentExtVar]; ok {
+				return fmt.Errorf("cannot set '%s', as it is always the name of the environment", EnvironmentExtVar)
+			}
+
+			return nil
+		})),
+	)
+}
+
+// LoadEnvironments reads the environments declared in a config file.
+//
+// Jsonnet configs may use external variables that are only set per-environment, so we
+// read the environments without evaluating the rest of the config. This works as long as
+// the environments themselves don't depend on any external variables.
+func LoadEnvironments(filename string) (map[string]*Environment, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(filename, ".jsonnet") {
+		// Import the file rather than evaluate its source, so it's parsed exactly as it
+		// would be on its own.
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return nil, err
+		}
+		path, _ := json.Marshal(abs)
+		snippet := fmt.Sprintf("local config = import %s;\nif std.objectHas(config, 'environments') then config.environments else {}", path)
+		jsonString, err := jsonnet.MakeVM().EvaluateAnonymousSnippet(filename, snippet)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing jsonnet environments")
+		}
+
+		data = []byte(jsonString)
+	}
+
+	var cfg struct {
+		Environments map[string]*Environment `json:"environments"`
+	}
+	if strings.HasSuffix(filename, ".jsonnet") {
+		err = json.Unmarshal(data, &cfg.Environments)
+	} else {
+		err = yaml.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing environments")
+	}
+	if cfg.Environments == nil {
+		cfg.Environments = map[string]*Environment{}
+	}
+
+	return cfg.Environments, nil
+}
+
+// EnvironmentNames returns the names of the environments, in a stable order.
+func EnvironmentNames(environments map[string]*Environment) []string {
+	names := lo.Keys(environments)
+	sort.Strings(names)
+
+	return names
+}
+
+// LoadEnvironment loads the config for the named environment. The config is evaluated
+// with the environment's external variables, then adjusted for its sync ID suffix and
+// targets.
+func LoadEnvironment(filename, name string) (*Config, *Environment, error) {
+	environments, err := LoadEnvironments(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	env, ok := environments[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("no environment named '%s' in config (found %s)",
+			name, strings.Join(EnvironmentNames(environments), ", "))
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	extVars := lo.Assign(env.ExtVars, map[string]string{EnvironmentExtVar: name})
+	cfg, err := ParseWithExtVars(filename, data, extVars)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cfg.ForEnvironment(env), env, nil
+}
+
+// ForEnvironment returns a copy of the config adjusted for the environment.
+func (c Config) ForEnvironment(env *Environment) *Config {
+	cfg := c.WithSyncIDSuffix(env.SyncIDSuffix)
+	if len(env.Targets) > 0 {
+		cfg = cfg.Filter(env.Targets)
+	}
+
+	return cfg
+}
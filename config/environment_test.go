@@ -0,0 +1,123 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Environments", func() {
+	var filename string
+	write := func(name, content string) {
+		filename = filepath.Join(GinkgoT().TempDir(), name)
+		Expect(os.WriteFile(filename, []byte(content), 0o600)).To(Succeed())
+	}
+
+	Context("with a jsonnet config that depends on ext vars", func() {
+		BeforeEach(func() {
+			GinkgoT().Setenv("INCIDENT_API_KEY_PROD", "prod-key")
+
+			write("importer.jsonnet", `
+local env = std.extVar('environment');
+local tier = std.extVar('tier');
+
+{
+  sync_id: 'example',
+  environments: {
+    staging: {
+      api_endpoint: 'https://staging.incident.io',
+      sync_id_suffix: '-staging',
+      targets: ['Custom["Service"]'],
+      ext_vars: { tier: 'low' },
+    },
+    prod: {
+      api_key: '$(INCIDENT_API_KEY_PROD)',
+      ext_vars: { tier: 'high' },
+    },
+  },
+  pipelines: [
+    {
+      sources: [{ inline: { entries: [{ name: env, tier: tier }] } }],
+      outputs: [
+        { name: 'Service', description: env, type_name: 'Custom["Service"]', source: { name: '$.name', external_id: '$.name' } },
+        { name: 'Team', description: env, type_name: 'Custom["Team"]', source: { name: '$.name', external_id: '$.name' } },
+      ],
+    },
+  ],
+}
+`)
+		})
+
+		It("loads environments without evaluating the rest of the config", func() {
+			environments, err := LoadEnvironments(filename)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(EnvironmentNames(environments)).To(Equal([]string{"prod", "staging"}))
+			Expect(string(environments["prod"].APIKey)).To(Equal("prod-key"))
+		})
+
+		It("evaluates the config with the environment's ext vars", func() {
+			cfg, env, err := LoadEnvironment(filename, "prod")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(env.ExtVars).To(Equal(map[string]string{"tier": "high"}))
+			Expect(cfg.SyncID).To(Equal("example"))
+			Expect(cfg.Outputs()).To(HaveLen(2))
+			Expect(cfg.Outputs()[0].Description).To(Equal("prod"))
+		})
+
+		It("applies the environment's sync ID suffix and targets", func() {
+			cfg, env, err := LoadEnvironment(filename, "staging")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(env.APIEndpoint).To(Equal("https://staging.incident.io"))
+			Expect(cfg.SyncID).To(Equal("example-staging"))
+			Expect(cfg.Outputs()).To(HaveLen(1))
+			Expect(cfg.Outputs()[0].TypeName).To(Equal(`Custom["Service"]`))
+		})
+
+		It("errors for an unknown environment", func() {
+			_, _, err := LoadEnvironment(filename, "dev")
+			Expect(err).To(MatchError(ContainSubstring("no environment named 'dev' in config (found prod, staging)")))
+		})
+	})
+
+	It("loads environments from YAML config", func() {
+		write("importer.yaml", `
+sync_id: example
+environments:
+  prod:
+    sync_id_suffix: -prod
+pipelines: []
+`)
+		environments, err := LoadEnvironments(filename)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(environments["prod"].SyncIDSuffix).To(Equal("-prod"))
+	})
+
+	It("returns no environments if none are declared", func() {
+		write("importer.jsonnet", `{ sync_id: 'example', pipelines: [] }`)
+		environments, err := LoadEnvironments(filename)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(environments).To(BeEmpty())
+	})
+
+	It("loads environments from jsonnet that imports files relative to itself", func() {
+		write("importer.jsonnet", `
+local envs = import 'environments.libsonnet';
+local suffix(name) = '-' + name;
+
+{ sync_id: 'example', environments: envs(suffix), pipelines: [] }
+// trailing comment`)
+		Expect(os.WriteFile(filepath.Join(filepath.Dir(filename), "environments.libsonnet"),
+			[]byte(`function(suffix) { prod: { sync_id_suffix: suffix('prod') } }`), 0o600)).To(Succeed())
+
+		environments, err := LoadEnvironments(filename)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(environments["prod"].SyncIDSuffix).To(Equal("-prod"))
+	})
+
+	It("doesn't allow overriding the environment ext var", func() {
+		err := Environment{ExtVars: map[string]string{EnvironmentExtVar: "prod"}}.Validate()
+		Expect(err).To(MatchError(ContainSubstring("cannot set 'environment'")))
+	})
+})
@@ -10,9 +10,19 @@ import (
 )
 
 func Parse(filename string, data []byte) (*Config, error) {
+	return ParseWithExtVars(filename, data, nil)
+}
+
+// ParseWithExtVars parses config, evaluating jsonnet with the given external variables.
+func ParseWithExtVars(filename string, data []byte, extVars map[string]string) (*Config, error) {
 	// If we think this is jsonnet, try parsing it.
 	if strings.HasSuffix(filename, ".jsonnet") {
-		jsonString, err := jsonnet.MakeVM().EvaluateAnonymousSnippet(filename, string(data))
+		vm := jsonnet.MakeVM()
+		for key, value := range extVars {
+			vm.ExtVar(key, value)
+		}
+
+		jsonString, err := vm.EvaluateAnonymousSnippet(filename, string(data))
 		if err != nil {
 			return nil, errors.Wrap(err, "parsing jsonnet")
 		}
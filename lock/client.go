@@ -0,0 +1,186 @@
+package lock
+
+import (
+	"context"
+	"time"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+const (
+	// TypeName is the catalog type that stores locks, with one entry per sync ID.
+	TypeName = `Custom["CatalogImporterLock"]`
+
+	attributeHolder    = "holder"
+	attributeExpiresAt = "expires_at"
+)
+
+// StoreFromClient builds a store that keeps leases as entries in a dedicated catalog
+// type, creating the type if it doesn't already exist.
+//
+// The lock type is deliberately created without a sync ID annotation, so no importer
+// will ever consider it theirs to prune.
+func StoreFromClient(ctx context.Context, cl *client.ClientWithResponses) (Store, error) {
+	catalogTypeID, err := ensureLockType(ctx, cl)
+	if err != nil {
+		return Store{}, err
+	}
+
+	return Store{
+		Get: func(ctx context.Context, key string) (*Lease, error) {
+			result, err := cl.CatalogV3ListEntriesWithResponse(ctx, &client.CatalogV3ListEntriesParams{
+				CatalogTypeId: catalogTypeID,
+				PageSize:      25,
+				Identifier:    lo.ToPtr(key),
+			})
+			if err != nil {
+				return nil, errors.Wrap(err, "listing lock entries")
+			}
+
+			for _, entry := range result.JSON200.CatalogEntries {
+				if lo.FromPtr(entry.ExternalId) == key {
+					return leaseFromEntry(entry), nil
+				}
+			}
+
+			return nil, nil
+		},
+		Create: func(ctx context.Context, lease Lease) (*Lease, error) {
+			result, err := cl.CatalogV3CreateEntryWithResponse(ctx, client.CatalogCreateEntryPayloadV3{
+				CatalogTypeId:   catalogTypeID,
+				Name:            lease.Key,
+				ExternalId:      lo.ToPtr(lease.Key),
+				AttributeValues: leaseAttributeValues(lease),
+			})
+			if err != nil {
+				return nil, errors.Wrap(err, "creating lock entry")
+			}
+
+			return leaseFromEntry(result.JSON201.CatalogEntry), nil
+		},
+		Update: func(ctx context.Context, lease Lease) error {
+			_, err := cl.CatalogV3UpdateEntryWithResponse(ctx, lease.ID, client.CatalogUpdateEntryPayloadV3{
+				Name:            lease.Key,
+				ExternalId:      lo.ToPtr(lease.Key),
+				AttributeValues: leaseAttributeValues(lease),
+			})
+			if err != nil {
+				return errors.Wrap(err, "updating lock entry")
+			}
+
+			return nil
+		},
+		Delete: func(ctx context.Context, lease Lease) error {
+			_, err := cl.CatalogV3DestroyEntryWithResponse(ctx, lease.ID)
+			if err != nil {
+				return errors.Wrap(err, "destroying lock entry")
+			}
+
+			return nil
+		},
+	}, nil
+}
+
+// ensureLockType finds the lock catalog type, creating it if it doesn't exist, and
+// returns its ID.
+func ensureLockType(ctx context.Context, cl *client.ClientWithResponses) (string, error) {
+	findLockType := func() (string, error) {
+		result, err := cl.CatalogV3ListTypesWithResponse(ctx)
+		if err != nil {
+			return "", errors.Wrap(err, "listing catalog types")
+		}
+
+		catalogType, ok := lo.Find(result.JSON200.CatalogTypes, func(catalogType client.CatalogTypeV3) bool {
+			return catalogType.TypeName == TypeName
+		})
+		if !ok {
+			return "", nil
+		}
+
+		return catalogType.Id, nil
+	}
+
+	catalogTypeID, err := findLockType()
+	if err != nil || catalogTypeID != "" {
+		return catalogTypeID, err
+	}
+
+	result, createErr := cl.CatalogV3CreateTypeWithResponse(ctx, client.CatalogCreateTypePayloadV3{
+		Name:        "Catalog importer lock",
+		Description: "Locks held by the catalog importer, preventing two syncs with the same sync ID from running at once. Managed automatically.",
+		TypeName:    lo.ToPtr(TypeName),
+	})
+	if createErr != nil {
+		// Another sync may have created the type at the same time as us, in which case we
+		// can use theirs.
+		catalogTypeID, err := findLockType()
+		if err != nil || catalogTypeID != "" {
+			return catalogTypeID, err
+		}
+
+		return "", errors.Wrap(createErr, "creating lock catalog type")
+	}
+
+	catalogType := result.JSON201.CatalogType
+	_, err = cl.CatalogV3UpdateTypeSchemaWithResponse(ctx, catalogType.Id, client.CatalogV3UpdateTypeSchemaJSONRequestBody{
+		Version: catalogType.Schema.Version,
+		Attributes: []client.CatalogTypeAttributePayloadV3{
+			{
+				Id:   lo.ToPtr(attributeHolder),
+				Name: "Holder",
+				Type: "String",
+				Mode: lo.ToPtr(client.CatalogTypeAttributePayloadV3ModeApi),
+			},
+			{
+				Id:   lo.ToPtr(attributeExpiresAt),
+				Name: "Expires at",
+				Type: "String",
+				Mode: lo.ToPtr(client.CatalogTypeAttributePayloadV3ModeApi),
+			},
+		},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "updating lock catalog type schema")
+	}
+
+	return catalogType.Id, nil
+}
+
+func leaseAttributeValues(lease Lease) map[string]client.CatalogEngineParamBindingPayloadV3 {
+	return map[string]client.CatalogEngineParamBindingPayloadV3{
+		attributeHolder: {
+			Value: &client.CatalogEngineParamBindingValuePayloadV3{
+				Literal: lo.ToPtr(lease.Holder),
+			},
+		},
+		attributeExpiresAt: {
+			Value: &client.CatalogEngineParamBindingValuePayloadV3{
+				Literal: lo.ToPtr(lease.ExpiresAt.UTC().Format(time.RFC3339)),
+			},
+		},
+	}
+}
+
+// leaseFromEntry parses a lease from its catalog entry. If the expiry is missing or
+// corrupt the lease is treated as already expired, so it can be broken.
+func leaseFromEntry(entry client.CatalogEntryV3) *Lease {
+	literal := func(attributeID string) string {
+		binding, ok := entry.AttributeValues[attributeID]
+		if !ok || binding.Value == nil {
+			return ""
+		}
+
+		return lo.FromPtr(binding.Value.Literal)
+	}
+
+	expiresAt, _ := time.Parse(time.RFC3339, literal(attributeExpiresAt))
+
+	return &Lease{
+		ID:        entry.Id,
+		Key:       lo.FromPtr(entry.ExternalId),
+		Holder:    literal(attributeHolder),
+		ExpiresAt: expiresAt,
+	}
+}
@@ -0,0 +1,301 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	// DefaultTTL is how long a lease lasts without being renewed. Syncs renew their lease
+	// while they run, so this only matters when a sync dies without releasing its lock.
+	DefaultTTL = 10 * time.Minute
+
+	// pollInterval is how often we check whether a held lock has been released while
+	// waiting for it.
+	pollInterval = 5 * time.Second
+)
+
+// ErrLocked is returned when a lock is held by someone else and we gave up waiting.
+var ErrLocked = errors.New("lock is held by another sync")
+
+// ErrLost is the cause of a lock's context being cancelled, when we couldn't renew our
+// lease before it expired or someone else took the lock from us.
+var ErrLost = errors.New("lost lock")
+
+// Lease is a claim on a lock, which is valid until it expires.
+type Lease struct {
+	ID        string // ID of the entry that stores the lease, set by the store
+	Key       string // what the lease is for, normally the sync ID
+	Holder    string
+	ExpiresAt time.Time
+}
+
+// Expired returns true if the lease has lapsed and can be broken by someone else.
+func (l Lease) Expired(now time.Time) bool {
+	return !now.Before(l.ExpiresAt)
+}
+
+// Store persists leases. Like reconcile.EntriesClient it's a struct of functions, so
+// tests can provide an in-memory implementation.
+type Store struct {
+	// Get returns the current lease for the key, or nil if there is none.
+	Get func(ctx context.Context, key string) (*Lease, error)
+	// Create stores a new lease, failing if one already exists for the key.
+	Create func(ctx context.Context, lease Lease) (*Lease, error)
+	// Update extends an existing lease.
+	Update func(ctx context.Context, lease Lease) error
+	// Delete removes a lease.
+	Delete func(ctx context.Context, lease Lease) error
+}
+
+// Options control how we acquire a lock.
+type Options struct {
+	TTL   time.Duration // how long our lease lasts, defaulting to DefaultTTL
+	Wait  time.Duration // how long to wait for someone else's lease, zero fails immediately
+	Break bool          // take the lock even if someone else holds an unexpired lease
+}
+
+// Lock is a held lease that is renewed in the background until released.
+type Lock struct {
+	store  Store
+	logger kitlog.Logger
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	lease Lease
+	stop  chan struct{}
+	done  chan struct{}
+	lost  chan struct{}
+}
+
+// Acquire takes the lock for the given key, waiting up to opts.Wait for any existing
+// lease to be released or expire.
+//
+// Once acquired, the lease is renewed every third of its TTL until Release is called.
+func Acquire(ctx context.Context, logger kitlog.Logger, store Store, key string, opts Options) (*Lock, error) {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+
+	holder := newHolder()
+	logger = kitlog.With(logger, "lock_key", key, "lock_holder", holder)
+
+	deadline := time.Now().Add(opts.Wait)
+	breakLock := opts.Break
+	for {
+		existing, err := store.Get(ctx, key)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting existing lock")
+		}
+
+		if existing != nil {
+			switch {
+			case breakLock:
+				logger.Log("msg", "breaking existing lock", "existing_holder", existing.Holder,
+					"existing_expires_at", existing.ExpiresAt.Format(time.RFC3339))
+			case existing.Expired(time.Now()):
+				logger.Log("msg", "existing lock has expired, breaking it", "existing_holder", existing.Holder,
+					"existing_expires_at", existing.ExpiresAt.Format(time.RFC3339))
+			default:
+				remaining := time.Until(deadline)
+				if remaining <= 0 {
+					return nil, errors.Wrapf(ErrLocked, "held by %s until %s",
+						existing.Holder, existing.ExpiresAt.Format(time.RFC3339))
+				}
+
+				logger.Log("msg", "waiting for lock", "existing_holder", existing.Holder,
+					"existing_expires_at", existing.ExpiresAt.Format(time.RFC3339))
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(min(pollInterval, remaining)):
+				}
+
+				continue
+			}
+
+			if err := store.Delete(ctx, *existing); err != nil {
+				return nil, errors.Wrap(err, "breaking existing lock")
+			}
+		}
+
+		// Only break the lock once: if someone else takes it while we do, they won the race
+		// fair and square.
+		breakLock = false
+
+		created, createErr := store.Create(ctx, Lease{
+			Key:       key,
+			Holder:    holder,
+			ExpiresAt: time.Now().Add(opts.TTL),
+		})
+
+		// Two syncs might have raced to create the lease, so check who actually holds it
+		// before we go any further.
+		current, err := store.Get(ctx, key)
+		if err != nil {
+			return nil, errors.Wrap(err, "checking lock")
+		}
+		if current == nil {
+			if createErr != nil {
+				return nil, errors.Wrap(createErr, "creating lock")
+			}
+
+			return nil, errors.New("lock disappeared immediately after being created")
+		}
+		if current.Holder != holder {
+			logger.Log("msg", "lost race to acquire lock", "existing_holder", current.Holder)
+			continue
+		}
+		if created == nil {
+			created = current
+		}
+
+		logger.Log("msg", "acquired lock", "expires_at", created.ExpiresAt.Format(time.RFC3339))
+
+		lock := &Lock{
+			store:  store,
+			logger: logger,
+			ttl:    opts.TTL,
+			lease:  *current,
+			stop:   make(chan struct{}),
+			done:   make(chan struct{}),
+			lost:   make(chan struct{}),
+		}
+		go lock.heartbeat()
+
+		return lock, nil
+	}
+}
+
+// Lease returns the lease we currently hold.
+func (l *Lock) Lease() Lease {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.lease
+}
+
+// Context returns a context that is cancelled, with ErrLost as its cause, if we lose the
+// lock. Anything done while holding the lock should use it, so we stop as soon as the
+// lock is no longer ours.
+func (l *Lock) Context(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-l.lost:
+			cancel(ErrLost)
+		}
+	}()
+
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+// heartbeat renews the lease until the lock is released.
+//
+// A failed renewal is retried on the next tick, as the lease remains valid until it
+// expires. If someone else has taken the lock, or the lease would expire before we could
+// try again, we've lost the lock and say so by closing l.lost.
+func (l *Lock) heartbeat() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			lease := l.lease
+			lease.ExpiresAt = time.Now().Add(l.ttl)
+			l.mu.Unlock()
+
+			ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+			err := l.store.Update(ctx, lease)
+			lost := err != nil && l.isLost(ctx)
+			cancel()
+			if lost {
+				l.logger.Log("msg", "failed to renew lock, and it's no longer ours", "error", err)
+				close(l.lost)
+				return
+			}
+			if err != nil {
+				l.logger.Log("msg", "failed to renew lock, will try again", "error", err)
+				continue
+			}
+
+			l.mu.Lock()
+			l.lease = lease
+			l.mu.Unlock()
+		}
+	}
+}
+
+// isLost returns true if, having failed to renew our lease, we should consider the lock
+// lost.
+func (l *Lock) isLost(ctx context.Context) bool {
+	held := l.Lease()
+	if time.Now().Add(l.ttl / 3).After(held.ExpiresAt) {
+		return true // we won't get another chance to renew it
+	}
+
+	current, err := l.store.Get(ctx, held.Key)
+	if err != nil {
+		return false // we can't tell, so try again next time
+	}
+
+	return current == nil || current.Holder != held.Holder
+}
+
+// Release stops renewing the lease and removes it, provided we still hold it. It's safe
+// to call on a nil lock, and more than once.
+func (l *Lock) Release(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	select {
+	case <-l.stop:
+		return nil // already released
+	default:
+		close(l.stop)
+	}
+	<-l.done
+
+	lease := l.Lease()
+	current, err := l.store.Get(ctx, lease.Key)
+	if err != nil {
+		return errors.Wrap(err, "getting lock")
+	}
+	if current == nil || current.Holder != lease.Holder {
+		l.logger.Log("msg", "lock was taken by someone else before we released it")
+		return nil
+	}
+
+	if err := l.store.Delete(ctx, *current); err != nil {
+		return errors.Wrap(err, "releasing lock")
+	}
+
+	l.logger.Log("msg", "released lock")
+	return nil
+}
+
+// newHolder builds a unique name for this process that is still readable to humans
+// trying to figure out who holds a lock.
+func newHolder() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return fmt.Sprintf("%s/%d/%s", hostname, os.Getpid(), uuid.NewString()[:8])
+}
@@ -0,0 +1,202 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// memoryStore keeps leases in memory, keyed by lock key.
+type memoryStore struct {
+	mu     sync.Mutex
+	leases map[string]Lease
+	nextID int
+}
+
+func (m *memoryStore) Store() Store {
+	return Store{
+		Get: func(ctx context.Context, key string) (*Lease, error) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			lease, ok := m.leases[key]
+			if !ok {
+				return nil, nil
+			}
+
+			return &lease, nil
+		},
+		Create: func(ctx context.Context, lease Lease) (*Lease, error) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			if _, ok := m.leases[lease.Key]; ok {
+				return nil, errors.New("already exists")
+			}
+
+			m.nextID++
+			lease.ID = fmt.Sprintf("entry-%d", m.nextID)
+			m.leases[lease.Key] = lease
+
+			return &lease, nil
+		},
+		Update: func(ctx context.Context, lease Lease) error {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			m.leases[lease.Key] = lease
+			return nil
+		},
+		Delete: func(ctx context.Context, lease Lease) error {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			if existing, ok := m.leases[lease.Key]; ok && existing.ID == lease.ID {
+				delete(m.leases, lease.Key)
+			}
+
+			return nil
+		},
+	}
+}
+
+var _ = Describe("Acquire", func() {
+	var (
+		ctx    context.Context
+		logger kitlog.Logger
+		memory *memoryStore
+		store  Store
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+		logger = kitlog.NewNopLogger()
+		memory = &memoryStore{leases: map[string]Lease{}}
+		store = memory.Store()
+
+		originalPollInterval := pollInterval
+		pollInterval = 10 * time.Millisecond
+		DeferCleanup(func() {
+			pollInterval = originalPollInterval
+		})
+	})
+
+	holdLock := func(holder string, expiresAt time.Time) {
+		_, err := store.Create(ctx, Lease{Key: "sync-id", Holder: holder, ExpiresAt: expiresAt})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("acquires and releases an unheld lock", func() {
+		lock, err := Acquire(ctx, logger, store, "sync-id", Options{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(memory.leases).To(HaveKey("sync-id"))
+		Expect(lock.Lease().ExpiresAt).To(BeTemporally("~", time.Now().Add(DefaultTTL), time.Second))
+
+		Expect(lock.Release(ctx)).To(Succeed())
+		Expect(memory.leases).NotTo(HaveKey("sync-id"))
+		Expect(lock.Release(ctx)).To(Succeed())
+	})
+
+	It("fails when someone else holds the lock", func() {
+		holdLock("someone-else", time.Now().Add(time.Hour))
+
+		_, err := Acquire(ctx, logger, store, "sync-id", Options{})
+		Expect(errors.Is(err, ErrLocked)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("someone-else"))
+	})
+
+	It("waits for the lock to be released", func() {
+		holdLock("someone-else", time.Now().Add(time.Hour))
+		go func() {
+			defer GinkgoRecover()
+
+			time.Sleep(50 * time.Millisecond)
+			Expect(store.Delete(ctx, memory.leases["sync-id"])).To(Succeed())
+		}()
+
+		lock, err := Acquire(ctx, logger, store, "sync-id", Options{Wait: 5 * time.Second})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lock.Release(ctx)).To(Succeed())
+	})
+
+	It("breaks an expired lock", func() {
+		holdLock("someone-else", time.Now().Add(-time.Minute))
+
+		lock, err := Acquire(ctx, logger, store, "sync-id", Options{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(memory.leases["sync-id"].Holder).NotTo(Equal("someone-else"))
+		Expect(lock.Release(ctx)).To(Succeed())
+	})
+
+	It("breaks an unexpired lock when asked to", func() {
+		holdLock("someone-else", time.Now().Add(time.Hour))
+
+		lock, err := Acquire(ctx, logger, store, "sync-id", Options{Break: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(memory.leases["sync-id"].Holder).To(Equal(lock.Lease().Holder))
+		Expect(lock.Release(ctx)).To(Succeed())
+	})
+
+	It("renews the lease while held", func() {
+		lock, err := Acquire(ctx, logger, store, "sync-id", Options{TTL: 150 * time.Millisecond})
+		Expect(err).NotTo(HaveOccurred())
+
+		initial := lock.Lease().ExpiresAt
+		Eventually(func() time.Time {
+			return lock.Lease().ExpiresAt
+		}).Should(BeTemporally(">", initial))
+		Expect(lock.Release(ctx)).To(Succeed())
+	})
+
+	It("cancels the lock's context when someone else takes the lock", func() {
+		failing := store
+		failing.Update = func(ctx context.Context, lease Lease) error {
+			return errors.New("entry not found")
+		}
+
+		lock, err := Acquire(ctx, logger, failing, "sync-id", Options{TTL: 150 * time.Millisecond})
+		Expect(err).NotTo(HaveOccurred())
+		lockCtx, cancel := lock.Context(ctx)
+		defer cancel()
+
+		memory.mu.Lock()
+		memory.leases["sync-id"] = Lease{ID: "other", Key: "sync-id", Holder: "someone-else", ExpiresAt: time.Now().Add(time.Hour)}
+		memory.mu.Unlock()
+
+		Eventually(lockCtx.Done()).Should(BeClosed())
+		Expect(context.Cause(lockCtx)).To(MatchError(ErrLost))
+		Expect(lock.Release(ctx)).To(Succeed())
+	})
+
+	It("keeps the lock's context while renewals fail but the lease is still ours", func() {
+		failing := store
+		failing.Update = func(ctx context.Context, lease Lease) error {
+			return errors.New("temporarily unavailable")
+		}
+
+		lock, err := Acquire(ctx, logger, failing, "sync-id", Options{TTL: 300 * time.Millisecond})
+		Expect(err).NotTo(HaveOccurred())
+		lockCtx, cancel := lock.Context(ctx)
+		defer cancel()
+
+		// The first renewal fails but leaves time for another, then the lease runs out.
+		Consistently(lockCtx.Done(), 150*time.Millisecond).ShouldNot(BeClosed())
+		Eventually(lockCtx.Done()).Should(BeClosed())
+		Expect(lock.Release(ctx)).To(Succeed())
+	})
+
+	It("doesn't release a lock that someone else has since taken", func() {
+		lock, err := Acquire(ctx, logger, store, "sync-id", Options{})
+		Expect(err).NotTo(HaveOccurred())
+
+		memory.leases["sync-id"] = Lease{ID: "other", Key: "sync-id", Holder: "someone-else", ExpiresAt: time.Now().Add(time.Hour)}
+		Expect(lock.Release(ctx)).To(Succeed())
+		Expect(memory.leases["sync-id"].Holder).To(Equal("someone-else"))
+	})
+})
@@ -12,23 +12,33 @@ import (
 // Collect filters the list of entries against the source filter on the output, returning
 // a list of all entries which pass the filter.
 func Collect(ctx context.Context, logger kitlog.Logger, output *Output, entries []source.Entry) ([]source.Entry, error) {
+	filteredEntries, _, err := CollectWithOrigins(ctx, logger, output, entries, nil)
+	return filteredEntries, err
+}
+
+// CollectWithOrigins is Collect, but also filters a list of origins that correspond to
+// each entry so we can keep track of where the collected entries came from.
+func CollectWithOrigins(ctx context.Context, logger kitlog.Logger, output *Output, entries []source.Entry, origins []string) ([]source.Entry, []string, error) {
 	if !output.Source.Filter.Valid {
-		return entries, nil // no-op, the filter is blank
+		return entries, origins, nil // no-op, the filter is blank
 	}
 
 	src := output.Source.Filter.String
 
-	filteredEntries := []source.Entry{}
-	for _, entry := range entries {
+	filteredEntries, filteredOrigins := []source.Entry{}, []string{}
+	for idx, entry := range entries {
 		result, err := expr.EvaluateSingleValue[bool](ctx, logger, src, entry)
 		if err != nil {
-			return nil, errors.Wrap(err, "evaluating filter for entry")
+			return nil, nil, errors.Wrap(err, "evaluating filter for entry")
 		}
 
 		if result != nil && *result {
 			filteredEntries = append(filteredEntries, entry)
+			if idx < len(origins) {
+				filteredOrigins = append(filteredOrigins, origins[idx])
+			}
 		}
 	}
 
-	return filteredEntries, nil
+	return filteredEntries, filteredOrigins, nil
 }
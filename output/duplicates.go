@@ -0,0 +1,162 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/samber/lo"
+)
+
+// Policies for resolving entries that share an external ID, set with on_duplicate.
+//
+// If no policy is set, the last entry wins as it always has, with each clash reported as
+// a warning.
+const (
+	OnDuplicateError     = "error"      // fail the sync
+	OnDuplicateFirstWins = "first_wins" // keep the first entry, discarding the others
+	OnDuplicateLastWins  = "last_wins"  // keep the last entry, discarding the others
+	OnDuplicateMerge     = "merge"      // combine the entries into one
+)
+
+var validOnDuplicate = validation.In(
+	OnDuplicateError, OnDuplicateFirstWins, OnDuplicateLastWins, OnDuplicateMerge,
+).Error("must be one of error, first_wins, last_wins or merge")
+
+// Duplicate describes a set of entries that shared an external ID, and where each came
+// from.
+type Duplicate struct {
+	ExternalID string
+	Origins    []string
+}
+
+func (d Duplicate) String() string {
+	return fmt.Sprintf("external_id='%s' produced by %d entries (%s)",
+		d.ExternalID, len(d.Origins), strings.Join(d.Origins, ", "))
+}
+
+// DuplicatesError is returned when entries share an external ID and the output's policy
+// is to error.
+type DuplicatesError []Duplicate
+
+func (d DuplicatesError) Error() string {
+	lines := []string{fmt.Sprintf("found %d external IDs shared by several entries (set on_duplicate to resolve them)", len(d))}
+	for _, duplicate := range d {
+		lines = append(lines, "  "+duplicate.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// ResolveDuplicates applies the output's on_duplicate policy to entries that share an
+// external ID, so that at most one entry per external ID is reconciled.
+//
+// It returns the resolved entries in their original order, alongside a report of each
+// clash. If the policy is to error, a DuplicatesError is returned instead.
+//
+// Without a policy, the last entry wins, which is what the importer has always done.
+func ResolveDuplicates(output *Output, entries []*CatalogEntryModel) ([]*CatalogEntryModel, []Duplicate, error) {
+	byExternalID := map[string][]*CatalogEntryModel{}
+	for _, entry := range entries {
+		if entry.ExternalID == "" {
+			continue // these are reported by ValidateEntries
+		}
+		byExternalID[entry.ExternalID] = append(byExternalID[entry.ExternalID], entry)
+	}
+
+	duplicates := []Duplicate{}
+	resolved := []*CatalogEntryModel{}
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		clashing := byExternalID[entry.ExternalID]
+		if len(clashing) < 2 {
+			resolved = append(resolved, entry)
+			continue
+		}
+		if seen[entry.ExternalID] {
+			continue
+		}
+		seen[entry.ExternalID] = true
+
+		duplicates = append(duplicates, Duplicate{
+			ExternalID: entry.ExternalID,
+			Origins: lo.Map(clashing, func(entry *CatalogEntryModel, _ int) string {
+				return lo.Ternary(entry.Origin != "", entry.Origin, "unknown")
+			}),
+		})
+
+		switch output.OnDuplicate.String {
+		case OnDuplicateFirstWins:
+			resolved = append(resolved, clashing[0])
+		case OnDuplicateLastWins, "":
+			resolved = append(resolved, clashing[len(clashing)-1])
+		case OnDuplicateMerge:
+			resolved = append(resolved, mergeEntries(output, clashing))
+		}
+	}
+
+	if len(duplicates) > 0 && output.OnDuplicate.String == OnDuplicateError {
+		return nil, duplicates, DuplicatesError(duplicates)
+	}
+
+	return resolved, duplicates, nil
+}
+
+// mergeEntries combines entries that share an external ID. The first entry provides the
+// name and rank, and the value of any attribute it sets. Later entries fill in attributes
+// the earlier ones left empty, while aliases and array attributes are combined from all
+// of them.
+func mergeEntries(output *Output, entries []*CatalogEntryModel) *CatalogEntryModel {
+	first := entries[0]
+	merged := &CatalogEntryModel{
+		ExternalID:      first.ExternalID,
+		Name:            first.Name,
+		Rank:            first.Rank,
+		Origin:          first.Origin,
+		Aliases:         []string{},
+		AttributeValues: map[string]client.CatalogEngineParamBindingPayloadV3{},
+	}
+
+	arrayAttributes := map[string]bool{}
+	for _, attr := range output.Attributes {
+		arrayAttributes[attr.ID] = attr.Array
+	}
+
+	for _, entry := range entries {
+		if merged.Name == "" {
+			merged.Name = entry.Name
+		}
+		for _, alias := range entry.Aliases {
+			if !lo.Contains(merged.Aliases, alias) {
+				merged.Aliases = append(merged.Aliases, alias)
+			}
+		}
+
+		for attributeID, binding := range entry.AttributeValues {
+			existing, ok := merged.AttributeValues[attributeID]
+			if !ok {
+				merged.AttributeValues[attributeID] = binding
+				continue
+			}
+			if !arrayAttributes[attributeID] || existing.ArrayValue == nil || binding.ArrayValue == nil {
+				continue // first value wins
+			}
+
+			arrayValue := append([]client.CatalogEngineParamBindingValuePayloadV3{}, *existing.ArrayValue...)
+			for _, value := range *binding.ArrayValue {
+				_, found := lo.Find(arrayValue, func(existingValue client.CatalogEngineParamBindingValuePayloadV3) bool {
+					return lo.FromPtr(existingValue.Literal) == lo.FromPtr(value.Literal)
+				})
+				if !found {
+					arrayValue = append(arrayValue, value)
+				}
+			}
+			merged.AttributeValues[attributeID] = client.CatalogEngineParamBindingPayloadV3{
+				ArrayValue: &arrayValue,
+			}
+		}
+	}
+
+	return merged
+}
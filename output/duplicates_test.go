@@ -0,0 +1,135 @@
+package output_test
+
+import (
+	"errors"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/samber/lo"
+	"gopkg.in/guregu/null.v3"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ResolveDuplicates", func() {
+	var (
+		op      *output.Output
+		entries []*output.CatalogEntryModel
+	)
+	BeforeEach(func() {
+		op = &output.Output{
+			Attributes: []*output.Attribute{
+				{ID: "owner", Type: null.StringFrom("String")},
+				{ID: "tags", Type: null.StringFrom("String"), Array: true},
+			},
+		}
+
+		literal := func(value string) *client.CatalogEngineParamBindingValuePayloadV3 {
+			return &client.CatalogEngineParamBindingValuePayloadV3{Literal: lo.ToPtr(value)}
+		}
+		entries = []*output.CatalogEntryModel{
+			{
+				ExternalID: "api",
+				Name:       "API",
+				Aliases:    []string{"api-service"},
+				Origin:     "local: services/api.yaml",
+				AttributeValues: map[string]client.CatalogEngineParamBindingPayloadV3{
+					"tags": {ArrayValue: &[]client.CatalogEngineParamBindingValuePayloadV3{*literal("go")}},
+				},
+			},
+			{
+				ExternalID: "web",
+				Name:       "Web",
+				Origin:     "local: services/web.yaml",
+			},
+			{
+				ExternalID: "api",
+				Name:       "API (legacy)",
+				Aliases:    []string{"api-legacy"},
+				Origin:     "local: legacy/api.yaml",
+				AttributeValues: map[string]client.CatalogEngineParamBindingPayloadV3{
+					"owner": {Value: literal("platform")},
+					"tags":  {ArrayValue: &[]client.CatalogEngineParamBindingValuePayloadV3{*literal("go"), *literal("legacy")}},
+				},
+			},
+		}
+	})
+
+	names := func(entries []*output.CatalogEntryModel) []string {
+		return lo.Map(entries, func(entry *output.CatalogEntryModel, _ int) string {
+			return entry.Name
+		})
+	}
+
+	It("keeps the last entry by default, reporting the clash", func() {
+		resolved, duplicates, err := output.ResolveDuplicates(op, entries)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(duplicates).To(Equal([]output.Duplicate{
+			{ExternalID: "api", Origins: []string{"local: services/api.yaml", "local: legacy/api.yaml"}},
+		}))
+		Expect(lo.Map(resolved, func(entry *output.CatalogEntryModel, _ int) string {
+			return entry.Origin
+		})).To(ContainElement("local: legacy/api.yaml"))
+		Expect(lo.Map(resolved, func(entry *output.CatalogEntryModel, _ int) string {
+			return entry.Origin
+		})).NotTo(ContainElement("local: services/api.yaml"))
+	})
+
+	It("errors with error, naming the clashing origins", func() {
+		op.OnDuplicate = null.StringFrom(output.OnDuplicateError)
+		_, duplicates, err := output.ResolveDuplicates(op, entries)
+		Expect(err).To(HaveOccurred())
+
+		var duplicatesErr output.DuplicatesError
+		Expect(errors.As(err, &duplicatesErr)).To(BeTrue())
+		Expect(duplicates).To(Equal([]output.Duplicate{
+			{ExternalID: "api", Origins: []string{"local: services/api.yaml", "local: legacy/api.yaml"}},
+		}))
+		Expect(err.Error()).To(ContainSubstring("local: legacy/api.yaml"))
+	})
+
+	It("leaves entries without duplicates alone", func() {
+		resolved, duplicates, err := output.ResolveDuplicates(op, entries[:2])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(duplicates).To(BeEmpty())
+		Expect(resolved).To(Equal(entries[:2]))
+	})
+
+	It("keeps the first entry with first_wins", func() {
+		op.OnDuplicate = null.StringFrom(output.OnDuplicateFirstWins)
+
+		resolved, duplicates, err := output.ResolveDuplicates(op, entries)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(duplicates).To(HaveLen(1))
+		Expect(names(resolved)).To(Equal([]string{"API", "Web"}))
+	})
+
+	It("keeps the last entry with last_wins", func() {
+		op.OnDuplicate = null.StringFrom(output.OnDuplicateLastWins)
+
+		resolved, _, err := output.ResolveDuplicates(op, entries)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names(resolved)).To(Equal([]string{"API (legacy)", "Web"}))
+	})
+
+	It("combines the entries with merge", func() {
+		op.OnDuplicate = null.StringFrom(output.OnDuplicateMerge)
+
+		resolved, _, err := output.ResolveDuplicates(op, entries)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names(resolved)).To(Equal([]string{"API", "Web"}))
+
+		merged := resolved[0]
+		Expect(merged.Aliases).To(Equal([]string{"api-service", "api-legacy"}))
+		Expect(*merged.AttributeValues["owner"].Value.Literal).To(Equal("platform"))
+		Expect(lo.Map(*merged.AttributeValues["tags"].ArrayValue, func(value client.CatalogEngineParamBindingValuePayloadV3, _ int) string {
+			return *value.Literal
+		})).To(Equal([]string{"go", "legacy"}))
+	})
+
+	It("rejects unknown policies", func() {
+		op.OnDuplicate = null.StringFrom("random")
+		Expect(op.Validate().Error()).To(ContainSubstring("on_duplicate: must be one of"))
+	})
+})
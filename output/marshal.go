@@ -23,7 +23,12 @@ type CatalogTypeModel struct {
 	Attributes          []client.CatalogTypeAttributePayloadV3
 	Categories          []string
 	SourceAttribute     *Attribute // tracks the origin attribute, if an enum model
+	ParentTypeName      string     // the type name of the output, if an enum model
 	SourceRepoUrl       string
+	Partitioned         bool              // if true, other importers may share this type
+	RenamedAttributes   map[string]string // attribute ID to the ID it was renamed from
+	// AllowSchemaDestruction permits schema changes that would lose attribute values.
+	AllowSchemaDestruction bool
 }
 
 type CatalogEntryModel struct {
@@ -32,6 +37,7 @@ type CatalogEntryModel struct {
 	Aliases         []string
 	Rank            int32
 	AttributeValues map[string]client.CatalogEngineParamBindingPayloadV3
+	Origin          string // where the source entry came from, if known
 }
 
 // MarshalType builds the base catalog type model for the output, and any associated enum
@@ -47,6 +53,10 @@ func MarshalType(output *Output) (base *CatalogTypeModel, enumTypes []*CatalogTy
 		UseNameAsIdentifier: output.UseNameAsIdentifier,
 		Attributes:          []client.CatalogTypeAttributePayloadV3{},
 		Categories:          output.Categories,
+		Partitioned:         output.Partition != nil,
+		RenamedAttributes:   map[string]string{},
+
+		AllowSchemaDestruction: output.AllowSchemaDestruction,
 	}
 	for _, attr := range output.Attributes {
 		var attrType string
@@ -65,7 +75,7 @@ func MarshalType(output *Output) (base *CatalogTypeModel, enumTypes []*CatalogTy
 			mode = client.CatalogTypeAttributePayloadV3ModeBacklink
 		case attr.Path != nil:
 			mode = client.CatalogTypeAttributePayloadV3ModePath
-		case attr.SchemaOnly:
+		case attr.IsSharedWithDashboard():
 			mode = client.CatalogTypeAttributePayloadV3ModeDashboard
 		default:
 			mode = client.CatalogTypeAttributePayloadV3ModeApi
@@ -89,6 +99,9 @@ func MarshalType(output *Output) (base *CatalogTypeModel, enumTypes []*CatalogTy
 		}
 
 		base.Attributes = append(base.Attributes, attribute)
+		if attr.RenamedFrom.Valid {
+			base.RenamedAttributes[attr.ID] = attr.RenamedFrom.String
+		}
 
 		// The enums we generate should be returned as types too, as we'll need to sync them
 		// just as any other.
@@ -121,6 +134,9 @@ func MarshalType(output *Output) (base *CatalogTypeModel, enumTypes []*CatalogTy
 				Ranked:          output.Ranked,
 				Attributes:      attributes,
 				SourceAttribute: lo.ToPtr(*attr),
+				ParentTypeName:  output.TypeName,
+
+				AllowSchemaDestruction: output.AllowSchemaDestruction,
 			})
 		}
 	}
@@ -256,7 +272,7 @@ func MarshalEntries(ctx context.Context, logger kitlog.Logger, output *Output, e
 			catalogEntryModel.Name = *name
 		}
 		if externalID != nil {
-			catalogEntryModel.ExternalID = *externalID
+			catalogEntryModel.ExternalID = output.Partition.ApplyExternalIDPrefix(*externalID)
 		}
 		if rank != nil {
 			catalogEntryModel.Rank = int32(*rank)
@@ -153,6 +153,58 @@ var _ = Describe("Marshalling data", func() {
 	})
 })
 
+var _ = Describe("Partition", func() {
+	var (
+		ctx    context.Context
+		logger kitlog.Logger
+		output *Output
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		logger = kitlog.NewNopLogger()
+		output = &Output{
+			Name:        "name",
+			Description: "description",
+			Source: SourceConfig{
+				Name:       "$.name",
+				ExternalID: "$.external_id",
+			},
+			Partition: &Partition{
+				ExternalIDPrefix: "platform/",
+			},
+		}
+	})
+
+	It("prefixes external IDs", func() {
+		res, err := MarshalEntries(ctx, logger, output, []source.Entry{
+			{"name": "API", "external_id": "api"},
+			{"name": "Worker", "external_id": "platform/worker"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res[0].ExternalID).To(Equal("platform/api"))
+		Expect(res[1].ExternalID).To(Equal("platform/worker"))
+	})
+
+	It("owns entries matching the prefix", func() {
+		owned, err := output.OwnsEntry(ctx, logger, client.CatalogEntryV3{ExternalId: lo.ToPtr("platform/api")})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(owned).To(BeTrue())
+
+		owned, err = output.OwnsEntry(ctx, logger, client.CatalogEntryV3{ExternalId: lo.ToPtr("data/api")})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(owned).To(BeFalse())
+	})
+
+	It("owns every entry when unpartitioned", func() {
+		output.Partition = nil
+
+		owned, err := output.OwnsEntry(ctx, logger, client.CatalogEntryV3{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(owned).To(BeTrue())
+	})
+})
+
 var _ = Describe("MarshalType", func() {
 	var (
 		output *Output
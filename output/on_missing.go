@@ -0,0 +1,70 @@
+package output
+
+import (
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"gopkg.in/guregu/null.v3"
+)
+
+// OnMissing controls what happens to catalog entries that are no longer produced by the
+// source. By default they are deleted immediately.
+type OnMissing struct {
+	// DeleteAfter is a grace period (e.g. 72h) that an entry must have been missing from
+	// source for before we delete it.
+	DeleteAfter null.String `json:"delete_after"`
+	// Archive marks missing entries as archived by setting an attribute, instead of (or
+	// until we get round to) deleting them.
+	Archive *OnMissingArchive `json:"archive"`
+}
+
+func (o OnMissing) Validate() error {
+	return validation.ValidateStruct(&o,
+		validation.Field(&o.DeleteAfter,
+			validation.Required.When(o.Archive == nil).Error("must provide either delete_after or archive"),
+			validation.By(func(value any) error {
+				if !o.DeleteAfter.Valid {
+					return nil
+				}
+				_, err := time.ParseDuration(o.DeleteAfter.String)
+				return err
+			}),
+		),
+		validation.Field(&o.Archive),
+	)
+}
+
+// GracePeriod returns how long an entry may be missing before it is deleted, and false if
+// missing entries should never be deleted.
+func (o *OnMissing) GracePeriod() (time.Duration, bool) {
+	if o == nil {
+		return 0, true // delete immediately
+	}
+	if !o.DeleteAfter.Valid {
+		return 0, false // archive only
+	}
+
+	gracePeriod, _ := time.ParseDuration(o.DeleteAfter.String) // checked by Validate
+	return gracePeriod, true
+}
+
+// OnMissingArchive configures the attribute we set to mark an entry as archived.
+type OnMissingArchive struct {
+	Attribute string      `json:"attribute"`
+	Value     null.String `json:"value"`
+}
+
+func (a OnMissingArchive) Validate() error {
+	return validation.ValidateStruct(&a,
+		validation.Field(&a.Attribute, validation.Required),
+	)
+}
+
+// ArchivedValue is the literal we set the archive attribute to, defaulting to true.
+func (a OnMissingArchive) ArchivedValue() string {
+	if !a.Value.Valid {
+		return "true"
+	}
+
+	return a.Value.String
+}
@@ -1,6 +1,7 @@
 package output
 
 import (
+	"fmt"
 	"regexp"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
@@ -14,6 +15,7 @@ type Output struct {
 	Name                string       `json:"name"`
 	Description         string       `json:"description"`
 	TypeName            string       `json:"type_name"`
+	PreviousTypeName    null.String  `json:"previous_type_name"`
 	Ranked              bool         `json:"ranked"`
 	Color               null.String  `json:"color"`
 	Icon                null.String  `json:"icon"`
@@ -21,6 +23,12 @@ type Output struct {
 	Source              SourceConfig `json:"source"`
 	Attributes          []*Attribute `json:"attributes"`
 	Categories          []string     `json:"categories"`
+	Partition           *Partition   `json:"partition"`
+	OnMissing           *OnMissing   `json:"on_missing"`
+	OnDuplicate         null.String  `json:"on_duplicate"`
+	// AllowSchemaDestruction permits schema changes that lose attribute values, such as
+	// removing an attribute or changing its type.
+	AllowSchemaDestruction bool `json:"allow_schema_destruction"`
 }
 
 func (o Output) Validate() error {
@@ -28,10 +36,53 @@ func (o Output) Validate() error {
 		validation.Field(&o.Name, validation.Required),
 		validation.Field(&o.Description, validation.Required),
 		validation.Field(&o.TypeName, validation.Required, validation.Match(regexp.MustCompile(`^Custom\["[a-zA-Z0-9]+"\]$`))),
+		validation.Field(&o.PreviousTypeName,
+			validation.Match(regexp.MustCompile(`^Custom\["[a-zA-Z0-9]+"\]$`)),
+			validation.NotIn(o.TypeName).Error("previous_type_name must be different from type_name"),
+		),
 		validation.Field(&o.Source, validation.Required),
+		validation.Field(&o.Partition),
+		validation.Field(&o.OnMissing, validation.By(func(value any) error {
+			if o.OnMissing == nil || o.OnMissing.Archive == nil {
+				return nil
+			}
+			for _, attr := range o.Attributes {
+				if attr.ID == o.OnMissing.Archive.Attribute {
+					return nil
+				}
+			}
+
+			return fmt.Errorf("archive attribute '%s' must be one of the output's attributes", o.OnMissing.Archive.Attribute)
+		})),
+		validation.Field(&o.OnDuplicate, validOnDuplicate),
+		validation.Field(&o.Attributes, validation.By(func(value any) error {
+			for _, attr := range o.Attributes {
+				if !attr.RenamedFrom.Valid {
+					continue
+				}
+				for _, other := range o.Attributes {
+					if other.ID == attr.RenamedFrom.String {
+						return fmt.Errorf("attribute '%s' is renamed_from '%s', which is still an attribute of this output", attr.ID, other.ID)
+					}
+				}
+			}
+
+			return nil
+		}), validation.Skip),
 	)
 }
 
+// ForEnum returns the output that should be used when reconciling the entries of one
+// of this output's enum types. Enum types are owned outright by the output that declares
+// them and have none of its attributes, so neither the partition nor the on_missing
+// policy applies: missing values are deleted.
+func (o Output) ForEnum() *Output {
+	o.Partition = nil
+	o.OnMissing = nil
+
+	return &o
+}
+
 // SourceConfig controls how we filter the source for this output's entries, and sets the
 // external ID – used to uniquely identify an entry in the catalog – and the aliases of
 // that entry from the source.
@@ -60,6 +111,9 @@ type Attribute struct {
 	BacklinkAttribute null.String    `json:"backlink_attribute"`
 	Path              []string       `json:"path"`
 	SchemaOnly        bool           `json:"schema_only"`
+	CreateOnly        bool           `json:"create_only"`
+	FillIfEmpty       bool           `json:"fill_if_empty"`
+	RenamedFrom       null.String    `json:"renamed_from"`
 }
 
 func (a Attribute) Validate() error {
@@ -74,9 +128,32 @@ func (a Attribute) Validate() error {
 			validation.Required.When(!a.Type.Valid).Error("enum is required if type is not set"),
 			validation.Empty.When(a.Type.Valid).Error("enum cannot be provided when type is set"),
 		),
+		validation.Field(&a.CreateOnly,
+			validation.Empty.When(a.SchemaOnly).Error("create_only cannot be set when schema_only is set"),
+			validation.Empty.When(a.FillIfEmpty).Error("create_only cannot be set when fill_if_empty is set"),
+			validation.Empty.When(a.isDerived()).Error("create_only cannot be set on backlink or path attributes"),
+		),
+		validation.Field(&a.FillIfEmpty,
+			validation.Empty.When(a.SchemaOnly).Error("fill_if_empty cannot be set when schema_only is set"),
+			validation.Empty.When(a.isDerived()).Error("fill_if_empty cannot be set on backlink or path attributes"),
+		),
+		validation.Field(&a.RenamedFrom,
+			validation.NotIn(a.ID).Error("renamed_from must be different from id"),
+			validation.Empty.When(a.isDerived()).Error("renamed_from cannot be set on backlink or path attributes"),
+		),
 	)
 }
 
+func (a Attribute) isDerived() bool {
+	return a.BacklinkAttribute.Valid || a.Path != nil
+}
+
+// IsSharedWithDashboard is true if the dashboard can edit the value of this attribute,
+// either because the importer never sets it or only seeds it with an initial value.
+func (a Attribute) IsSharedWithDashboard() bool {
+	return a.SchemaOnly || a.CreateOnly || a.FillIfEmpty
+}
+
 func (a Attribute) IncludeInPayload() bool {
 	if a.SchemaOnly {
 		// These are left for the dashboard to set
@@ -90,6 +167,10 @@ func (a Attribute) IncludeInPayload() bool {
 		// These are derived from other attributes
 		return false
 	}
+	if a.CreateOnly {
+		// Only set when the entry is first created, and never overwritten afterwards
+		return false
+	}
 
 	return true
 }
@@ -171,4 +171,36 @@ var _ = Describe("Attribute validation", func() {
 		}
 		Expect(attr.Validate()).To(HaveOccurred())
 	})
+
+	It("accepts create_only on its own", func() {
+		attr := output.Attribute{
+			ID:         "test",
+			Name:       "Test",
+			Type:       null.StringFrom("String"),
+			CreateOnly: true,
+		}
+		Expect(attr.Validate()).To(Succeed())
+	})
+
+	It("rejects combining ownership modes", func() {
+		attr := output.Attribute{
+			ID:          "test",
+			Name:        "Test",
+			Type:        null.StringFrom("String"),
+			CreateOnly:  true,
+			FillIfEmpty: true,
+		}
+		Expect(attr.Validate()).To(HaveOccurred())
+	})
+
+	It("rejects fill_if_empty on derived attributes", func() {
+		attr := output.Attribute{
+			ID:          "test",
+			Name:        "Test",
+			Type:        null.StringFrom("String"),
+			Path:        []string{"owner", "team"},
+			FillIfEmpty: true,
+		}
+		Expect(attr.Validate()).To(HaveOccurred())
+	})
 })
@@ -0,0 +1,95 @@
+package output
+
+import (
+	"context"
+	"strings"
+
+	kitlog "github.com/go-kit/log"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/expr"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Partition restricts an output to a subset of the entries in its catalog type. This
+// allows several importers (each with their own sync ID) to push entries into the same
+// catalog type without deleting each other's entries.
+type Partition struct {
+	// ExternalIDPrefix is prepended to the external ID of every entry this output
+	// produces, and any entry in the catalog with this prefix is considered ours.
+	ExternalIDPrefix string `json:"external_id_prefix"`
+	// OwnsEntries is an expression evaluated against each entry in the catalog, which
+	// returns true if this output owns that entry.
+	OwnsEntries null.String `json:"owns_entries"`
+}
+
+func (p Partition) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.ExternalIDPrefix,
+			validation.Required.When(!p.OwnsEntries.Valid).Error("must provide either external_id_prefix or owns_entries"),
+		),
+	)
+}
+
+// ApplyExternalIDPrefix prefixes the external ID with the partition prefix, if it isn't
+// already prefixed.
+func (p *Partition) ApplyExternalIDPrefix(externalID string) string {
+	if p == nil || strings.HasPrefix(externalID, p.ExternalIDPrefix) {
+		return externalID
+	}
+
+	return p.ExternalIDPrefix + externalID
+}
+
+// OwnsEntry returns true if the existing catalog entry belongs to this output, and is
+// therefore something the output can update or delete.
+//
+// Outputs without a partition own every entry in their catalog type. When both a prefix
+// and an expression are provided, the entry must match both.
+func (o *Output) OwnsEntry(ctx context.Context, logger kitlog.Logger, entry client.CatalogEntryV3) (bool, error) {
+	if o.Partition == nil {
+		return true, nil
+	}
+
+	if prefix := o.Partition.ExternalIDPrefix; prefix != "" {
+		if entry.ExternalId == nil || !strings.HasPrefix(*entry.ExternalId, prefix) {
+			return false, nil
+		}
+	}
+
+	if src := o.Partition.OwnsEntries; src.Valid {
+		owned, err := expr.EvaluateSingleValue[bool](ctx, logger, src.String, partitionSubject(entry))
+		if err != nil {
+			return false, errors.Wrap(err, "evaluating owns_entries")
+		}
+
+		return owned != nil && *owned, nil
+	}
+
+	return true, nil
+}
+
+// partitionSubject builds the value that owns_entries expressions are evaluated against,
+// shaped to mirror the entry as it appears in the API.
+func partitionSubject(entry client.CatalogEntryV3) map[string]any {
+	attributeValues := map[string]any{}
+	for attrID, binding := range entry.AttributeValues {
+		if binding.ArrayValue != nil {
+			attributeValues[attrID] = lo.Map(*binding.ArrayValue, func(value client.CatalogEntryEngineParamBindingValueV3, _ int) string {
+				return lo.FromPtr(value.Literal)
+			})
+		} else if binding.Value != nil {
+			attributeValues[attrID] = lo.FromPtr(binding.Value.Literal)
+		}
+	}
+
+	return map[string]any{
+		"id":               entry.Id,
+		"external_id":      lo.FromPtr(entry.ExternalId),
+		"name":             entry.Name,
+		"aliases":          entry.Aliases,
+		"attribute_values": attributeValues,
+	}
+}
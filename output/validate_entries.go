@@ -0,0 +1,164 @@
+package output
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// EntryProblem describes something wrong with a marshalled entry that would cause the
+// API to reject it, or to accept it in a way we didn't intend.
+type EntryProblem struct {
+	Index      int    // position of the entry in the marshalled list
+	ExternalID string // external ID of the entry, if it has one
+	Field      string // e.g. name, external_id, aliases or attribute_values.<id>
+	Message    string
+}
+
+func (p EntryProblem) String() string {
+	entry := fmt.Sprintf("entries.%d", p.Index)
+	if p.ExternalID != "" {
+		entry = fmt.Sprintf("%s (external_id='%s')", entry, p.ExternalID)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", entry, p.Field, p.Message)
+}
+
+// EntryProblems is every problem found when validating entries, returned as a single
+// error so they can be reported at once rather than failing on the first.
+type EntryProblems []EntryProblem
+
+func (p EntryProblems) Error() string {
+	lines := []string{fmt.Sprintf("found %d problems with entries", len(p))}
+	for _, problem := range p {
+		lines = append(lines, "  "+problem.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// ValidateEntries checks the marshalled entries of an output before we send them to the
+// API, returning EntryProblems if any are invalid.
+//
+// This catches expressions that return nothing for the name or external ID, values that
+// don't fit the type of their attribute (including empty enum values), and identifiers
+// that clash between entries.
+func ValidateEntries(output *Output, entries []*CatalogEntryModel) error {
+	attributeByID := map[string]*Attribute{}
+	for _, attr := range output.Attributes {
+		attributeByID[attr.ID] = attr
+	}
+
+	var (
+		problems        = EntryProblems{}
+		externalIDIndex = map[string]int{}
+		aliasIndex      = map[string]int{}
+	)
+	for idx, entry := range entries {
+		problem := func(field, format string, args ...any) {
+			problems = append(problems, EntryProblem{
+				Index:      idx,
+				ExternalID: entry.ExternalID,
+				Field:      field,
+				Message:    fmt.Sprintf(format, args...),
+			})
+		}
+
+		if entry.Name == "" {
+			problem("name", "must not be empty")
+		}
+		if entry.ExternalID == "" {
+			problem("external_id", "must not be empty")
+		} else if firstIdx, ok := externalIDIndex[entry.ExternalID]; ok {
+			problem("external_id", "duplicates the external ID of entries.%d", firstIdx)
+		} else {
+			externalIDIndex[entry.ExternalID] = idx
+		}
+
+		// Aliases identify an entry just as its external ID does, so they must be unique
+		// across all entries of the type.
+		for _, alias := range entry.Aliases {
+			if firstIdx, ok := aliasIndex[alias]; ok && firstIdx != idx {
+				problem("aliases", "alias '%s' is also used by entries.%d", alias, firstIdx)
+			} else {
+				aliasIndex[alias] = idx
+			}
+		}
+
+		for attributeID, binding := range entry.AttributeValues {
+			field := "attribute_values." + attributeID
+
+			attr, ok := attributeByID[attributeID]
+			if !ok {
+				problem(field, "is not an attribute of this output")
+				continue
+			}
+
+			switch {
+			case attr.Array && binding.Value != nil:
+				problem(field, "attribute is an array but was given a single value")
+				continue
+			case !attr.Array && binding.ArrayValue != nil:
+				problem(field, "attribute is not an array but was given an array value")
+				continue
+			}
+
+			literals := []*string{}
+			if binding.Value != nil {
+				literals = append(literals, binding.Value.Literal)
+			}
+			if binding.ArrayValue != nil {
+				for _, value := range *binding.ArrayValue {
+					literals = append(literals, value.Literal)
+				}
+			}
+
+			for _, literal := range literals {
+				if literal == nil {
+					continue // references rather than literals are checked by the API
+				}
+				if msg := validateLiteral(attr, *literal); msg != "" {
+					problem(field, "%s", msg)
+				}
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+
+	return nil
+}
+
+// validateLiteral checks a literal value conforms to the type of its attribute, returning
+// a description of the problem if it doesn't.
+func validateLiteral(attr *Attribute, literal string) string {
+	// Enum types are generated from the values we see, so any value is a member of them,
+	// but each becomes the name of an entry in the enum type, which can't be empty.
+	if attr.Enum != nil {
+		if literal == "" {
+			return fmt.Sprintf("value for enum %s must not be empty", attr.Enum.TypeName)
+		}
+
+		return ""
+	}
+
+	switch attr.Type.String {
+	case "Bool":
+		if _, err := strconv.ParseBool(literal); err != nil {
+			return fmt.Sprintf("value '%s' is not a valid Bool", literal)
+		}
+	case "Number":
+		if _, err := strconv.ParseFloat(literal, 64); err != nil {
+			return fmt.Sprintf("value '%s' is not a valid Number", literal)
+		}
+	case "Text", "String":
+		if !utf8.ValidString(literal) {
+			return fmt.Sprintf("value is not valid UTF-8 %s", attr.Type.String)
+		}
+	}
+
+	return ""
+}
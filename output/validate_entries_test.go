@@ -0,0 +1,116 @@
+package output_test
+
+import (
+	"errors"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/samber/lo"
+	"gopkg.in/guregu/null.v3"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ValidateEntries", func() {
+	var op *output.Output
+	BeforeEach(func() {
+		op = &output.Output{
+			Attributes: []*output.Attribute{
+				{ID: "description", Type: null.StringFrom("Text")},
+				{ID: "active", Type: null.StringFrom("Bool")},
+				{ID: "cost", Type: null.StringFrom("Number")},
+				{ID: "tags", Type: null.StringFrom("String"), Array: true},
+				{ID: "tier", Enum: &output.AttributeEnum{TypeName: `Custom["Tier"]`}},
+			},
+		}
+	})
+
+	single := func(literal string) client.CatalogEngineParamBindingPayloadV3 {
+		return client.CatalogEngineParamBindingPayloadV3{
+			Value: &client.CatalogEngineParamBindingValuePayloadV3{Literal: lo.ToPtr(literal)},
+		}
+	}
+	array := func(literals ...string) client.CatalogEngineParamBindingPayloadV3 {
+		return client.CatalogEngineParamBindingPayloadV3{
+			ArrayValue: lo.ToPtr(lo.Map(literals, func(literal string, _ int) client.CatalogEngineParamBindingValuePayloadV3 {
+				return client.CatalogEngineParamBindingValuePayloadV3{Literal: lo.ToPtr(literal)}
+			})),
+		}
+	}
+
+	problemsFor := func(entries ...*output.CatalogEntryModel) []string {
+		err := output.ValidateEntries(op, entries)
+		if err == nil {
+			return nil
+		}
+
+		var problems output.EntryProblems
+		Expect(errors.As(err, &problems)).To(BeTrue())
+
+		return lo.Map(problems, func(problem output.EntryProblem, _ int) string {
+			return problem.String()
+		})
+	}
+
+	It("accepts valid entries", func() {
+		Expect(problemsFor(
+			&output.CatalogEntryModel{
+				ExternalID: "one",
+				Name:       "One",
+				Aliases:    []string{"first"},
+				AttributeValues: map[string]client.CatalogEngineParamBindingPayloadV3{
+					"description": single("The first"),
+					"active":      single("true"),
+					"cost":        single("12.5"),
+					"tags":        array("a", "b"),
+					"tier":        single("gold"),
+				},
+			},
+			&output.CatalogEntryModel{ExternalID: "two", Name: "Two", Aliases: []string{"second"}},
+		)).To(BeEmpty())
+	})
+
+	It("reports every problem at once", func() {
+		Expect(problemsFor(
+			&output.CatalogEntryModel{
+				ExternalID: "one",
+				Name:       "",
+				AttributeValues: map[string]client.CatalogEngineParamBindingPayloadV3{
+					"active": single("yes"),
+					"cost":   single("lots"),
+					"tags":   single("a"),
+					"tier":   single(""),
+				},
+			},
+			&output.CatalogEntryModel{
+				ExternalID: "",
+				Name:       "Missing ID",
+				AttributeValues: map[string]client.CatalogEngineParamBindingPayloadV3{
+					"description": array("a"),
+					"unknown":     single("value"),
+				},
+			},
+		)).To(ConsistOf(
+			"entries.0 (external_id='one'): name: must not be empty",
+			"entries.0 (external_id='one'): attribute_values.active: value 'yes' is not a valid Bool",
+			"entries.0 (external_id='one'): attribute_values.cost: value 'lots' is not a valid Number",
+			"entries.0 (external_id='one'): attribute_values.tags: attribute is an array but was given a single value",
+			`entries.0 (external_id='one'): attribute_values.tier: value for enum Custom["Tier"] must not be empty`,
+			"entries.1: external_id: must not be empty",
+			"entries.1: attribute_values.description: attribute is not an array but was given an array value",
+			"entries.1: attribute_values.unknown: is not an attribute of this output",
+		))
+	})
+
+	It("reports duplicate external IDs and alias collisions", func() {
+		Expect(problemsFor(
+			&output.CatalogEntryModel{ExternalID: "one", Name: "One", Aliases: []string{"shared"}},
+			&output.CatalogEntryModel{ExternalID: "one", Name: "One again"},
+			&output.CatalogEntryModel{ExternalID: "three", Name: "Three", Aliases: []string{"shared"}},
+		)).To(ConsistOf(
+			"entries.1 (external_id='one'): external_id: duplicates the external ID of entries.0",
+			"entries.2 (external_id='three'): aliases: alias 'shared' is also used by entries.0",
+		))
+	})
+})
@@ -0,0 +1,196 @@
+package profile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// File is a profiles file, holding named connections to incident.io so they don't need
+// to be passed to every command as --api-endpoint and --api-key.
+type File struct {
+	Default  string              `json:"default,omitempty"` // used when no --profile is given
+	Profiles map[string]*Profile `json:"profiles"`
+}
+
+// Profile is a named connection to an incident.io workspace.
+type Profile struct {
+	APIEndpoint  string            `json:"api_endpoint,omitempty"`   // defaults to https://api.incident.io
+	APIKey       KeySource         `json:"api_key"`                  // where to read the API key from
+	SyncIDSuffix string            `json:"sync_id_suffix,omitempty"` // appended to the config's sync_id
+	Flags        map[string]string `json:"flags,omitempty"`          // default flags, e.g. catalog-entries-api-page-size: 100
+}
+
+func (p Profile) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.APIKey),
+	)
+}
+
+// KeySource is where a profile reads its API key from. Exactly one must be set.
+type KeySource struct {
+	Env     string   `json:"env,omitempty"`     // environment variable holding the key
+	File    string   `json:"file,omitempty"`    // file containing the key, ~ is expanded
+	Command []string `json:"command,omitempty"` // command printing the key, e.g. [op, read, op://...]
+}
+
+func (k KeySource) Validate() error {
+	if lo.Count([]bool{k.Env != "", k.File != "", len(k.Command) > 0}, true) != 1 {
+		return errors.New("must set exactly one of env, file or command")
+	}
+
+	return nil
+}
+
+func (k KeySource) String() string {
+	switch {
+	case k.Env != "":
+		return fmt.Sprintf("env (%s)", k.Env)
+	case k.File != "":
+		return fmt.Sprintf("file (%s)", k.File)
+	case len(k.Command) > 0:
+		return fmt.Sprintf("command (%s)", strings.Join(k.Command, " "))
+	default:
+		return "none"
+	}
+}
+
+// Resolve reads the API key, trimming any surrounding whitespace.
+func (k KeySource) Resolve(ctx context.Context) (string, error) {
+	var key string
+	switch {
+	case k.Env != "":
+		key = os.Getenv(k.Env)
+		if key == "" {
+			return "", fmt.Errorf("environment variable %s is not set", k.Env)
+		}
+	case k.File != "":
+		data, err := os.ReadFile(expandHome(k.File))
+		if err != nil {
+			return "", errors.Wrap(err, "reading API key file")
+		}
+		key = string(data)
+	case len(k.Command) > 0:
+		cmd := exec.CommandContext(ctx, k.Command[0], k.Command[1:]...)
+
+		var output bytes.Buffer
+		cmd.Stdout = &output
+		cmd.Stderr = os.Stderr // so password managers can prompt
+
+		if err := cmd.Run(); err != nil {
+			return "", errors.Wrap(err, "running API key command")
+		}
+		key = output.String()
+	default:
+		return "", errors.New("no API key source set")
+	}
+
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", fmt.Errorf("API key from %s is empty", k)
+	}
+
+	return key, nil
+}
+
+// DefaultPath is where we look for the profiles file, following the XDG convention of
+// $XDG_CONFIG_HOME falling back to ~/.config.
+func DefaultPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = expandHome("~/.config")
+	}
+
+	return filepath.Join(dir, "catalog-importer", "profiles.yaml")
+}
+
+// Load reads a profiles file. A missing file has no profiles, as most people won't need
+// one.
+func Load(filename string) (*File, error) {
+	file := &File{Profiles: map[string]*Profile{}}
+
+	data, err := os.ReadFile(expandHome(filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return file, nil
+		}
+
+		return nil, errors.Wrap(err, "reading profiles file")
+	}
+
+	if err := yaml.Unmarshal(data, file); err != nil {
+		return nil, errors.Wrap(err, "parsing profiles file")
+	}
+	if file.Profiles == nil {
+		file.Profiles = map[string]*Profile{}
+	}
+	for name, profile := range file.Profiles {
+		if profile == nil {
+			file.Profiles[name] = &Profile{}
+		}
+	}
+
+	if err := validation.Validate(file.Profiles); err != nil {
+		return nil, errors.Wrap(err, "validating profiles file")
+	}
+	if file.Default != "" {
+		if _, ok := file.Profiles[file.Default]; !ok {
+			return nil, fmt.Errorf("default profile '%s' does not exist", file.Default)
+		}
+	}
+
+	return file, nil
+}
+
+// Names returns the names of the profiles, in a stable order.
+func (f *File) Names() []string {
+	names := lo.Keys(f.Profiles)
+	sort.Strings(names)
+
+	return names
+}
+
+// Get returns the named profile, or the default if name is empty. It returns nil if no
+// name was given and there is no default.
+func (f *File) Get(name string) (*Profile, error) {
+	if name == "" {
+		name = f.Default
+	}
+	if name == "" {
+		return nil, nil
+	}
+
+	profile, ok := f.Profiles[name]
+	if !ok {
+		if len(f.Profiles) == 0 {
+			return nil, fmt.Errorf("no profile named '%s', as there are no profiles", name)
+		}
+
+		return nil, fmt.Errorf("no profile named '%s' (found %s)", name, strings.Join(f.Names(), ", "))
+	}
+
+	return profile, nil
+}
+
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
@@ -0,0 +1,123 @@
+package profile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Profiles", func() {
+	var filename string
+	write := func(content string) {
+		filename = filepath.Join(GinkgoT().TempDir(), "profiles.yaml")
+		Expect(os.WriteFile(filename, []byte(content), 0o600)).To(Succeed())
+	}
+
+	Describe("Load", func() {
+		It("loads profiles", func() {
+			write(`
+default: staging
+profiles:
+  staging:
+    api_endpoint: https://staging.incident.io
+    api_key:
+      env: INCIDENT_API_KEY_STAGING
+    sync_id_suffix: -staging
+    flags:
+      catalog-entries-api-page-size: "100"
+  prod:
+    api_key:
+      command: [op, read, "op://incident/prod/key"]
+`)
+			file, err := Load(filename)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(file.Names()).To(Equal([]string{"prod", "staging"}))
+
+			profile, err := file.Get("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(profile.APIEndpoint).To(Equal("https://staging.incident.io"))
+			Expect(profile.SyncIDSuffix).To(Equal("-staging"))
+			Expect(profile.Flags).To(Equal(map[string]string{"catalog-entries-api-page-size": "100"}))
+
+			profile, err = file.Get("prod")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(profile.APIKey.Command).To(Equal([]string{"op", "read", "op://incident/prod/key"}))
+		})
+
+		It("has no profiles if the file doesn't exist", func() {
+			file, err := Load(filepath.Join(GinkgoT().TempDir(), "missing.yaml"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(file.Profiles).To(BeEmpty())
+
+			profile, err := file.Get("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(profile).To(BeNil())
+		})
+
+		It("errors for an unknown profile", func() {
+			write(`
+profiles:
+  prod:
+    api_key: { env: INCIDENT_API_KEY }
+`)
+			file, err := Load(filename)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = file.Get("staging")
+			Expect(err).To(MatchError("no profile named 'staging' (found prod)"))
+		})
+
+		It("requires exactly one API key source", func() {
+			write(`
+profiles:
+  prod:
+    api_key: { env: INCIDENT_API_KEY, file: ~/.incident-key }
+`)
+			_, err := Load(filename)
+			Expect(err).To(MatchError(ContainSubstring("must set exactly one of env, file or command")))
+		})
+
+		It("requires the default profile to exist", func() {
+			write(`
+default: staging
+profiles:
+  prod:
+    api_key: { env: INCIDENT_API_KEY }
+`)
+			_, err := Load(filename)
+			Expect(err).To(MatchError("default profile 'staging' does not exist"))
+		})
+	})
+
+	Describe("KeySource.Resolve", func() {
+		ctx := context.Background()
+
+		It("reads from the environment", func() {
+			GinkgoT().Setenv("INCIDENT_API_KEY_TEST", "env-key")
+			Expect(KeySource{Env: "INCIDENT_API_KEY_TEST"}.Resolve(ctx)).To(Equal("env-key"))
+		})
+
+		It("errors if the environment variable isn't set", func() {
+			_, err := KeySource{Env: "INCIDENT_API_KEY_MISSING"}.Resolve(ctx)
+			Expect(err).To(MatchError("environment variable INCIDENT_API_KEY_MISSING is not set"))
+		})
+
+		It("reads from a file, trimming whitespace", func() {
+			keyFile := filepath.Join(GinkgoT().TempDir(), "key")
+			Expect(os.WriteFile(keyFile, []byte("file-key\n"), 0o600)).To(Succeed())
+			Expect(KeySource{File: keyFile}.Resolve(ctx)).To(Equal("file-key"))
+		})
+
+		It("reads from a command", func() {
+			Expect(KeySource{Command: []string{"echo", "command-key"}}.Resolve(ctx)).To(Equal("command-key"))
+		})
+
+		It("errors if the command prints nothing", func() {
+			_, err := KeySource{Command: []string{"true"}}.Resolve(ctx)
+			Expect(err).To(MatchError("API key from command (true) is empty"))
+		})
+	})
+})
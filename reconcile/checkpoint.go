@@ -0,0 +1,395 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// checkpointSaveInterval throttles how often we flush the checkpoint to disk while
+// entries are being created or updated, as large syncs can make thousands of calls.
+var checkpointSaveInterval = 5 * time.Second
+
+// Checkpoint records the progress of a sync to a local file, so that an interrupted sync
+// can be resumed without redoing the work that had already completed.
+//
+// All methods are safe to call on a nil checkpoint, in which case they do nothing. This
+// means callers (such as a dry-run) can opt-out of checkpointing by passing nil.
+type Checkpoint struct {
+	SyncID     string `json:"sync_id"`
+	ConfigHash string `json:"config_hash"`
+
+	// Schemas maps catalog type ID to the schema version we left it in after syncing the
+	// type schema.
+	Schemas map[string]int64 `json:"schemas"`
+	// Pipelines lists the index of each pipeline that has been fully synced.
+	Pipelines []int `json:"pipelines"`
+	// Outputs tracks entry reconciliation for each catalog type, keyed by type name.
+	Outputs map[string]*OutputCheckpoint `json:"outputs"`
+
+	filename  string
+	mu        sync.Mutex
+	lastSaved time.Time
+	removed   bool
+}
+
+// OutputCheckpoint tracks the entries we've written for a single catalog type.
+type OutputCheckpoint struct {
+	CatalogTypeID string   `json:"catalog_type_id"`
+	SchemaVersion int64    `json:"schema_version"`
+	Completed     bool     `json:"completed"`
+	Created       []string `json:"created"` // external IDs of created entries
+}
+
+// NewCheckpoint creates an empty checkpoint that will be saved to the given file.
+func NewCheckpoint(filename, syncID, configHash string) *Checkpoint {
+	return &Checkpoint{
+		SyncID:     syncID,
+		ConfigHash: configHash,
+		Schemas:    map[string]int64{},
+		Pipelines:  []int{},
+		Outputs:    map[string]*OutputCheckpoint{},
+		filename:   filename,
+	}
+}
+
+// LoadCheckpoint reads a checkpoint from disk, returning nil if no checkpoint exists.
+func LoadCheckpoint(filename string) (*Checkpoint, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "reading checkpoint")
+	}
+
+	checkpoint := NewCheckpoint(filename, "", "")
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, errors.Wrap(err, "parsing checkpoint")
+	}
+
+	return checkpoint, nil
+}
+
+// Matches returns true if the checkpoint was produced by a sync of the same config.
+func (c *Checkpoint) Matches(syncID, configHash string) bool {
+	if c == nil {
+		return false
+	}
+
+	return c.SyncID == syncID && c.ConfigHash == configHash
+}
+
+// Save writes the checkpoint to disk.
+func (c *Checkpoint) Save() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.save()
+}
+
+func (c *Checkpoint) save() error {
+	if c.removed {
+		return nil // we've finished, so there's nothing to resume
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshalling checkpoint")
+	}
+
+	// Write to a temporary file first so we never leave a half-written checkpoint if
+	// we're interrupted mid-write.
+	tmp := c.filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return errors.Wrap(err, "writing checkpoint")
+	}
+	if err := os.Rename(tmp, c.filename); err != nil {
+		return errors.Wrap(err, "writing checkpoint")
+	}
+
+	c.lastSaved = time.Now()
+	return nil
+}
+
+// saveThrottled saves the checkpoint if we haven't done so recently. Errors are ignored,
+// as failing to checkpoint should never fail the sync itself.
+func (c *Checkpoint) saveThrottled() {
+	if time.Since(c.lastSaved) > checkpointSaveInterval {
+		_ = c.save()
+	}
+}
+
+// Remove deletes the checkpoint file, which we do once a sync completes successfully.
+func (c *Checkpoint) Remove() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removed = true
+	err := os.Remove(c.filename)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "removing checkpoint")
+	}
+
+	return nil
+}
+
+// SchemaSynced returns true if we've already synced the schema of this catalog type, and
+// it hasn't changed since.
+func (c *Checkpoint) SchemaSynced(catalogType *client.CatalogTypeV3) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	version, ok := c.Schemas[catalogType.Id]
+	return ok && version == catalogType.Schema.Version
+}
+
+// MarkSchemaSynced records the schema version of a catalog type once it has been synced.
+func (c *Checkpoint) MarkSchemaSynced(catalogTypeID string, version int64) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Schemas[catalogTypeID] = version
+	return c.save()
+}
+
+// PipelineCompleted returns true if every output in the pipeline has been synced.
+func (c *Checkpoint) PipelineCompleted(idx int) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return lo.Contains(c.Pipelines, idx)
+}
+
+// MarkPipelineCompleted records that the pipeline at this index has been synced.
+func (c *Checkpoint) MarkPipelineCompleted(idx int) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Pipelines = append(c.Pipelines, idx)
+	return c.save()
+}
+
+// OutputCompleted returns true if the entries of this catalog type have been reconciled
+// against a schema that matches the one we last synced.
+func (c *Checkpoint) OutputCompleted(catalogType *client.CatalogTypeV3) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	output, ok := c.Outputs[catalogType.TypeName]
+	if !ok || !output.Completed || output.CatalogTypeID != catalogType.Id {
+		return false
+	}
+
+	version, ok := c.Schemas[catalogType.Id]
+	return ok && version == output.SchemaVersion
+}
+
+// MarkOutputCompleted records that all entries of this catalog type have been reconciled.
+func (c *Checkpoint) MarkOutputCompleted(catalogType *client.CatalogTypeV3) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	output := c.output(catalogType)
+	output.Completed = true
+
+	return c.save()
+}
+
+// output returns the checkpoint for this catalog type, creating it if needed. It must be
+// called while holding the lock.
+func (c *Checkpoint) output(catalogType *client.CatalogTypeV3) *OutputCheckpoint {
+	output, ok := c.Outputs[catalogType.TypeName]
+	if !ok {
+		output = &OutputCheckpoint{
+			CatalogTypeID: catalogType.Id,
+			Created:       []string{},
+		}
+		c.Outputs[catalogType.TypeName] = output
+	}
+
+	return output
+}
+
+// WrapEntriesClient returns a client that records each entry we create into the
+// checkpoint, and skips those we'd already created before the sync was interrupted.
+//
+// Updates aren't skipped: the source may have changed since the interrupted sync, and
+// entries that are already up-to-date drop out of the diff against the catalog anyway.
+//
+// When listing entries it also validates any progress we'd previously recorded for the
+// type: if the schema version has moved or any entry we think we wrote is missing from
+// the catalog, the progress is discarded and the type is reconciled from scratch.
+func (c *Checkpoint) WrapEntriesClient(logger kitlog.Logger, cl EntriesClient) EntriesClient {
+	if c == nil {
+		return cl
+	}
+
+	var (
+		catalogTypeMu sync.Mutex
+		catalogTypes  = map[string]*client.CatalogTypeV3{} // by ID
+		resumed       = map[string]*resumedOutput{}        // by catalog type ID
+	)
+	catalogTypeByID := func(id string) (*client.CatalogTypeV3, *resumedOutput) {
+		catalogTypeMu.Lock()
+		defer catalogTypeMu.Unlock()
+
+		return catalogTypes[id], resumed[id]
+	}
+
+	return EntriesClient{
+		GetEntries: func(ctx context.Context, catalogTypeID string, pageSize int) (*client.CatalogTypeV3, []client.CatalogEntryV3, error) {
+			catalogType, entries, err := cl.GetEntries(ctx, catalogTypeID, pageSize)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			var progress *resumedOutput
+			logger := kitlog.With(logger, "catalog_type_id", catalogType.Id, "catalog_type_name", catalogType.TypeName)
+			if output, ok := c.Outputs[catalogType.TypeName]; ok {
+				if valid, reason := output.validate(catalogType, entries); valid {
+					logger.Log("msg", "resuming catalog type from checkpoint", "created", len(output.Created))
+					progress = newResumedOutput(output, entries)
+				} else {
+					logger.Log("msg", "discarding checkpoint for catalog type", "reason", reason)
+					delete(c.Outputs, catalogType.TypeName)
+				}
+			}
+
+			output := c.output(catalogType)
+			output.SchemaVersion = catalogType.Schema.Version
+
+			catalogTypeMu.Lock()
+			catalogTypes[catalogType.Id], resumed[catalogType.Id] = catalogType, progress
+			catalogTypeMu.Unlock()
+
+			return catalogType, entries, nil
+		},
+		Delete:                cl.Delete,
+		BulkUpdate:            cl.BulkUpdate,
+		UpdateTypeAnnotations: cl.UpdateTypeAnnotations,
+		Create: func(ctx context.Context, payload client.CatalogCreateEntryPayloadV3) (*client.CatalogEntryV3, error) {
+			catalogType, progress := catalogTypeByID(payload.CatalogTypeId)
+			if entry, ok := progress.created(payload.ExternalId); ok {
+				logger.Log("msg", "entry was created before the sync was interrupted, skipping", "external_id", *payload.ExternalId)
+				return entry, nil
+			}
+
+			entry, err := cl.Create(ctx, payload)
+			if err != nil {
+				return nil, err
+			}
+
+			if catalogType != nil && payload.ExternalId != nil {
+				c.mu.Lock()
+				defer c.mu.Unlock()
+
+				output := c.output(catalogType)
+				output.Created = append(output.Created, *payload.ExternalId)
+				c.saveThrottled()
+			}
+
+			return entry, nil
+		},
+	}
+}
+
+// resumedOutput is the progress an interrupted sync made against a catalog type, which a
+// resumed sync can skip. It's safe to use when nil, in which case nothing is skipped.
+type resumedOutput struct {
+	createdEntries map[string]*client.CatalogEntryV3 // by external ID
+}
+
+func newResumedOutput(output *OutputCheckpoint, entries []client.CatalogEntryV3) *resumedOutput {
+	progress := &resumedOutput{
+		createdEntries: map[string]*client.CatalogEntryV3{},
+	}
+	created := lo.SliceToMap(output.Created, func(externalID string) (string, bool) {
+		return externalID, true
+	})
+	for _, entry := range entries {
+		if entry.ExternalId != nil && created[*entry.ExternalId] {
+			progress.createdEntries[*entry.ExternalId] = lo.ToPtr(entry)
+		}
+	}
+	return progress
+}
+
+// created returns the entry if we created it before the sync was interrupted.
+func (r *resumedOutput) created(externalID *string) (*client.CatalogEntryV3, bool) {
+	if r == nil || externalID == nil {
+		return nil, false
+	}
+
+	entry, ok := r.createdEntries[*externalID]
+	return entry, ok
+}
+
+// validate checks that progress recorded against this catalog type still holds, given
+// the current schema and entry listing.
+func (o *OutputCheckpoint) validate(catalogType *client.CatalogTypeV3, entries []client.CatalogEntryV3) (bool, string) {
+	if o.CatalogTypeID != catalogType.Id {
+		return false, "catalog type ID has changed"
+	}
+	if o.SchemaVersion != catalogType.Schema.Version {
+		return false, "schema version has changed"
+	}
+
+	externalIDs := map[string]bool{}
+	for _, entry := range entries {
+		if entry.ExternalId != nil {
+			externalIDs[*entry.ExternalId] = true
+		}
+	}
+
+	for _, externalID := range o.Created {
+		if !externalIDs[externalID] {
+			return false, "created entry is missing from catalog"
+		}
+	}
+
+	return true, ""
+}
@@ -0,0 +1,178 @@
+package reconcile_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+	"github.com/samber/lo"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Checkpoint", func() {
+	var (
+		ctx      context.Context
+		logger   kitlog.Logger
+		filename string
+	)
+	BeforeEach(func() {
+		ctx = context.Background()
+		logger = kitlog.NewNopLogger()
+		filename = filepath.Join(GinkgoT().TempDir(), "checkpoint.json")
+	})
+
+	var (
+		catalogType     *client.CatalogTypeV3
+		existingEntries []client.CatalogEntryV3
+		created         []string
+		updated         []string
+		mockClient      reconcile.EntriesClient
+	)
+	BeforeEach(func() {
+		catalogType = &client.CatalogTypeV3{
+			Id:       "type-123",
+			TypeName: `Custom["Service"]`,
+			Schema:   client.CatalogTypeSchemaV3{Version: 3},
+		}
+		existingEntries = []client.CatalogEntryV3{}
+		created, updated = []string{}, []string{}
+
+		mockClient = reconcile.EntriesClient{
+			GetEntries: func(ctx context.Context, catalogTypeID string, pageSize int) (*client.CatalogTypeV3, []client.CatalogEntryV3, error) {
+				return catalogType, existingEntries, nil
+			},
+			Create: func(ctx context.Context, payload client.CatalogCreateEntryPayloadV3) (*client.CatalogEntryV3, error) {
+				created = append(created, *payload.ExternalId)
+				return &client.CatalogEntryV3{Id: "entry-" + *payload.ExternalId, ExternalId: payload.ExternalId}, nil
+			},
+			Delete: func(ctx context.Context, entry *client.CatalogEntryV3) error {
+				return nil
+			},
+			BulkUpdate: func(ctx context.Context, catalogTypeID string, entries []client.PartialEntryPayloadV3, updateAttributes *[]string) error {
+				for _, entry := range entries {
+					updated = append(updated, entry.EntryId)
+				}
+				return nil
+			},
+		}
+	})
+
+	entryModels := []*output.CatalogEntryModel{
+		{ExternalID: "ext-1", Name: "One", Aliases: []string{}},
+		{ExternalID: "ext-2", Name: "Two", Aliases: []string{}},
+	}
+
+	reconcileWith := func(checkpoint *reconcile.Checkpoint) {
+		err := reconcile.Entries(ctx, logger, checkpoint.WrapEntriesClient(logger, mockClient),
+			&output.Output{}, catalogType, entryModels, nil, 100)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("records created entries and completed outputs", func() {
+		checkpoint := reconcile.NewCheckpoint(filename, "sync-id", "hash")
+		Expect(checkpoint.MarkSchemaSynced(catalogType.Id, 3)).To(Succeed())
+
+		reconcileWith(checkpoint)
+		Expect(checkpoint.MarkOutputCompleted(catalogType)).To(Succeed())
+
+		loaded, err := reconcile.LoadCheckpoint(filename)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(loaded.Matches("sync-id", "hash")).To(BeTrue())
+		Expect(loaded.Matches("sync-id", "other-hash")).To(BeFalse())
+		Expect(loaded.Outputs[catalogType.TypeName].Created).To(ConsistOf("ext-1", "ext-2"))
+		Expect(loaded.OutputCompleted(catalogType)).To(BeTrue())
+	})
+
+	It("treats the output as incomplete once the schema changes", func() {
+		checkpoint := reconcile.NewCheckpoint(filename, "sync-id", "hash")
+		Expect(checkpoint.MarkSchemaSynced(catalogType.Id, 3)).To(Succeed())
+		reconcileWith(checkpoint)
+		Expect(checkpoint.MarkOutputCompleted(catalogType)).To(Succeed())
+
+		Expect(checkpoint.MarkSchemaSynced(catalogType.Id, 4)).To(Succeed())
+		Expect(checkpoint.OutputCompleted(catalogType)).To(BeFalse())
+	})
+
+	It("discards progress when a created entry is missing from the catalog", func() {
+		checkpoint := reconcile.NewCheckpoint(filename, "sync-id", "hash")
+		reconcileWith(checkpoint)
+		Expect(created).To(HaveLen(2))
+
+		// Only one of the entries we created is still in the catalog, so the checkpoint
+		// can't be trusted.
+		existingEntries = []client.CatalogEntryV3{
+			{Id: "entry-ext-1", ExternalId: lo.ToPtr("ext-1"), Name: "One", Aliases: []string{}},
+		}
+		created = []string{}
+		reconcileWith(checkpoint)
+
+		Expect(created).To(ConsistOf("ext-2"))
+		Expect(checkpoint.Outputs[catalogType.TypeName].Created).To(ConsistOf("ext-2"))
+	})
+
+	It("updates entries that have changed since the sync was interrupted", func() {
+		// ext-1 was updated by the interrupted sync but has since changed in source, while
+		// ext-2 still matches.
+		existingEntries = []client.CatalogEntryV3{
+			{Id: "entry-ext-1", ExternalId: lo.ToPtr("ext-1"), Name: "Old one", Aliases: []string{}},
+			{Id: "entry-ext-2", ExternalId: lo.ToPtr("ext-2"), Name: "Two", Aliases: []string{}},
+		}
+
+		checkpoint := reconcile.NewCheckpoint(filename, "sync-id", "hash")
+		checkpoint.Outputs[catalogType.TypeName] = &reconcile.OutputCheckpoint{
+			CatalogTypeID: catalogType.Id,
+			SchemaVersion: catalogType.Schema.Version,
+			Created:       []string{},
+		}
+		reconcileWith(checkpoint)
+
+		Expect(updated).To(ConsistOf("entry-ext-1"))
+	})
+
+	It("skips entries that were created before the sync was interrupted", func() {
+		existingEntries = []client.CatalogEntryV3{
+			{Id: "entry-ext-1", ExternalId: lo.ToPtr("ext-1"), Name: "One", Aliases: []string{}},
+		}
+
+		checkpoint := reconcile.NewCheckpoint(filename, "sync-id", "hash")
+		checkpoint.Outputs[catalogType.TypeName] = &reconcile.OutputCheckpoint{
+			CatalogTypeID: catalogType.Id,
+			SchemaVersion: catalogType.Schema.Version,
+			Created:       []string{"ext-1"},
+		}
+
+		wrapped := checkpoint.WrapEntriesClient(logger, mockClient)
+		_, _, err := wrapped.GetEntries(ctx, catalogType.Id, 100)
+		Expect(err).NotTo(HaveOccurred())
+
+		entry, err := wrapped.Create(ctx, client.CatalogCreateEntryPayloadV3{
+			CatalogTypeId: catalogType.Id, ExternalId: lo.ToPtr("ext-1"), Name: "One",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entry.Id).To(Equal("entry-ext-1"))
+		Expect(created).To(BeEmpty())
+	})
+
+	It("removes the checkpoint file once finished", func() {
+		checkpoint := reconcile.NewCheckpoint(filename, "sync-id", "hash")
+		Expect(checkpoint.Save()).To(Succeed())
+		Expect(checkpoint.Remove()).To(Succeed())
+		Expect(checkpoint.Save()).To(Succeed())
+
+		_, err := os.Stat(filename)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("is a no-op when nil", func() {
+		var checkpoint *reconcile.Checkpoint
+		Expect(checkpoint.Save()).To(Succeed())
+		Expect(checkpoint.OutputCompleted(catalogType)).To(BeFalse())
+		reconcileWith(checkpoint)
+	})
+})
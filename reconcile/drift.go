@@ -0,0 +1,122 @@
+package reconcile
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/samber/lo"
+)
+
+// DriftKind classifies how an entry differs between source and catalog.
+type DriftKind string
+
+const (
+	// DriftSourceChanged is a change in source that hasn't been synced yet.
+	DriftSourceChanged DriftKind = "source-changed"
+	// DriftCatalogDrifted is a change made to the catalog outside of the importer.
+	DriftCatalogDrifted DriftKind = "catalog-drifted"
+	// DriftConflicting is when both source and catalog have changed since the last sync.
+	DriftConflicting DriftKind = "conflicting"
+)
+
+// Drift is a single entry that differs between source and catalog.
+type Drift struct {
+	ExternalID string
+	Kind       DriftKind
+	Fields     []string // what differs between source and catalog
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s: external_id='%s' (%v)", d.Kind, d.ExternalID, d.Fields)
+}
+
+// DetectDrift does a three-way comparison of the entries we last applied, those in the
+// catalog, and those built from source, all keyed by external ID.
+//
+// Entries where source and catalog agree aren't drift, whatever we last applied. Where
+// they disagree, whichever side has moved on from what we last applied is the one that
+// changed. If we've never applied an entry, we assume the side that has it is correct.
+func DetectDrift(applied, catalog, source map[string]AppliedEntry) []Drift {
+	externalIDs := lo.Uniq(append(append(lo.Keys(applied), lo.Keys(catalog)...), lo.Keys(source)...))
+	sort.Strings(externalIDs)
+
+	hash := func(entries map[string]AppliedEntry, externalID string) string {
+		if entry, ok := entries[externalID]; ok {
+			return entry.Hash()
+		}
+
+		return "" // absent
+	}
+
+	drifts := []Drift{}
+	for _, externalID := range externalIDs {
+		sourceHash, catalogHash := hash(source, externalID), hash(catalog, externalID)
+		if sourceHash == catalogHash {
+			continue
+		}
+
+		var kind DriftKind
+		if appliedEntry, ok := applied[externalID]; ok {
+			appliedHash := appliedEntry.Hash()
+			switch sourceChanged, catalogChanged := sourceHash != appliedHash, catalogHash != appliedHash; {
+			case sourceChanged && catalogChanged:
+				kind = DriftConflicting
+			case catalogChanged:
+				kind = DriftCatalogDrifted
+			default:
+				kind = DriftSourceChanged
+			}
+		} else {
+			switch {
+			case catalogHash == "":
+				kind = DriftSourceChanged // new in source
+			case sourceHash == "":
+				kind = DriftCatalogDrifted // added to the catalog by hand
+			default:
+				kind = DriftConflicting
+			}
+		}
+
+		drifts = append(drifts, Drift{
+			ExternalID: externalID,
+			Kind:       kind,
+			Fields:     diffFields(source, catalog, externalID),
+		})
+	}
+
+	return drifts
+}
+
+// diffFields lists what differs between the source and catalog versions of an entry.
+func diffFields(source, catalog map[string]AppliedEntry, externalID string) []string {
+	sourceEntry, inSource := source[externalID]
+	catalogEntry, inCatalog := catalog[externalID]
+	switch {
+	case !inSource:
+		return []string{"missing from source"}
+	case !inCatalog:
+		return []string{"missing from catalog"}
+	}
+
+	fields := []string{}
+	if sourceEntry.Name != catalogEntry.Name {
+		fields = append(fields, "name")
+	}
+	if !reflect.DeepEqual(sourceEntry.Aliases, catalogEntry.Aliases) {
+		fields = append(fields, "aliases")
+	}
+	if sourceEntry.Rank != catalogEntry.Rank {
+		fields = append(fields, "rank")
+	}
+
+	attributeIDs := lo.Uniq(append(lo.Keys(sourceEntry.AttributeValues), lo.Keys(catalogEntry.AttributeValues)...))
+	sort.Strings(attributeIDs)
+	for _, attributeID := range attributeIDs {
+		if !reflect.DeepEqual(sourceEntry.AttributeValues[attributeID], catalogEntry.AttributeValues[attributeID]) {
+			fields = append(fields, "attribute_values."+attributeID)
+		}
+	}
+
+	return fields
+}
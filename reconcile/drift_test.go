@@ -0,0 +1,234 @@
+package reconcile_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+	"github.com/samber/lo"
+	"gopkg.in/guregu/null.v3"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Drift", func() {
+	outputType := &output.Output{
+		TypeName: `Custom["Service"]`,
+		Attributes: []*output.Attribute{
+			{ID: "tier", Type: null.StringFrom("Number")},
+			{ID: "notes", Type: null.StringFrom("Text"), SchemaOnly: true},
+			{ID: "runbook", Type: null.StringFrom("String"), CreateOnly: true},
+		},
+	}
+
+	binding := func(value string) client.CatalogEngineParamBindingPayloadV3 {
+		return client.CatalogEngineParamBindingPayloadV3{
+			Value: &client.CatalogEngineParamBindingValuePayloadV3{Literal: lo.ToPtr(value)},
+		}
+	}
+	model := func(externalID, name, tier string) *output.CatalogEntryModel {
+		return &output.CatalogEntryModel{
+			ExternalID: externalID,
+			Name:       name,
+			AttributeValues: map[string]client.CatalogEngineParamBindingPayloadV3{
+				"tier": binding(tier),
+			},
+		}
+	}
+	entries := func(models ...*output.CatalogEntryModel) map[string]reconcile.AppliedEntry {
+		return reconcile.AppliedEntriesFromModels(outputType, models)
+	}
+
+	kinds := func(drifts []reconcile.Drift) map[string]reconcile.DriftKind {
+		return lo.SliceToMap(drifts, func(drift reconcile.Drift) (string, reconcile.DriftKind) {
+			return drift.ExternalID, drift.Kind
+		})
+	}
+
+	Describe("AppliedEntriesFromCatalog", func() {
+		It("matches the models it was built from, ignoring attributes shared with the dashboard", func() {
+			catalog := reconcile.AppliedEntriesFromCatalog(outputType, []client.CatalogEntryV3{
+				{
+					Id:         "entry-1",
+					Name:       "API",
+					ExternalId: lo.ToPtr("api"),
+					AttributeValues: map[string]client.CatalogEntryEngineParamBindingV3{
+						"tier":    {Value: &client.CatalogEntryEngineParamBindingValueV3{Literal: lo.ToPtr("1")}},
+						"notes":   {Value: &client.CatalogEntryEngineParamBindingValueV3{Literal: lo.ToPtr("edited by hand")}},
+						"runbook": {Value: &client.CatalogEntryEngineParamBindingValueV3{Literal: lo.ToPtr("https://runbook")}},
+					},
+				},
+				{Id: "entry-2", Name: "No external ID"},
+			})
+
+			Expect(catalog).To(HaveLen(1))
+			Expect(catalog["api"].Hash()).To(Equal(entries(model("api", "API", "1"))["api"].Hash()))
+		})
+	})
+
+	Describe("DetectDrift", func() {
+		It("ignores entries where source and catalog agree", func() {
+			applied := entries(model("api", "API", "1"))
+			current := entries(model("api", "API", "2"))
+			Expect(reconcile.DetectDrift(applied, current, current)).To(BeEmpty())
+		})
+
+		It("classifies changes by which side moved on from what was applied", func() {
+			applied := entries(model("source", "Source", "1"), model("catalog", "Catalog", "1"), model("both", "Both", "1"))
+			catalog := entries(model("source", "Source", "1"), model("catalog", "Catalog", "2"), model("both", "Both", "2"))
+			source := entries(model("source", "Source", "2"), model("catalog", "Catalog", "1"), model("both", "Both", "3"))
+
+			drifts := reconcile.DetectDrift(applied, catalog, source)
+			Expect(kinds(drifts)).To(Equal(map[string]reconcile.DriftKind{
+				"source":  reconcile.DriftSourceChanged,
+				"catalog": reconcile.DriftCatalogDrifted,
+				"both":    reconcile.DriftConflicting,
+			}))
+			Expect(drifts[0].Fields).To(Equal([]string{"attribute_values.tier"}))
+		})
+
+		It("treats deletions like any other change", func() {
+			applied := entries(model("removed-from-source", "A", "1"), model("removed-from-catalog", "B", "1"))
+			catalog := entries(model("removed-from-source", "A", "1"))
+			source := entries(model("removed-from-catalog", "B", "1"))
+
+			drifts := reconcile.DetectDrift(applied, catalog, source)
+			Expect(kinds(drifts)).To(Equal(map[string]reconcile.DriftKind{
+				"removed-from-source":  reconcile.DriftSourceChanged,
+				"removed-from-catalog": reconcile.DriftCatalogDrifted,
+			}))
+			Expect(drifts[0].Fields).To(Equal([]string{"missing from catalog"}))
+		})
+
+		It("attributes entries that were never applied to whichever side has them", func() {
+			catalog := entries(model("by-hand", "By hand", "1"), model("both", "Both", "1"))
+			source := entries(model("new", "New", "1"), model("both", "Both", "2"))
+
+			Expect(kinds(reconcile.DetectDrift(nil, catalog, source))).To(Equal(map[string]reconcile.DriftKind{
+				"new":     reconcile.DriftSourceChanged,
+				"by-hand": reconcile.DriftCatalogDrifted,
+				"both":    reconcile.DriftConflicting,
+			}))
+		})
+	})
+
+	Describe("RetainedEntries", func() {
+		catalogEntry := func(externalID string, archived bool) client.CatalogEntryV3 {
+			return client.CatalogEntryV3{
+				Id:         "entry-" + externalID,
+				ExternalId: lo.ToPtr(externalID),
+				Name:       externalID,
+				AttributeValues: map[string]client.CatalogEntryEngineParamBindingV3{
+					"archived": {Value: &client.CatalogEntryEngineParamBindingValueV3{Literal: lo.ToPtr(fmt.Sprint(archived))}},
+				},
+			}
+		}
+		driftWithout := func(retained, catalogEntries []client.CatalogEntryV3, models []*output.CatalogEntryModel) []reconcile.Drift {
+			retainedIDs := lo.Map(retained, func(entry client.CatalogEntryV3, _ int) string { return entry.Id })
+			catalogEntries = lo.Reject(catalogEntries, func(entry client.CatalogEntryV3, _ int) bool {
+				return lo.Contains(retainedIDs, entry.Id)
+			})
+
+			return reconcile.DetectDrift(entries(models...),
+				reconcile.AppliedEntriesFromCatalog(outputType, catalogEntries), entries(models...))
+		}
+
+		var (
+			now            time.Time
+			archiveOutput  *output.Output
+			catalogType    *client.CatalogTypeV3
+			catalogEntries []client.CatalogEntryV3
+		)
+		BeforeEach(func() {
+			now = time.Now()
+			archiveOutput = &output.Output{
+				TypeName:   outputType.TypeName,
+				Attributes: []*output.Attribute{{ID: "archived", Type: null.StringFrom("Bool")}},
+			}
+			catalogType = &client.CatalogTypeV3{
+				Id:       "type-123",
+				TypeName: outputType.TypeName,
+				Annotations: map[string]string{
+					reconcile.AnnotationMissingSince: fmt.Sprintf(`{"entry-recently-missing": "%s", "entry-long-missing": "%s"}`,
+						now.Add(-1*time.Hour).Format(time.RFC3339), now.Add(-100*time.Hour).Format(time.RFC3339)),
+				},
+			}
+			catalogEntries = []client.CatalogEntryV3{
+				catalogEntry("recently-missing", false),
+				catalogEntry("long-missing", false),
+				catalogEntry("archived", true),
+			}
+		})
+
+		It("keeps entries within their delete_after grace period out of drift", func() {
+			archiveOutput.OnMissing = &output.OnMissing{DeleteAfter: null.StringFrom("72h")}
+
+			retained := reconcile.RetainedEntries(archiveOutput, catalogType, catalogEntries, nil, now)
+			Expect(lo.Map(retained, func(entry client.CatalogEntryV3, _ int) string { return entry.Id })).
+				To(ConsistOf("entry-recently-missing", "entry-archived"))
+
+			// The entry past its grace period is about to be deleted, so is still reported.
+			Expect(kinds(driftWithout(retained, catalogEntries, nil))).To(Equal(map[string]reconcile.DriftKind{
+				"long-missing": reconcile.DriftCatalogDrifted,
+			}))
+		})
+
+		It("keeps archived entries out of drift", func() {
+			archiveOutput.OnMissing = &output.OnMissing{Archive: &output.OnMissingArchive{Attribute: "archived"}}
+
+			retained := reconcile.RetainedEntries(archiveOutput, catalogType, catalogEntries, nil, now)
+			Expect(lo.Map(retained, func(entry client.CatalogEntryV3, _ int) string { return entry.Id })).
+				To(ConsistOf("entry-archived"))
+
+			// Entries that are yet to be archived will be changed by the next sync.
+			Expect(kinds(driftWithout(retained, catalogEntries, nil))).To(Equal(map[string]reconcile.DriftKind{
+				"recently-missing": reconcile.DriftCatalogDrifted,
+				"long-missing":     reconcile.DriftCatalogDrifted,
+			}))
+		})
+
+		It("retains nothing without an on_missing policy", func() {
+			Expect(reconcile.RetainedEntries(archiveOutput, catalogType, catalogEntries, nil, now)).To(BeEmpty())
+		})
+	})
+
+	Describe("State", func() {
+		var filename string
+		BeforeEach(func() {
+			filename = filepath.Join(GinkgoT().TempDir(), "state.json")
+		})
+
+		It("starts empty, and round-trips what was recorded", func() {
+			state, err := reconcile.LoadState(filename, "sync-123")
+			Expect(err).NotTo(HaveOccurred())
+			_, ok := state.Applied(outputType.TypeName)
+			Expect(ok).To(BeFalse())
+
+			Expect(state.Record(outputType.TypeName, entries(model("api", "API", "1")))).To(Succeed())
+
+			loaded, err := reconcile.LoadState(filename, "sync-123")
+			Expect(err).NotTo(HaveOccurred())
+			applied, ok := loaded.Applied(outputType.TypeName)
+			Expect(ok).To(BeTrue())
+			Expect(applied["api"].Hash()).To(Equal(entries(model("api", "API", "1"))["api"].Hash()))
+		})
+
+		It("refuses state from a different sync ID", func() {
+			Expect(os.WriteFile(filename, []byte(`{"sync_id":"other","outputs":{}}`), 0o600)).To(Succeed())
+
+			_, err := reconcile.LoadState(filename, "sync-123")
+			Expect(err).To(MatchError(reconcile.ErrStateSyncIDMismatch))
+			Expect(err).To(MatchError(ContainSubstring("belongs to sync ID 'other'")))
+		})
+
+		It("does nothing when nil", func() {
+			var state *reconcile.State
+			Expect(state.Record(outputType.TypeName, nil)).To(Succeed())
+		})
+	})
+})
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"time"
 
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/incident-io/catalog-importer/v2/client"
@@ -19,6 +20,10 @@ type EntriesClient struct {
 	Delete     func(ctx context.Context, entry *client.CatalogEntryV3) error
 	Create     func(ctx context.Context, payload client.CatalogCreateEntryPayloadV3) (*client.CatalogEntryV3, error)
 	BulkUpdate func(ctx context.Context, catalogTypeID string, entries []client.PartialEntryPayloadV3, updateAttributes *[]string) error
+
+	// UpdateTypeAnnotations is only used for outputs that need to track state against the
+	// catalog type, such as when entries went missing from source.
+	UpdateTypeAnnotations func(ctx context.Context, catalogType *client.CatalogTypeV3, annotations map[string]string) error
 }
 
 // EntriesClientFromClient wraps a real client with hooks that can create, update and delete
@@ -62,6 +67,31 @@ func EntriesClientFromClient(cl *client.ClientWithResponses) EntriesClient {
 			})
 			return err
 		},
+		UpdateTypeAnnotations: func(ctx context.Context, catalogType *client.CatalogTypeV3, annotations map[string]string) error {
+			var color *client.CatalogUpdateTypePayloadV3Color
+			if catalogType.Color != "" {
+				color = lo.ToPtr(client.CatalogUpdateTypePayloadV3Color(catalogType.Color))
+			}
+			var icon *client.CatalogUpdateTypePayloadV3Icon
+			if catalogType.Icon != "" {
+				icon = lo.ToPtr(client.CatalogUpdateTypePayloadV3Icon(catalogType.Icon))
+			}
+
+			_, err := cl.CatalogV3UpdateTypeWithResponse(ctx, catalogType.Id, client.CatalogUpdateTypePayloadV3{
+				Name:        catalogType.Name,
+				Description: catalogType.Description,
+				Ranked:      lo.ToPtr(catalogType.Ranked),
+				Categories: lo.ToPtr(lo.Map(catalogType.Categories, func(category client.CatalogTypeV3Categories, _ int) client.CatalogUpdateTypePayloadV3Categories {
+					return client.CatalogUpdateTypePayloadV3Categories(category)
+				})),
+				Annotations:         lo.ToPtr(annotations),
+				Color:               color,
+				Icon:                icon,
+				UseNameAsIdentifier: lo.ToPtr(catalogType.UseNameAsIdentifier),
+				SourceRepoUrl:       catalogType.SourceRepoUrl,
+			})
+			return err
+		},
 	}
 }
 
@@ -72,6 +102,7 @@ type EntriesProgress struct {
 	OnCreateProgress func()
 	OnUpdateStart    func(total int)
 	OnUpdateProgress func()
+	OnArchiveStart   func(total int)
 }
 
 func Entries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, outputType *output.Output, catalogType *client.CatalogTypeV3, entryModels []*output.CatalogEntryModel, progress *EntriesProgress, pageSize int) error {
@@ -110,6 +141,41 @@ func Entries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, output
 		modelsByExternalID[model.ExternalID] = true
 	}
 
+	// If this output is partitioned, other importers may own some of the entries in this
+	// catalog type. We must leave those alone, so we work only with the entries we own.
+	allEntries := entries
+	{
+		ownedEntries := []client.CatalogEntryV3{}
+		unownedExternalIDs := map[string]bool{}
+		for _, entry := range entries {
+			owned, err := outputType.OwnsEntry(ctx, logger, entry)
+			if err != nil {
+				return errors.Wrap(err, "checking entry partition")
+			}
+
+			if owned {
+				ownedEntries = append(ownedEntries, entry)
+			} else if entry.ExternalId != nil {
+				unownedExternalIDs[*entry.ExternalId] = true
+			}
+		}
+
+		if len(ownedEntries) != len(entries) {
+			logger.Log("msg", fmt.Sprintf("found %d entries in the catalog, %d of which are in this output's partition", len(entries), len(ownedEntries)))
+		}
+		entries = ownedEntries
+
+		// We can't create or update entries that belong to someone else.
+		entryModels = lo.Filter(entryModels, func(model *output.CatalogEntryModel, _ int) bool {
+			if unownedExternalIDs[model.ExternalID] {
+				logger.Log("msg", "entry exists outside of this output's partition, skipping", "external_id", model.ExternalID)
+				return false
+			}
+
+			return true
+		})
+	}
+
 	{
 		toDelete := []client.CatalogEntryV3{}
 	eachEntry: // for every entry that exists, find any that has no corresponding model
@@ -126,6 +192,27 @@ func Entries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, output
 			toDelete = append(toDelete, entry)
 		}
 
+		// If the output has an on_missing policy, we may want to hold off deleting entries
+		// or archive them instead.
+		toArchive := []client.CatalogEntryV3{}
+		if outputType.OnMissing != nil {
+			plan := planMissing(outputType.OnMissing, catalogType, allEntries, entries, toDelete, time.Now())
+			logger.Log("msg", fmt.Sprintf("applying on_missing policy: %d missing entries, deleting %d and archiving %d",
+				len(toDelete), len(plan.toDelete), len(plan.toArchive)))
+
+			if plan.missingSinceChanged(catalogType) {
+				if cl.UpdateTypeAnnotations == nil {
+					return errors.New("client does not support updating catalog type annotations, needed for on_missing.delete_after")
+				}
+				err := cl.UpdateTypeAnnotations(ctx, catalogType, plan.annotations(catalogType))
+				if err != nil {
+					return errors.Wrap(err, "recording when entries went missing")
+				}
+			}
+
+			toDelete, toArchive = plan.toDelete, plan.toArchive
+		}
+
 		logger.Log("msg", fmt.Sprintf("found %d entries in the catalog, deleting %d of them", len(entries), len(toDelete)))
 
 		// Use a pool of workers to avoid hitting API limits but multiple other
@@ -158,6 +245,10 @@ func Entries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, output
 		if err != nil {
 			return errors.Wrap(err, "destroying catalog entries")
 		}
+
+		if err := archiveEntries(ctx, logger, cl, catalogType, outputType.OnMissing, toArchive, progress); err != nil {
+			return err
+		}
 	}
 
 	// Prepare a quick lookup of entry by external ID. We'll have deleted all entries
@@ -219,8 +310,8 @@ func Entries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, output
 		}
 	}
 
-	// Identify the attributes that are schema-only, as we want to preserve the existing
-	// value instead of setting it outselves.
+	// Identify the attributes that are schema-only or create-only, as we want to preserve
+	// the existing value instead of setting it outselves.
 	attributesToUpdate := []*output.Attribute{}
 	for _, attr := range outputType.Attributes {
 		if attr.IncludeInPayload() {
@@ -259,7 +350,7 @@ func Entries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, output
 						Rank:            &model.Rank,
 						ExternalId:      lo.ToPtr(model.ExternalID),
 						Aliases:         lo.ToPtr(model.Aliases),
-						AttributeValues: model.AttributeValues,
+						AttributeValues: attributeValuesForUpdate(entry.AttributeValues, model.AttributeValues, attributesToUpdate),
 					})
 				}
 			}
@@ -301,6 +392,41 @@ func Entries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, output
 	return nil
 }
 
+// archiveEntries marks the given entries as archived by setting the archive attribute,
+// leaving all their other attributes untouched.
+func archiveEntries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, catalogType *client.CatalogTypeV3, onMissing *output.OnMissing, toArchive []client.CatalogEntryV3, progress *EntriesProgress) error {
+	if onStart := progress.OnArchiveStart; onStart != nil {
+		onStart(len(toArchive))
+	}
+	if len(toArchive) == 0 {
+		return nil
+	}
+
+	archive := onMissing.Archive
+	updateAttributes := lo.ToPtr([]string{archive.Attribute})
+	for _, batch := range lo.Chunk(toArchive, 100) {
+		payloads := lo.Map(batch, func(entry client.CatalogEntryV3, _ int) client.PartialEntryPayloadV3 {
+			return client.PartialEntryPayloadV3{
+				EntryId: entry.Id,
+				AttributeValues: map[string]client.CatalogEngineParamBindingPayloadV3{
+					archive.Attribute: {
+						Value: &client.CatalogEngineParamBindingValuePayloadV3{
+							Literal: lo.ToPtr(archive.ArchivedValue()),
+						},
+					},
+				},
+			}
+		})
+
+		logger.Log("msg", fmt.Sprintf("archiving %d catalog entries", len(batch)))
+		if err := cl.BulkUpdate(ctx, catalogType.Id, payloads, updateAttributes); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to archive %d catalog entries", len(batch)))
+		}
+	}
+
+	return nil
+}
+
 // GetEntries paginates through all catalog entries for the given type.
 func GetEntries(ctx context.Context, cl *client.ClientWithResponses, catalogTypeID string, pageSize int) (catalogType *client.CatalogTypeV3, entries []client.CatalogEntryV3, err error) {
 	var (
@@ -329,7 +455,15 @@ func GetEntries(ctx context.Context, cl *client.ClientWithResponses, catalogType
 func attributesAreSame(existing map[string]client.CatalogEntryEngineParamBindingV3, desired map[string]client.CatalogEngineParamBindingPayloadV3, attributesToCheck []*output.Attribute) bool {
 	// Loop through the attributes which we are in control of and see if any have changed.
 	for _, attr := range attributesToCheck {
-		if !reflect.DeepEqual(bindingToPayload(existing[attr.ID]), desired[attr.ID]) {
+		existingValue := bindingToPayload(existing[attr.ID])
+
+		// Fill-if-empty attributes are left alone once they have a value, no matter what
+		// the source says.
+		if attr.FillIfEmpty && !bindingIsEmpty(existingValue) {
+			continue
+		}
+
+		if !reflect.DeepEqual(existingValue, desired[attr.ID]) {
 			return false
 		}
 	}
@@ -337,6 +471,41 @@ func attributesAreSame(existing map[string]client.CatalogEntryEngineParamBinding
 	return true
 }
 
+// attributeValuesForUpdate builds the attribute values we send when updating an entry.
+//
+// The list of attributes to update is shared by every entry in a bulk update, so for
+// fill-if-empty attributes that already have a value we send the existing value back
+// rather than overwriting it with the source.
+func attributeValuesForUpdate(existing map[string]client.CatalogEntryEngineParamBindingV3, desired map[string]client.CatalogEngineParamBindingPayloadV3, attributesToUpdate []*output.Attribute) map[string]client.CatalogEngineParamBindingPayloadV3 {
+	values := map[string]client.CatalogEngineParamBindingPayloadV3{}
+	for attrID, value := range desired {
+		values[attrID] = value
+	}
+
+	for _, attr := range attributesToUpdate {
+		if !attr.FillIfEmpty {
+			continue
+		}
+
+		if existingValue := bindingToPayload(existing[attr.ID]); !bindingIsEmpty(existingValue) {
+			values[attr.ID] = existingValue
+		}
+	}
+
+	return values
+}
+
+func bindingIsEmpty(binding client.CatalogEngineParamBindingPayloadV3) bool {
+	if binding.Value != nil && lo.FromPtr(binding.Value.Literal) != "" {
+		return false
+	}
+	if binding.ArrayValue != nil && len(*binding.ArrayValue) > 0 {
+		return false
+	}
+
+	return true
+}
+
 func bindingToPayload(binding client.CatalogEntryEngineParamBindingV3) client.CatalogEngineParamBindingPayloadV3 {
 	payload := client.CatalogEngineParamBindingPayloadV3{}
 	if binding.Value != nil {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/incident-io/catalog-importer/v2/client"
@@ -16,6 +17,8 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+var annotationMissingSince = reconcile.AnnotationMissingSince
+
 var _ = Describe("Entries", func() {
 	var (
 		ctx    context.Context
@@ -37,6 +40,7 @@ var _ = Describe("Entries", func() {
 		createdEntries  []client.CatalogCreateEntryPayloadV3
 		updatedEntries  []updatedEntry
 		deletedEntries  []string
+		annotations     map[string]string
 		mu              sync.Mutex // Protect concurrent writes to slices
 	)
 	BeforeEach(func() {
@@ -45,6 +49,7 @@ var _ = Describe("Entries", func() {
 		createdEntries = []client.CatalogCreateEntryPayloadV3{}
 		updatedEntries = []updatedEntry{}
 		deletedEntries = []string{}
+		annotations = map[string]string{}
 
 		attrValuesFromPayload := func(payload map[string]client.CatalogEngineParamBindingPayloadV3) map[string]client.CatalogEntryEngineParamBindingV3 {
 			attributeValues := map[string]client.CatalogEntryEngineParamBindingV3{}
@@ -75,10 +80,15 @@ var _ = Describe("Entries", func() {
 		mockClient = reconcile.EntriesClient{
 			GetEntries: func(ctx context.Context, catalogTypeID string, pageSize int) (*client.CatalogTypeV3, []client.CatalogEntryV3, error) {
 				return &client.CatalogTypeV3{
-					Id:       "type-123",
-					TypeName: "Test Type",
+					Id:          "type-123",
+					TypeName:    "Test Type",
+					Annotations: annotations,
 				}, existingEntries, nil
 			},
+			UpdateTypeAnnotations: func(ctx context.Context, catalogType *client.CatalogTypeV3, updated map[string]string) error {
+				annotations = updated
+				return nil
+			},
 			Create: func(ctx context.Context, payload client.CatalogCreateEntryPayloadV3) (*client.CatalogEntryV3, error) {
 				mu.Lock()
 				createdEntries = append(createdEntries, payload)
@@ -468,6 +478,301 @@ var _ = Describe("Entries", func() {
 		})
 	})
 
+	When("attributes are create-only or fill-if-empty", func() {
+		BeforeEach(func() {
+			catalogType = &client.CatalogTypeV3{
+				Id:       "type-123",
+				TypeName: "Test Type",
+			}
+
+			outputType = &output.Output{
+				Attributes: []*output.Attribute{
+					{ID: "managed", Name: "Managed"},
+					{ID: "create_only", Name: "Create only", CreateOnly: true},
+					{ID: "fill_if_empty", Name: "Fill if empty", FillIfEmpty: true},
+				},
+			}
+
+			literal := func(value string) client.CatalogEngineParamBindingPayloadV3 {
+				return client.CatalogEngineParamBindingPayloadV3{
+					Value: &client.CatalogEngineParamBindingValuePayloadV3{
+						Literal: lo.ToPtr(value),
+					},
+				}
+			}
+			existingLiteral := func(value string) client.CatalogEntryEngineParamBindingV3 {
+				return client.CatalogEntryEngineParamBindingV3{
+					Value: &client.CatalogEntryEngineParamBindingValueV3{
+						Literal: lo.ToPtr(value),
+					},
+				}
+			}
+
+			existingEntries = []client.CatalogEntryV3{
+				{
+					// Only the create-only attribute differs, which we should ignore.
+					Id:         "entry-unchanged",
+					ExternalId: lo.ToPtr("ext-unchanged"),
+					Name:       "Unchanged",
+					AttributeValues: map[string]client.CatalogEntryEngineParamBindingV3{
+						"managed":       existingLiteral("same"),
+						"create_only":   existingLiteral("edited-in-dashboard"),
+						"fill_if_empty": existingLiteral("edited-in-dashboard"),
+					},
+				},
+				{
+					// The fill-if-empty attribute is blank, so we should fill it.
+					Id:         "entry-empty",
+					ExternalId: lo.ToPtr("ext-empty"),
+					Name:       "Empty",
+					AttributeValues: map[string]client.CatalogEntryEngineParamBindingV3{
+						"managed": existingLiteral("same"),
+					},
+				},
+				{
+					// The managed attribute changed, but we must preserve the filled value.
+					Id:         "entry-filled",
+					ExternalId: lo.ToPtr("ext-filled"),
+					Name:       "Filled",
+					AttributeValues: map[string]client.CatalogEntryEngineParamBindingV3{
+						"managed":       existingLiteral("old"),
+						"fill_if_empty": existingLiteral("edited-in-dashboard"),
+					},
+				},
+			}
+
+			entryModels = []*output.CatalogEntryModel{}
+			for _, entry := range existingEntries {
+				entryModels = append(entryModels, &output.CatalogEntryModel{
+					Name:       entry.Name,
+					ExternalID: *entry.ExternalId,
+					AttributeValues: map[string]client.CatalogEngineParamBindingPayloadV3{
+						"managed":       literal("same"),
+						"create_only":   literal("from-source"),
+						"fill_if_empty": literal("from-source"),
+					},
+				})
+			}
+			entryModels[2].AttributeValues["managed"] = literal("new")
+		})
+
+		It("only updates entries where an owned value has changed", func() {
+			mustReconcile()
+
+			Expect(lo.Map(updatedEntries, func(entry updatedEntry, _ int) string {
+				return entry.id
+			})).To(ConsistOf("entry-empty", "entry-filled"))
+		})
+
+		It("never includes create-only attributes in the update", func() {
+			mustReconcile()
+
+			for _, entry := range updatedEntries {
+				Expect(*entry.payload.UpdateAttributes).To(ConsistOf("managed", "fill_if_empty"))
+			}
+		})
+
+		It("preserves fill-if-empty values that are already set", func() {
+			mustReconcile()
+
+			for _, entry := range updatedEntries {
+				value := *entry.payload.AttributeValues["fill_if_empty"].Value.Literal
+				switch entry.id {
+				case "entry-empty":
+					Expect(value).To(Equal("from-source"))
+				case "entry-filled":
+					Expect(value).To(Equal("edited-in-dashboard"))
+				}
+			}
+		})
+	})
+
+	When("the output is partitioned", func() {
+		BeforeEach(func() {
+			catalogType = &client.CatalogTypeV3{
+				Id:       "type-123",
+				TypeName: "Test Type",
+			}
+
+			outputType = &output.Output{
+				Attributes: []*output.Attribute{
+					{ID: "team", Name: "Team"},
+				},
+				Partition: &output.Partition{
+					ExternalIDPrefix: "platform/",
+				},
+			}
+
+			existingEntries = []client.CatalogEntryV3{
+				{Id: "entry-ours", ExternalId: lo.ToPtr("platform/removed"), Name: "Ours"},
+				{Id: "entry-theirs", ExternalId: lo.ToPtr("data/warehouse"), Name: "Theirs"},
+				{Id: "entry-no-external-id", Name: "No external ID"},
+			}
+
+			entryModels = []*output.CatalogEntryModel{
+				{Name: "New", ExternalID: "platform/new"},
+				{Name: "Clash", ExternalID: "data/warehouse"},
+			}
+		})
+
+		It("only deletes entries inside the partition", func() {
+			mustReconcile()
+
+			Expect(deletedEntries).To(ConsistOf("entry-ours"))
+		})
+
+		It("does not touch entries owned by another partition", func() {
+			mustReconcile()
+
+			Expect(createdEntries).To(HaveLen(1))
+			Expect(*createdEntries[0].ExternalId).To(Equal("platform/new"))
+			Expect(updatedEntries).To(BeEmpty())
+		})
+
+		When("ownership is decided by an expression", func() {
+			BeforeEach(func() {
+				outputType.Partition = &output.Partition{
+					OwnsEntries: null.StringFrom(`$.attribute_values.team == "platform"`),
+				}
+
+				existingEntries = []client.CatalogEntryV3{
+					{
+						Id:         "entry-platform",
+						ExternalId: lo.ToPtr("a"),
+						AttributeValues: map[string]client.CatalogEntryEngineParamBindingV3{
+							"team": {Value: &client.CatalogEntryEngineParamBindingValueV3{Literal: lo.ToPtr("platform")}},
+						},
+					},
+					{
+						Id:         "entry-data",
+						ExternalId: lo.ToPtr("b"),
+						AttributeValues: map[string]client.CatalogEntryEngineParamBindingV3{
+							"team": {Value: &client.CatalogEntryEngineParamBindingValueV3{Literal: lo.ToPtr("data")}},
+						},
+					},
+				}
+				entryModels = []*output.CatalogEntryModel{}
+			})
+
+			It("only deletes entries the expression matches", func() {
+				mustReconcile()
+
+				Expect(deletedEntries).To(ConsistOf("entry-platform"))
+			})
+		})
+	})
+
+	When("the output has an on_missing policy", func() {
+		BeforeEach(func() {
+			catalogType = &client.CatalogTypeV3{
+				Id:       "type-123",
+				TypeName: "Test Type",
+			}
+
+			outputType = &output.Output{
+				Attributes: []*output.Attribute{
+					{ID: "archived", Name: "Archived", Type: null.StringFrom("Bool")},
+				},
+				OnMissing: &output.OnMissing{
+					DeleteAfter: null.StringFrom("72h"),
+				},
+			}
+
+			existingEntries = []client.CatalogEntryV3{
+				{Id: "entry-present", ExternalId: lo.ToPtr("present"), Name: "Present"},
+				{Id: "entry-new-missing", ExternalId: lo.ToPtr("new-missing"), Name: "Newly missing"},
+				{Id: "entry-old-missing", ExternalId: lo.ToPtr("old-missing"), Name: "Missing for ages"},
+			}
+
+			annotations = map[string]string{
+				annotationMissingSince: fmt.Sprintf(`{"entry-old-missing": "%s", "entry-present": "%s", "entry-deleted": "%s"}`,
+					time.Now().Add(-100*time.Hour).Format(time.RFC3339),
+					time.Now().Add(-1*time.Hour).Format(time.RFC3339),
+					time.Now().Add(-1*time.Hour).Format(time.RFC3339),
+				),
+			}
+
+			entryModels = []*output.CatalogEntryModel{
+				{Name: "Present", ExternalID: "present"},
+			}
+		})
+
+		It("only deletes entries missing for longer than the grace period", func() {
+			mustReconcile()
+
+			Expect(deletedEntries).To(ConsistOf("entry-old-missing"))
+		})
+
+		It("records when entries went missing, forgetting any that reappeared or were removed", func() {
+			mustReconcile()
+
+			Expect(annotations).To(HaveKey(annotationMissingSince))
+			Expect(annotations[annotationMissingSince]).To(ContainSubstring("entry-new-missing"))
+			Expect(annotations[annotationMissingSince]).NotTo(ContainSubstring("entry-present"))
+			Expect(annotations[annotationMissingSince]).NotTo(ContainSubstring("entry-old-missing"))
+			Expect(annotations[annotationMissingSince]).NotTo(ContainSubstring("entry-deleted"))
+		})
+
+		When("the output is partitioned", func() {
+			BeforeEach(func() {
+				outputType.Partition = &output.Partition{ExternalIDPrefix: "platform/"}
+				existingEntries = append(existingEntries,
+					client.CatalogEntryV3{Id: "entry-other", ExternalId: lo.ToPtr("payments/missing"), Name: "Another partition"})
+				for idx := range existingEntries[:3] {
+					existingEntries[idx].ExternalId = lo.ToPtr("platform/" + *existingEntries[idx].ExternalId)
+				}
+				entryModels[0].ExternalID = "platform/present"
+				annotations[annotationMissingSince] = fmt.Sprintf(`{"entry-other": "%s", "entry-deleted": "%s"}`,
+					time.Now().Add(-1*time.Hour).Format(time.RFC3339),
+					time.Now().Add(-1*time.Hour).Format(time.RFC3339),
+				)
+			})
+
+			It("leaves entries in other partitions for their owner", func() {
+				mustReconcile()
+
+				Expect(annotations[annotationMissingSince]).To(ContainSubstring("entry-other"))
+				Expect(annotations[annotationMissingSince]).NotTo(ContainSubstring("entry-deleted"))
+			})
+		})
+
+		When("archiving missing entries", func() {
+			BeforeEach(func() {
+				outputType.OnMissing = &output.OnMissing{
+					Archive: &output.OnMissingArchive{Attribute: "archived"},
+				}
+			})
+
+			It("sets the archive attribute instead of deleting", func() {
+				mustReconcile()
+
+				Expect(deletedEntries).To(BeEmpty())
+				Expect(lo.Map(updatedEntries, func(entry updatedEntry, _ int) string {
+					return entry.id
+				})).To(ConsistOf("entry-new-missing", "entry-old-missing"))
+
+				for _, entry := range updatedEntries {
+					Expect(*entry.payload.UpdateAttributes).To(ConsistOf("archived"))
+					Expect(*entry.payload.AttributeValues["archived"].Value.Literal).To(Equal("true"))
+				}
+			})
+
+			When("reconciling one of the output's enum types", func() {
+				BeforeEach(func() {
+					outputType = outputType.ForEnum()
+				})
+
+				It("deletes missing values rather than archiving them", func() {
+					mustReconcile()
+
+					Expect(updatedEntries).To(BeEmpty())
+					Expect(deletedEntries).To(ConsistOf("entry-new-missing", "entry-old-missing"))
+					Expect(annotations[annotationMissingSince]).NotTo(ContainSubstring("entry-new-missing"))
+				})
+			})
+		})
+	})
+
 	When("entries have duplicate external IDs", func() {
 		BeforeEach(func() {
 			// Setup test data
@@ -0,0 +1,353 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// AttributeRename moves the values of an attribute onto a new attribute that replaces it,
+// which may also have a different type.
+type AttributeRename struct {
+	From client.CatalogTypeAttributeV3        // as it exists in the catalog type's schema
+	To   client.CatalogTypeAttributePayloadV3 // as we're about to declare it
+}
+
+// PendingRenames finds the attributes of a catalog type that have been renamed and whose
+// values still need to be migrated, given a map of new attribute ID to the ID it was
+// renamed from.
+//
+// A rename is pending while the old attribute is still in the schema, and we're not
+// about to declare an attribute with the old ID. This includes attributes whose type is
+// changing, once PendingTypeChanges has put their values somewhere safe.
+func PendingRenames(catalogType *client.CatalogTypeV3, renamedAttributes map[string]string, attributes []client.CatalogTypeAttributePayloadV3) []AttributeRename {
+	renames := []AttributeRename{}
+	for _, attr := range attributes {
+		renamedFrom, ok := renamedAttributes[lo.FromPtr(attr.Id)]
+		if !ok {
+			continue
+		}
+
+		_, stillDeclared := lo.Find(attributes, func(other client.CatalogTypeAttributePayloadV3) bool {
+			return lo.FromPtr(other.Id) == renamedFrom
+		})
+		if stillDeclared {
+			continue
+		}
+
+		existing, ok := lo.Find(catalogType.Schema.Attributes, func(existing client.CatalogTypeAttributeV3) bool {
+			return existing.Id == renamedFrom
+		})
+		if !ok {
+			continue // already migrated, or never existed
+		}
+
+		renames = append(renames, AttributeRename{From: existing, To: attr})
+	}
+
+	// Attributes whose type is changing have their values kept in a copy of the old
+	// attribute (see PendingTypeChanges), which we migrate back like any other rename.
+	for _, attr := range attributes {
+		previous, ok := lo.Find(catalogType.Schema.Attributes, func(existing client.CatalogTypeAttributeV3) bool {
+			return existing.Id == lo.FromPtr(attr.Id)+typeChangeSuffix
+		})
+		if ok {
+			renames = append(renames, AttributeRename{From: previous, To: attr})
+		}
+	}
+
+	return renames
+}
+
+// typeChangeSuffix is appended to the ID of an attribute whose type is changing, giving
+// the ID of the attribute we keep its values in while the type changes.
+const typeChangeSuffix = "_previous_type"
+
+// PendingTypeChanges finds attributes that keep their ID but change their type, or
+// whether they're an array, which would otherwise lose all their values.
+//
+// For each, we return a rename from the attribute as it exists onto a copy of it with the
+// typeChangeSuffix: once those values are safe, the type can change and PendingRenames
+// will migrate them back, converting them to the new type. References to a catalog type
+// that is being migrated to a new type name, given as a map of old type name to new,
+// aren't type changes.
+func PendingTypeChanges(catalogType *client.CatalogTypeV3, attributes []client.CatalogTypeAttributePayloadV3, typeMigrations map[string]string) []AttributeRename {
+	renames := []AttributeRename{}
+	for _, attr := range attributes {
+		existing, ok := lo.Find(catalogType.Schema.Attributes, func(existing client.CatalogTypeAttributeV3) bool {
+			return existing.Id == lo.FromPtr(attr.Id)
+		})
+		if !ok || !isTypeChange(existing, attr, typeMigrations) {
+			continue
+		}
+
+		renames = append(renames, AttributeRename{From: existing, To: client.CatalogTypeAttributePayloadV3{
+			Id:    lo.ToPtr(existing.Id + typeChangeSuffix),
+			Name:  fmt.Sprintf("%s (previous type)", existing.Name),
+			Type:  existing.Type,
+			Array: existing.Array,
+			Mode:  lo.ToPtr(client.CatalogTypeAttributePayloadV3Mode(existing.Mode)),
+		}})
+	}
+
+	return renames
+}
+
+func isTypeChange(existing client.CatalogTypeAttributeV3, attr client.CatalogTypeAttributePayloadV3, typeMigrations map[string]string) bool {
+	// Backlinks and paths are derived from other attributes, so have no values to keep.
+	if existing.BacklinkAttribute != nil || existing.Path != nil {
+		return false
+	}
+
+	typeChanged := attr.Type != existing.Type && typeMigrations[existing.Type] != attr.Type
+
+	return typeChanged || attr.Array != existing.Array
+}
+
+// MigrateAttributes copies the values of each renamed attribute onto its replacement,
+// converting them to the new type where possible.
+//
+// Entries that already have a value for the new attribute are left alone, so this can
+// safely be re-run if a previous migration was interrupted. Values that can't be
+// converted are logged and dropped.
+func MigrateAttributes(ctx context.Context, logger kitlog.Logger, cl EntriesClient, catalogTypeID string, renames []AttributeRename, pageSize int) error {
+	if len(renames) == 0 {
+		return nil
+	}
+
+	_, entries, err := cl.GetEntries(ctx, catalogTypeID, pageSize)
+	if err != nil {
+		return errors.Wrap(err, "listing entries")
+	}
+
+	for _, rename := range renames {
+		logger := kitlog.With(logger, "from_attribute_id", rename.From.Id, "to_attribute_id", lo.FromPtr(rename.To.Id))
+
+		payloads := []client.PartialEntryPayloadV3{}
+		for _, entry := range entries {
+			if !bindingIsEmpty(bindingToPayload(entry.AttributeValues[lo.FromPtr(rename.To.Id)])) {
+				continue // already migrated
+			}
+
+			binding, dropped := convertBinding(entry.AttributeValues[rename.From.Id], rename)
+			if len(dropped) > 0 {
+				logger.Log("msg", "could not convert attribute values, dropping them",
+					"catalog_entry_id", entry.Id, "values", fmt.Sprintf("%v", dropped))
+			}
+			if bindingIsEmpty(binding) {
+				continue
+			}
+
+			payloads = append(payloads, client.PartialEntryPayloadV3{
+				EntryId: entry.Id,
+				AttributeValues: map[string]client.CatalogEngineParamBindingPayloadV3{
+					lo.FromPtr(rename.To.Id): binding,
+				},
+			})
+		}
+
+		logger.Log("msg", "migrating renamed attribute", "entries", len(payloads))
+		updateAttributes := lo.ToPtr([]string{lo.FromPtr(rename.To.Id)})
+		for _, batch := range lo.Chunk(payloads, 100) {
+			if err := cl.BulkUpdate(ctx, catalogTypeID, batch, updateAttributes); err != nil {
+				return errors.Wrap(err, fmt.Sprintf("migrating attribute '%s' to '%s'", rename.From.Id, lo.FromPtr(rename.To.Id)))
+			}
+		}
+	}
+
+	return nil
+}
+
+// convertBinding converts the value of the old attribute into one that fits the new
+// attribute, returning any literals that couldn't be converted.
+func convertBinding(existing client.CatalogEntryEngineParamBindingV3, rename AttributeRename) (client.CatalogEngineParamBindingPayloadV3, []string) {
+	literals := []string{}
+	if existing.Value != nil && existing.Value.Literal != nil {
+		literals = append(literals, *existing.Value.Literal)
+	}
+	if existing.ArrayValue != nil {
+		for _, value := range *existing.ArrayValue {
+			if value.Literal != nil {
+				literals = append(literals, *value.Literal)
+			}
+		}
+	}
+
+	converted, dropped := []string{}, []string{}
+	for _, literal := range literals {
+		if value, ok := convertLiteral(literal, rename.To.Type); ok {
+			converted = append(converted, value)
+		} else {
+			dropped = append(dropped, literal)
+		}
+	}
+
+	if len(converted) == 0 {
+		return client.CatalogEngineParamBindingPayloadV3{}, dropped
+	}
+
+	if rename.To.Array {
+		return client.CatalogEngineParamBindingPayloadV3{
+			ArrayValue: lo.ToPtr(lo.Map(converted, func(literal string, _ int) client.CatalogEngineParamBindingValuePayloadV3 {
+				return client.CatalogEngineParamBindingValuePayloadV3{Literal: lo.ToPtr(literal)}
+			})),
+		}, dropped
+	}
+
+	// Moving from an array to a single value means we can only keep the first.
+	return client.CatalogEngineParamBindingPayloadV3{
+		Value: &client.CatalogEngineParamBindingValuePayloadV3{Literal: lo.ToPtr(converted[0])},
+	}, append(dropped, converted[1:]...)
+}
+
+// convertLiteral converts a literal value into the given attribute type, returning false
+// if it can't be represented.
+func convertLiteral(literal, attributeType string) (string, bool) {
+	switch attributeType {
+	case "Bool":
+		value, err := strconv.ParseBool(literal)
+		if err != nil {
+			return "", false
+		}
+
+		return strconv.FormatBool(value), true
+	case "Number":
+		if _, err := strconv.ParseFloat(literal, 64); err != nil {
+			return "", false
+		}
+
+		return literal, true
+	default:
+		// Strings, text and references to other catalog entries all accept any literal.
+		return literal, true
+	}
+}
+
+// CopyEntries copies every entry of one catalog type into another, including the values
+// of the given attributes, returning the entries we copied and a map of old entry ID to
+// the ID of its copy.
+//
+// Entries that were already copied (matched by external ID, or name if they have none)
+// are reused, so an interrupted copy can be resumed.
+func CopyEntries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, fromID, toID string, attributeIDs []string, pageSize int) ([]client.CatalogEntryV3, map[string]string, error) {
+	_, entries, err := cl.GetEntries(ctx, fromID, pageSize)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "listing entries to copy")
+	}
+	_, copiedEntries, err := cl.GetEntries(ctx, toID, pageSize)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "listing copied entries")
+	}
+
+	copyKey := func(entry client.CatalogEntryV3) string {
+		if entry.ExternalId != nil {
+			return "external_id:" + *entry.ExternalId
+		}
+
+		return "name:" + entry.Name
+	}
+	copiedByKey := map[string]string{}
+	for _, entry := range copiedEntries {
+		copiedByKey[copyKey(entry)] = entry.Id
+	}
+
+	entryIDs, alreadyCopied := map[string]string{}, 0
+	for _, entry := range entries {
+		if copiedID, ok := copiedByKey[copyKey(entry)]; ok {
+			entryIDs[entry.Id] = copiedID
+			alreadyCopied++
+			continue
+		}
+
+		attributeValues := map[string]client.CatalogEngineParamBindingPayloadV3{}
+		for _, attributeID := range attributeIDs {
+			if binding := bindingToPayload(entry.AttributeValues[attributeID]); !bindingIsEmpty(binding) {
+				attributeValues[attributeID] = binding
+			}
+		}
+
+		var rank *int32
+		if entry.Rank != 0 {
+			rank = lo.ToPtr(entry.Rank)
+		}
+
+		copied, err := cl.Create(ctx, client.CatalogCreateEntryPayloadV3{
+			CatalogTypeId:   toID,
+			Name:            entry.Name,
+			ExternalId:      entry.ExternalId,
+			Aliases:         lo.ToPtr(entry.Aliases),
+			Rank:            rank,
+			AttributeValues: attributeValues,
+		})
+		if err != nil {
+			return nil, nil, errors.Wrap(err, fmt.Sprintf("copying entry %s", entry.Id))
+		}
+
+		entryIDs[entry.Id] = copied.Id
+	}
+
+	logger.Log("msg", "copied catalog entries", "from_catalog_type_id", fromID, "to_catalog_type_id", toID,
+		"entries", len(entries), "already_copied", alreadyCopied)
+
+	return entries, entryIDs, nil
+}
+
+// RewriteReferences sets the values of attributes that reference entries we've copied,
+// swapping any old entry IDs for the IDs of their copies.
+//
+// The entries must be a snapshot taken before the attributes were cleared. If an entry
+// was itself copied, the update is applied to its copy.
+func RewriteReferences(ctx context.Context, logger kitlog.Logger, cl EntriesClient, catalogTypeID string, entries []client.CatalogEntryV3, attributeIDs []string, entryIDs map[string]string) error {
+	rewrite := func(value client.CatalogEngineParamBindingValuePayloadV3) client.CatalogEngineParamBindingValuePayloadV3 {
+		if value.Literal != nil {
+			if copiedID, ok := entryIDs[*value.Literal]; ok {
+				return client.CatalogEngineParamBindingValuePayloadV3{Literal: lo.ToPtr(copiedID)}
+			}
+		}
+
+		return value
+	}
+
+	payloads := []client.PartialEntryPayloadV3{}
+	for _, entry := range entries {
+		attributeValues := map[string]client.CatalogEngineParamBindingPayloadV3{}
+		for _, attributeID := range attributeIDs {
+			binding := bindingToPayload(entry.AttributeValues[attributeID])
+			if bindingIsEmpty(binding) {
+				continue
+			}
+
+			if binding.Value != nil {
+				binding.Value = lo.ToPtr(rewrite(*binding.Value))
+			}
+			if binding.ArrayValue != nil {
+				binding.ArrayValue = lo.ToPtr(lo.Map(*binding.ArrayValue, func(value client.CatalogEngineParamBindingValuePayloadV3, _ int) client.CatalogEngineParamBindingValuePayloadV3 {
+					return rewrite(value)
+				}))
+			}
+			attributeValues[attributeID] = binding
+		}
+		if len(attributeValues) == 0 {
+			continue
+		}
+
+		payloads = append(payloads, client.PartialEntryPayloadV3{
+			EntryId:         lo.ValueOr(entryIDs, entry.Id, entry.Id),
+			AttributeValues: attributeValues,
+		})
+	}
+
+	logger.Log("msg", "rewriting references to copied entries", "catalog_type_id", catalogTypeID, "entries", len(payloads))
+	for _, batch := range lo.Chunk(payloads, 100) {
+		if err := cl.BulkUpdate(ctx, catalogTypeID, batch, lo.ToPtr(attributeIDs)); err != nil {
+			return errors.Wrap(err, "rewriting references")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package reconcile_test
+
+import (
+	"context"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+	"github.com/samber/lo"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Attribute migrations", func() {
+	var catalogType *client.CatalogTypeV3
+	BeforeEach(func() {
+		catalogType = &client.CatalogTypeV3{
+			Id: "type-123",
+			Schema: client.CatalogTypeSchemaV3{
+				Attributes: []client.CatalogTypeAttributeV3{
+					{Id: "team", Name: "Team", Type: `Custom["Squad"]`},
+					{Id: "tier_text", Name: "Tier", Type: "String"},
+					{Id: "languages", Name: "Languages", Type: "String", Array: true},
+				},
+			},
+		}
+	})
+
+	Describe("PendingRenames", func() {
+		attributes := []client.CatalogTypeAttributePayloadV3{
+			{Id: lo.ToPtr("owner"), Name: "Owner", Type: "String"},
+			{Id: lo.ToPtr("tier"), Name: "Tier", Type: "Number"},
+			{Id: lo.ToPtr("gone"), Name: "Gone", Type: "String"},
+		}
+
+		It("finds renames whose old attribute is still in the schema", func() {
+			renames := reconcile.PendingRenames(catalogType, map[string]string{
+				"owner": "team",
+				"tier":  "tier_text",
+				"gone":  "never_existed",
+			}, attributes)
+
+			Expect(lo.Map(renames, func(rename reconcile.AttributeRename, _ int) string {
+				return rename.From.Id + "->" + *rename.To.Id
+			})).To(ConsistOf("team->owner", "tier_text->tier"))
+		})
+
+		It("ignores renames where the old attribute is still declared", func() {
+			renames := reconcile.PendingRenames(catalogType, map[string]string{"owner": "team"},
+				append(attributes, client.CatalogTypeAttributePayloadV3{Id: lo.ToPtr("team"), Type: "String"}))
+			Expect(renames).To(BeEmpty())
+		})
+	})
+
+	Describe("PendingTypeChanges", func() {
+		attributes := []client.CatalogTypeAttributePayloadV3{
+			{Id: lo.ToPtr("team"), Name: "Team", Type: "String"},
+			{Id: lo.ToPtr("tier_text"), Name: "Tier", Type: "Number"},
+			{Id: lo.ToPtr("languages"), Name: "Languages", Type: "String", Array: false},
+		}
+
+		It("keeps the values of attributes changing type in a copy of the old attribute", func() {
+			typeChanges := reconcile.PendingTypeChanges(catalogType, attributes, nil)
+			Expect(lo.Map(typeChanges, func(rename reconcile.AttributeRename, _ int) string {
+				return rename.From.Id + "->" + *rename.To.Id
+			})).To(ConsistOf(
+				"team->team_previous_type",
+				"tier_text->tier_text_previous_type",
+				"languages->languages_previous_type",
+			))
+			Expect(typeChanges[1].To.Type).To(Equal("String"))
+			Expect(typeChanges[2].To.Array).To(BeTrue())
+		})
+
+		It("ignores references to a type being migrated", func() {
+			typeChanges := reconcile.PendingTypeChanges(catalogType, []client.CatalogTypeAttributePayloadV3{
+				{Id: lo.ToPtr("team"), Name: "Team", Type: `Custom["Team"]`},
+			}, map[string]string{`Custom["Squad"]`: `Custom["Team"]`})
+			Expect(typeChanges).To(BeEmpty())
+		})
+
+		It("migrates the kept values back once the type has changed", func() {
+			catalogType.Schema.Attributes = []client.CatalogTypeAttributeV3{
+				{Id: "tier_text", Name: "Tier", Type: "Number"},
+				{Id: "tier_text_previous_type", Name: "Tier (previous type)", Type: "String"},
+			}
+
+			Expect(reconcile.PendingTypeChanges(catalogType, attributes[1:2], nil)).To(BeEmpty())
+			renames := reconcile.PendingRenames(catalogType, nil, attributes[1:2])
+			Expect(lo.Map(renames, func(rename reconcile.AttributeRename, _ int) string {
+				return rename.From.Id + "->" + *rename.To.Id
+			})).To(ConsistOf("tier_text_previous_type->tier_text"))
+		})
+	})
+
+	Describe("MigrateAttributes", func() {
+		var (
+			entries []client.CatalogEntryV3
+			updates map[string][]client.PartialEntryPayloadV3 // by updated attribute
+			cl      reconcile.EntriesClient
+		)
+		BeforeEach(func() {
+			literal := func(value string) *client.CatalogEntryEngineParamBindingValueV3 {
+				return &client.CatalogEntryEngineParamBindingValueV3{Literal: lo.ToPtr(value)}
+			}
+			entries = []client.CatalogEntryV3{
+				{
+					Id: "entry-1",
+					AttributeValues: map[string]client.CatalogEntryEngineParamBindingV3{
+						"tier_text": {Value: literal("2")},
+						"languages": {ArrayValue: &[]client.CatalogEntryEngineParamBindingValueV3{*literal("go"), *literal("ruby")}},
+					},
+				},
+				{
+					Id: "entry-2",
+					AttributeValues: map[string]client.CatalogEntryEngineParamBindingV3{
+						"tier_text": {Value: literal("gold")},
+					},
+				},
+				{
+					Id: "entry-3",
+					AttributeValues: map[string]client.CatalogEntryEngineParamBindingV3{
+						"tier_text": {Value: literal("3")},
+						"tier":      {Value: literal("1")},
+					},
+				},
+			}
+			updates = map[string][]client.PartialEntryPayloadV3{}
+
+			cl = reconcile.EntriesClient{
+				GetEntries: func(ctx context.Context, catalogTypeID string, pageSize int) (*client.CatalogTypeV3, []client.CatalogEntryV3, error) {
+					return catalogType, entries, nil
+				},
+				BulkUpdate: func(ctx context.Context, catalogTypeID string, payloads []client.PartialEntryPayloadV3, updateAttributes *[]string) error {
+					Expect(*updateAttributes).To(HaveLen(1))
+					updates[(*updateAttributes)[0]] = append(updates[(*updateAttributes)[0]], payloads...)
+					return nil
+				},
+			}
+		})
+
+		literals := func(payloads []client.PartialEntryPayloadV3, attributeID string) map[string][]string {
+			result := map[string][]string{}
+			for _, payload := range payloads {
+				binding := payload.AttributeValues[attributeID]
+				if binding.Value != nil {
+					result[payload.EntryId] = []string{*binding.Value.Literal}
+				}
+				if binding.ArrayValue != nil {
+					for _, value := range *binding.ArrayValue {
+						result[payload.EntryId] = append(result[payload.EntryId], *value.Literal)
+					}
+				}
+			}
+
+			return result
+		}
+
+		It("copies values, converting them to the new type", func() {
+			err := reconcile.MigrateAttributes(context.Background(), kitlog.NewNopLogger(), cl, catalogType.Id, []reconcile.AttributeRename{
+				{
+					From: catalogType.Schema.Attributes[1],
+					To:   client.CatalogTypeAttributePayloadV3{Id: lo.ToPtr("tier"), Type: "Number"},
+				},
+				{
+					From: catalogType.Schema.Attributes[2],
+					To:   client.CatalogTypeAttributePayloadV3{Id: lo.ToPtr("primary_language"), Type: "String"},
+				},
+			}, 25)
+			Expect(err).NotTo(HaveOccurred())
+
+			// entry-2 can't be converted to a number, and entry-3 already has a value.
+			Expect(literals(updates["tier"], "tier")).To(Equal(map[string][]string{
+				"entry-1": {"2"},
+			}))
+			// Moving from an array to a single value keeps only the first.
+			Expect(literals(updates["primary_language"], "primary_language")).To(Equal(map[string][]string{
+				"entry-1": {"go"},
+			}))
+		})
+
+		It("wraps single values when moving to an array", func() {
+			err := reconcile.MigrateAttributes(context.Background(), kitlog.NewNopLogger(), cl, catalogType.Id, []reconcile.AttributeRename{
+				{
+					From: catalogType.Schema.Attributes[1],
+					To:   client.CatalogTypeAttributePayloadV3{Id: lo.ToPtr("tiers"), Type: "String", Array: true},
+				},
+			}, 25)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(literals(updates["tiers"], "tiers")).To(Equal(map[string][]string{
+				"entry-1": {"2"},
+				"entry-2": {"gold"},
+				"entry-3": {"3"},
+			}))
+		})
+	})
+
+	Describe("CopyEntries and RewriteReferences", func() {
+		var (
+			entriesByType map[string][]client.CatalogEntryV3
+			updates       []client.PartialEntryPayloadV3
+			cl            reconcile.EntriesClient
+		)
+		BeforeEach(func() {
+			literal := func(value string) *client.CatalogEntryEngineParamBindingValueV3 {
+				return &client.CatalogEntryEngineParamBindingValueV3{Literal: lo.ToPtr(value)}
+			}
+			entriesByType = map[string][]client.CatalogEntryV3{
+				"old-type": {
+					{
+						Id:         "old-1",
+						Name:       "API",
+						ExternalId: lo.ToPtr("api"),
+						Aliases:    []string{"api-service"},
+						AttributeValues: map[string]client.CatalogEntryEngineParamBindingV3{
+							"notes":      {Value: literal("set from the dashboard")},
+							"depends_on": {ArrayValue: &[]client.CatalogEntryEngineParamBindingValueV3{*literal("old-2")}},
+						},
+					},
+					{Id: "old-2", Name: "Database", ExternalId: lo.ToPtr("database"), Aliases: []string{}},
+				},
+				"new-type": {
+					{Id: "new-2", Name: "Database", ExternalId: lo.ToPtr("database")}, // already copied
+				},
+			}
+			updates = []client.PartialEntryPayloadV3{}
+
+			cl = reconcile.EntriesClient{
+				GetEntries: func(ctx context.Context, catalogTypeID string, pageSize int) (*client.CatalogTypeV3, []client.CatalogEntryV3, error) {
+					return &client.CatalogTypeV3{Id: catalogTypeID}, entriesByType[catalogTypeID], nil
+				},
+				Create: func(ctx context.Context, payload client.CatalogCreateEntryPayloadV3) (*client.CatalogEntryV3, error) {
+					Expect(payload.CatalogTypeId).To(Equal("new-type"))
+					Expect(*payload.ExternalId).To(Equal("api"))
+					Expect(*payload.Aliases).To(Equal([]string{"api-service"}))
+					Expect(payload.AttributeValues).To(HaveKey("notes"))
+					Expect(payload.AttributeValues).NotTo(HaveKey("depends_on"))
+
+					return &client.CatalogEntryV3{Id: "new-1"}, nil
+				},
+				BulkUpdate: func(ctx context.Context, catalogTypeID string, payloads []client.PartialEntryPayloadV3, updateAttributes *[]string) error {
+					updates = append(updates, payloads...)
+					return nil
+				},
+			}
+		})
+
+		It("copies entries and points references at the copies", func() {
+			ctx, logger := context.Background(), kitlog.NewNopLogger()
+
+			entries, entryIDs, err := reconcile.CopyEntries(ctx, logger, cl, "old-type", "new-type", []string{"notes"}, 25)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(2))
+			Expect(entryIDs).To(Equal(map[string]string{"old-1": "new-1", "old-2": "new-2"}))
+
+			Expect(reconcile.RewriteReferences(ctx, logger, cl, "new-type", entries, []string{"depends_on"}, entryIDs)).To(Succeed())
+			Expect(updates).To(HaveLen(1))
+			Expect(updates[0].EntryId).To(Equal("new-1"))
+			Expect(*(*updates[0].AttributeValues["depends_on"].ArrayValue)[0].Literal).To(Equal("new-2"))
+		})
+	})
+})
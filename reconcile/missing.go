@@ -0,0 +1,148 @@
+package reconcile
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/samber/lo"
+)
+
+// AnnotationMissingSince is set on catalog types whose outputs have a delete_after grace
+// period, recording when each entry (by ID) first went missing from source as a JSON
+// object.
+var AnnotationMissingSince = "incident.io/catalog-importer/missing-since"
+
+// missingPlan is what we've decided to do with entries that are no longer in source.
+type missingPlan struct {
+	toDelete     []client.CatalogEntryV3
+	toArchive    []client.CatalogEntryV3
+	missingSince map[string]time.Time // updated state, to be saved into the annotation
+}
+
+// planMissing applies the output's on_missing policy to the entries that are no longer in
+// source, deciding which to delete now and which to archive or keep around for longer.
+//
+// Any entries that aren't in the missing list drop out of the missing-since state, either
+// because they've reappeared or because they're no longer in the catalog. The exception is
+// entries outside of this output's partition, which we leave for their owner to deal with.
+func planMissing(onMissing *output.OnMissing, catalogType *client.CatalogTypeV3, allEntries, ownedEntries, missing []client.CatalogEntryV3, now time.Time) missingPlan {
+	gracePeriod, shouldDelete := onMissing.GracePeriod()
+
+	previous := getMissingSince(catalogType)
+	plan := missingPlan{
+		toDelete:     []client.CatalogEntryV3{},
+		toArchive:    []client.CatalogEntryV3{},
+		missingSince: map[string]time.Time{},
+	}
+
+	ownedIDs := lo.SliceToMap(ownedEntries, func(entry client.CatalogEntryV3) (string, bool) {
+		return entry.Id, true
+	})
+	for _, entry := range allEntries {
+		if since, ok := previous[entry.Id]; ok && !ownedIDs[entry.Id] {
+			plan.missingSince[entry.Id] = since
+		}
+	}
+
+	for _, entry := range missing {
+		since, ok := previous[entry.Id]
+		if !ok {
+			since = now
+		}
+
+		if shouldDelete && now.Sub(since) >= gracePeriod {
+			plan.toDelete = append(plan.toDelete, entry)
+			continue
+		}
+
+		if gracePeriod > 0 {
+			plan.missingSince[entry.Id] = since
+		}
+		if onMissing != nil && onMissing.Archive != nil && !isArchived(entry, onMissing.Archive) {
+			plan.toArchive = append(plan.toArchive, entry)
+		}
+	}
+
+	return plan
+}
+
+// RetainedEntries returns the entries that are no longer in source but that the output's
+// on_missing policy keeps in the catalog as they are, either because they're still within
+// their grace period or because they've already been archived. Syncing leaves these
+// alone, so they shouldn't be reported as drift.
+func RetainedEntries(outputType *output.Output, catalogType *client.CatalogTypeV3, ownedEntries []client.CatalogEntryV3, entryModels []*output.CatalogEntryModel, now time.Time) []client.CatalogEntryV3 {
+	if outputType.OnMissing == nil {
+		return []client.CatalogEntryV3{}
+	}
+
+	inSource := lo.SliceToMap(entryModels, func(model *output.CatalogEntryModel) (string, bool) {
+		return model.ExternalID, true
+	})
+	missing := lo.Filter(ownedEntries, func(entry client.CatalogEntryV3, _ int) bool {
+		return entry.ExternalId == nil || !inSource[*entry.ExternalId]
+	})
+
+	plan := planMissing(outputType.OnMissing, catalogType, ownedEntries, ownedEntries, missing, now)
+	changing := map[string]bool{}
+	for _, entry := range append(plan.toDelete, plan.toArchive...) {
+		changing[entry.Id] = true
+	}
+
+	return lo.Reject(missing, func(entry client.CatalogEntryV3, _ int) bool {
+		return changing[entry.Id]
+	})
+}
+
+// missingSinceChanged returns true if we need to save the missing-since state back to the
+// catalog type.
+func (p missingPlan) missingSinceChanged(catalogType *client.CatalogTypeV3) bool {
+	previous := getMissingSince(catalogType)
+	if len(previous) != len(p.missingSince) {
+		return true
+	}
+	for entryID, since := range p.missingSince {
+		if !previous[entryID].Equal(since) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// annotations returns the catalog type's annotations with the missing-since state
+// replaced.
+func (p missingPlan) annotations(catalogType *client.CatalogTypeV3) map[string]string {
+	annotations := map[string]string{}
+	for key, value := range catalogType.Annotations {
+		annotations[key] = value
+	}
+
+	if len(p.missingSince) == 0 {
+		delete(annotations, AnnotationMissingSince)
+	} else {
+		missingSinceJSON, _ := json.Marshal(p.missingSince)
+		annotations[AnnotationMissingSince] = string(missingSinceJSON)
+	}
+
+	return annotations
+}
+
+func getMissingSince(catalogType *client.CatalogTypeV3) map[string]time.Time {
+	missingSince := map[string]time.Time{}
+	if value, ok := catalogType.Annotations[AnnotationMissingSince]; ok {
+		_ = json.Unmarshal([]byte(value), &missingSince) // if this is corrupt, start over
+	}
+
+	return missingSince
+}
+
+func isArchived(entry client.CatalogEntryV3, archive *output.OnMissingArchive) bool {
+	binding, ok := entry.AttributeValues[archive.Attribute]
+	if !ok || binding.Value == nil {
+		return false
+	}
+
+	return lo.FromPtr(binding.Value.Literal) == archive.ArchivedValue()
+}
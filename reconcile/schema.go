@@ -0,0 +1,67 @@
+package reconcile
+
+import (
+	"fmt"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/samber/lo"
+)
+
+// SchemaChange is a change to a catalog type's schema that would lose the values held by
+// an attribute.
+type SchemaChange struct {
+	AttributeID string
+	Description string
+}
+
+func (c SchemaChange) String() string {
+	return fmt.Sprintf("%s: %s", c.AttributeID, c.Description)
+}
+
+// DestructiveSchemaChanges compares the attributes we're about to declare against the
+// current schema of a catalog type, returning any changes that would destroy attribute
+// values: removing an attribute, or changing its type or whether it's an array, which
+// loses any values we can't convert.
+//
+// Attributes that are being renamed have their values migrated first, so removing the
+// old attribute isn't considered destructive. The same goes for attributes referencing a
+// catalog type that is being migrated to a new type name, given as a map of old type
+// name to new.
+func DestructiveSchemaChanges(catalogType *client.CatalogTypeV3, attributes []client.CatalogTypeAttributePayloadV3, renames []AttributeRename, typeMigrations map[string]string) []SchemaChange {
+	changes := []SchemaChange{}
+	for _, existing := range catalogType.Schema.Attributes {
+		attr, ok := lo.Find(attributes, func(attr client.CatalogTypeAttributePayloadV3) bool {
+			return lo.FromPtr(attr.Id) == existing.Id
+		})
+		if !ok {
+			_, renamed := lo.Find(renames, func(rename AttributeRename) bool {
+				return rename.From.Id == existing.Id
+			})
+			if !renamed {
+				changes = append(changes, SchemaChange{
+					AttributeID: existing.Id,
+					Description: fmt.Sprintf("attribute '%s' would be removed, along with all its values", existing.Name),
+				})
+			}
+
+			continue
+		}
+
+		// We convert the values of attributes that change type (see PendingTypeChanges), but
+		// any that can't be converted are lost.
+		if attr.Type != existing.Type && typeMigrations[existing.Type] != attr.Type {
+			changes = append(changes, SchemaChange{
+				AttributeID: existing.Id,
+				Description: fmt.Sprintf("type would change from %s to %s, losing any values that can't be converted", existing.Type, attr.Type),
+			})
+		}
+		if attr.Array != existing.Array {
+			changes = append(changes, SchemaChange{
+				AttributeID: existing.Id,
+				Description: fmt.Sprintf("array would change from %v to %v, losing any values that can't be converted", existing.Array, attr.Array),
+			})
+		}
+	}
+
+	return changes
+}
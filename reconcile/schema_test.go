@@ -0,0 +1,76 @@
+package reconcile_test
+
+import (
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+	"github.com/samber/lo"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DestructiveSchemaChanges", func() {
+	catalogType := &client.CatalogTypeV3{
+		Schema: client.CatalogTypeSchemaV3{
+			Attributes: []client.CatalogTypeAttributeV3{
+				{Id: "owner", Name: "Owner", Type: "String"},
+				{Id: "tier", Name: "Tier", Type: "String"},
+				{Id: "tags", Name: "Tags", Type: "String", Array: true},
+				{Id: "runbook", Name: "Runbook", Type: "String"},
+			},
+		},
+	}
+
+	changedAttributeIDs := func(changes []reconcile.SchemaChange) []string {
+		return lo.Map(changes, func(change reconcile.SchemaChange, _ int) string {
+			return change.AttributeID
+		})
+	}
+
+	It("allows adding attributes and changing names", func() {
+		changes := reconcile.DestructiveSchemaChanges(catalogType, []client.CatalogTypeAttributePayloadV3{
+			{Id: lo.ToPtr("owner"), Name: "Team", Type: "String"},
+			{Id: lo.ToPtr("tier"), Name: "Tier", Type: "String"},
+			{Id: lo.ToPtr("tags"), Name: "Tags", Type: "String", Array: true},
+			{Id: lo.ToPtr("runbook"), Name: "Runbook", Type: "String"},
+			{Id: lo.ToPtr("new"), Name: "New", Type: "Bool"},
+		}, nil, nil)
+		Expect(changes).To(BeEmpty())
+	})
+
+	It("flags removed attributes and type or array changes", func() {
+		changes := reconcile.DestructiveSchemaChanges(catalogType, []client.CatalogTypeAttributePayloadV3{
+			{Id: lo.ToPtr("tier"), Name: "Tier", Type: "Number"},
+			{Id: lo.ToPtr("tags"), Name: "Tags", Type: "String", Array: false},
+		}, nil, nil)
+		Expect(changedAttributeIDs(changes)).To(Equal([]string{"owner", "tier", "tags", "runbook"}))
+		Expect(changes[1].String()).To(Equal("tier: type would change from String to Number, losing any values that can't be converted"))
+	})
+
+	It("doesn't flag references to a type being migrated", func() {
+		catalogType := &client.CatalogTypeV3{
+			Schema: client.CatalogTypeSchemaV3{
+				Attributes: []client.CatalogTypeAttributeV3{
+					{Id: "service", Name: "Service", Type: `Custom["Svc"]`},
+				},
+			},
+		}
+
+		changes := reconcile.DestructiveSchemaChanges(catalogType, []client.CatalogTypeAttributePayloadV3{
+			{Id: lo.ToPtr("service"), Name: "Service", Type: `Custom["Service"]`},
+		}, nil, map[string]string{`Custom["Svc"]`: `Custom["Service"]`})
+		Expect(changes).To(BeEmpty())
+	})
+
+	It("doesn't flag attributes being renamed", func() {
+		attributes := []client.CatalogTypeAttributePayloadV3{
+			{Id: lo.ToPtr("team"), Name: "Team", Type: "String"},
+			{Id: lo.ToPtr("tier"), Name: "Tier", Type: "String"},
+			{Id: lo.ToPtr("tags"), Name: "Tags", Type: "String", Array: true},
+			{Id: lo.ToPtr("runbook"), Name: "Runbook", Type: "String"},
+		}
+		renames := reconcile.PendingRenames(catalogType, map[string]string{"team": "owner"}, attributes)
+
+		Expect(reconcile.DestructiveSchemaChanges(catalogType, attributes, renames, nil)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,213 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Snapshot is a point-in-time copy of the catalog types managed by a sync ID, including
+// their schemas and every entry, so they can be restored if a sync goes wrong.
+type Snapshot struct {
+	SyncID       string                `json:"sync_id"`
+	TakenAt      time.Time             `json:"taken_at"`
+	CatalogTypes []SnapshotCatalogType `json:"catalog_types"`
+}
+
+// SnapshotCatalogType is a catalog type as it was when the snapshot was taken.
+type SnapshotCatalogType struct {
+	CatalogType client.CatalogTypeV3    `json:"catalog_type"`
+	Entries     []client.CatalogEntryV3 `json:"entries"`
+}
+
+// TakeSnapshot lists the entries of each of the given catalog types, recording them
+// alongside the type's current schema.
+func TakeSnapshot(ctx context.Context, logger kitlog.Logger, cl EntriesClient, syncID string, catalogTypes []client.CatalogTypeV3, pageSize int) (*Snapshot, error) {
+	snapshot := &Snapshot{
+		SyncID:       syncID,
+		TakenAt:      time.Now(),
+		CatalogTypes: []SnapshotCatalogType{},
+	}
+	for _, catalogType := range catalogTypes {
+		// Listing entries also returns the latest version of the type, which is what we
+		// want to record.
+		latest, entries, err := cl.GetEntries(ctx, catalogType.Id, pageSize)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("listing entries of %s", catalogType.TypeName))
+		}
+
+		logger.Log("msg", "snapshotted catalog type", "catalog_type_id", catalogType.Id, "entries", len(entries))
+		snapshot.CatalogTypes = append(snapshot.CatalogTypes, SnapshotCatalogType{
+			CatalogType: *latest,
+			Entries:     entries,
+		})
+	}
+
+	return snapshot, nil
+}
+
+// LoadSnapshot reads a snapshot from disk.
+func LoadSnapshot(filename string) (*Snapshot, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading snapshot")
+	}
+
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, errors.Wrap(err, "parsing snapshot")
+	}
+
+	return snapshot, nil
+}
+
+// Save writes the snapshot to disk.
+func (s *Snapshot) Save(filename string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshalling snapshot")
+	}
+
+	// As with checkpoints, never leave a half-written snapshot behind.
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return errors.Wrap(err, "writing snapshot")
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return errors.Wrap(err, "writing snapshot")
+	}
+
+	return nil
+}
+
+// EntryCount is the total number of entries across every catalog type in the snapshot.
+func (s *Snapshot) EntryCount() int {
+	return lo.SumBy(s.CatalogTypes, func(catalogType SnapshotCatalogType) int {
+		return len(catalogType.Entries)
+	})
+}
+
+// EntriesWithoutExternalID counts the snapshotted entries that have no external ID. These
+// can't be restored, as reconciling the type would delete them instead.
+func (c SnapshotCatalogType) EntriesWithoutExternalID() int {
+	return lo.CountBy(c.Entries, func(entry client.CatalogEntryV3) bool {
+		return lo.FromPtr(entry.ExternalId) == ""
+	})
+}
+
+// Output builds an output declaring every attribute of the snapshotted schema that holds
+// values, including those usually left to the dashboard, so that reconciling against it
+// restores all of them.
+//
+// Attributes that reference other catalog types in the snapshot are only included if
+// withReferences is set, as the entries they point at may need restoring first.
+func (s *Snapshot) Output(catalogType SnapshotCatalogType, withReferences bool) *output.Output {
+	op := &output.Output{
+		Name:        catalogType.CatalogType.Name,
+		Description: catalogType.CatalogType.Description,
+		TypeName:    catalogType.CatalogType.TypeName,
+		Ranked:      catalogType.CatalogType.Ranked,
+		Attributes:  []*output.Attribute{},
+	}
+	for _, attr := range catalogType.CatalogType.Schema.Attributes {
+		if attr.BacklinkAttribute != nil || attr.Path != nil {
+			continue // derived, so there's nothing to restore
+		}
+		if !withReferences && s.isSnapshotted(attr.Type) {
+			continue
+		}
+
+		op.Attributes = append(op.Attributes, &output.Attribute{
+			ID:    attr.Id,
+			Name:  attr.Name,
+			Type:  null.StringFrom(attr.Type),
+			Array: attr.Array,
+		})
+	}
+
+	return op
+}
+
+// EntryModels converts the snapshotted entries back into models that can be reconciled
+// against the output, returning how many entries were skipped because they had no
+// external ID to reconcile by.
+//
+// References to entries that no longer exist (those missing from liveEntryIDs) are
+// swapped for the external ID of the entry, as the entry will be recreated with a new
+// ID.
+func (s *Snapshot) EntryModels(catalogType SnapshotCatalogType, op *output.Output, liveEntryIDs map[string]bool) ([]*output.CatalogEntryModel, int) {
+	externalIDs := map[string]string{}
+	for _, snapshotted := range s.CatalogTypes {
+		for _, entry := range snapshotted.Entries {
+			if entry.ExternalId != nil {
+				externalIDs[entry.Id] = *entry.ExternalId
+			}
+		}
+	}
+
+	rewrite := func(value client.CatalogEngineParamBindingValuePayloadV3) client.CatalogEngineParamBindingValuePayloadV3 {
+		literal := lo.FromPtr(value.Literal)
+		if externalID, ok := externalIDs[literal]; ok && !liveEntryIDs[literal] {
+			return client.CatalogEngineParamBindingValuePayloadV3{Literal: lo.ToPtr(externalID)}
+		}
+
+		return value
+	}
+
+	models, skipped := []*output.CatalogEntryModel{}, 0
+	for _, entry := range catalogType.Entries {
+		if entry.ExternalId == nil || *entry.ExternalId == "" {
+			skipped++
+			continue
+		}
+
+		attributeValues := map[string]client.CatalogEngineParamBindingPayloadV3{}
+		for _, attr := range op.Attributes {
+			binding := bindingToPayload(entry.AttributeValues[attr.ID])
+			if bindingIsEmpty(binding) {
+				continue
+			}
+
+			if s.isSnapshotted(attr.Type.String) {
+				if binding.Value != nil {
+					binding.Value = lo.ToPtr(rewrite(*binding.Value))
+				}
+				if binding.ArrayValue != nil {
+					binding.ArrayValue = lo.ToPtr(lo.Map(*binding.ArrayValue, func(value client.CatalogEngineParamBindingValuePayloadV3, _ int) client.CatalogEngineParamBindingValuePayloadV3 {
+						return rewrite(value)
+					}))
+				}
+			}
+			attributeValues[attr.ID] = binding
+		}
+
+		models = append(models, &output.CatalogEntryModel{
+			ExternalID:      *entry.ExternalId,
+			Name:            entry.Name,
+			Aliases:         entry.Aliases,
+			Rank:            entry.Rank,
+			AttributeValues: attributeValues,
+		})
+	}
+
+	return models, skipped
+}
+
+// isSnapshotted returns true if the snapshot contains the catalog type with this type
+// name.
+func (s *Snapshot) isSnapshotted(typeName string) bool {
+	_, ok := lo.Find(s.CatalogTypes, func(catalogType SnapshotCatalogType) bool {
+		return catalogType.CatalogType.TypeName == typeName
+	})
+
+	return ok
+}
@@ -0,0 +1,163 @@
+package reconcile_test
+
+import (
+	"context"
+	"path/filepath"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+	"github.com/samber/lo"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Snapshot", func() {
+	literal := func(value string) *client.CatalogEntryEngineParamBindingValueV3 {
+		return &client.CatalogEntryEngineParamBindingValueV3{Literal: lo.ToPtr(value)}
+	}
+
+	var snapshot *reconcile.Snapshot
+	BeforeEach(func() {
+		snapshot = &reconcile.Snapshot{
+			SyncID: "sync-123",
+			CatalogTypes: []reconcile.SnapshotCatalogType{
+				{
+					CatalogType: client.CatalogTypeV3{
+						Id:       "type-team",
+						TypeName: `Custom["Team"]`,
+						Schema: client.CatalogTypeSchemaV3{
+							Attributes: []client.CatalogTypeAttributeV3{
+								{Id: "slack", Name: "Slack", Type: "String"},
+							},
+						},
+					},
+					Entries: []client.CatalogEntryV3{
+						{Id: "team-1", Name: "Payments", ExternalId: lo.ToPtr("payments")},
+						{Id: "team-2", Name: "Core", ExternalId: lo.ToPtr("core")},
+					},
+				},
+				{
+					CatalogType: client.CatalogTypeV3{
+						Id:       "type-service",
+						TypeName: `Custom["Service"]`,
+						Schema: client.CatalogTypeSchemaV3{
+							Attributes: []client.CatalogTypeAttributeV3{
+								{Id: "tier", Name: "Tier", Type: "Number"},
+								{Id: "owners", Name: "Owners", Type: `Custom["Team"]`, Array: true},
+								{Id: "owner_slack", Name: "Owner Slack", Type: "String", Path: &[]client.CatalogTypeAttributePathItemV3{
+									{AttributeId: "owners"}, {AttributeId: "slack"},
+								}},
+							},
+						},
+					},
+					Entries: []client.CatalogEntryV3{
+						{
+							Id:         "service-1",
+							Name:       "API",
+							ExternalId: lo.ToPtr("api"),
+							Aliases:    []string{"api-server"},
+							Rank:       2,
+							AttributeValues: map[string]client.CatalogEntryEngineParamBindingV3{
+								"tier":   {Value: literal("1")},
+								"owners": {ArrayValue: &[]client.CatalogEntryEngineParamBindingValueV3{*literal("team-1"), *literal("team-2")}},
+							},
+						},
+						{Id: "service-2", Name: "Created in the dashboard"},
+					},
+				},
+			},
+		}
+	})
+
+	Describe("TakeSnapshot", func() {
+		It("records the latest schema and every entry of each type", func() {
+			latest := snapshot.CatalogTypes[1]
+			latest.CatalogType.Schema.Version = 5
+
+			cl := reconcile.EntriesClient{
+				GetEntries: func(ctx context.Context, catalogTypeID string, pageSize int) (*client.CatalogTypeV3, []client.CatalogEntryV3, error) {
+					Expect(catalogTypeID).To(Equal("type-service"))
+					return &latest.CatalogType, latest.Entries, nil
+				},
+			}
+
+			taken, err := reconcile.TakeSnapshot(context.Background(), kitlog.NewNopLogger(), cl, "sync-123",
+				[]client.CatalogTypeV3{{Id: "type-service"}}, 100)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(taken.SyncID).To(Equal("sync-123"))
+			Expect(taken.CatalogTypes).To(HaveLen(1))
+			Expect(taken.CatalogTypes[0].CatalogType.Schema.Version).To(Equal(int64(5)))
+			Expect(taken.EntryCount()).To(Equal(2))
+		})
+	})
+
+	It("round-trips through a file", func() {
+		filename := filepath.Join(GinkgoT().TempDir(), "snapshot.json")
+		Expect(snapshot.Save(filename)).To(Succeed())
+
+		loaded, err := reconcile.LoadSnapshot(filename)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(loaded.SyncID).To(Equal("sync-123"))
+		Expect(loaded.EntryCount()).To(Equal(4))
+	})
+
+	Describe("Output", func() {
+		attributeIDs := func(op *output.Output) []string {
+			return lo.Map(op.Attributes, func(attr *output.Attribute, _ int) string {
+				return attr.ID
+			})
+		}
+
+		It("declares every attribute that holds values", func() {
+			op := snapshot.Output(snapshot.CatalogTypes[1], true)
+			Expect(op.TypeName).To(Equal(`Custom["Service"]`))
+			Expect(attributeIDs(op)).To(Equal([]string{"tier", "owners"}))
+			Expect(op.Attributes[1].Type.String).To(Equal(`Custom["Team"]`))
+			Expect(op.Attributes[1].IncludeInPayload()).To(BeTrue())
+		})
+
+		It("leaves out references to snapshotted types when asked", func() {
+			op := snapshot.Output(snapshot.CatalogTypes[1], false)
+			Expect(attributeIDs(op)).To(Equal([]string{"tier"}))
+		})
+	})
+
+	Describe("EntriesWithoutExternalID", func() {
+		It("counts entries that can't be matched when restoring", func() {
+			Expect(snapshot.CatalogTypes[0].EntriesWithoutExternalID()).To(Equal(0))
+			Expect(snapshot.CatalogTypes[1].EntriesWithoutExternalID()).To(Equal(1))
+		})
+	})
+
+	Describe("EntryModels", func() {
+		It("converts entries, skipping those without an external ID", func() {
+			op := snapshot.Output(snapshot.CatalogTypes[1], true)
+			models, skipped := snapshot.EntryModels(snapshot.CatalogTypes[1], op, map[string]bool{"team-1": true})
+			Expect(skipped).To(Equal(1))
+			Expect(models).To(HaveLen(1))
+
+			model := models[0]
+			Expect(model.ExternalID).To(Equal("api"))
+			Expect(model.Name).To(Equal("API"))
+			Expect(model.Aliases).To(Equal([]string{"api-server"}))
+			Expect(model.Rank).To(Equal(int32(2)))
+			Expect(*model.AttributeValues["tier"].Value.Literal).To(Equal("1"))
+
+			By("keeping references to live entries, and using external IDs for the rest")
+			owners := lo.Map(*model.AttributeValues["owners"].ArrayValue, func(value client.CatalogEngineParamBindingValuePayloadV3, _ int) string {
+				return *value.Literal
+			})
+			Expect(owners).To(Equal([]string{"team-1", "core"}))
+		})
+
+		It("only includes values for the output's attributes", func() {
+			op := snapshot.Output(snapshot.CatalogTypes[1], false)
+			models, _ := snapshot.EntryModels(snapshot.CatalogTypes[1], op, nil)
+			Expect(models[0].AttributeValues).To(HaveKey("tier"))
+			Expect(models[0].AttributeValues).NotTo(HaveKey("owners"))
+		})
+	})
+})
@@ -0,0 +1,180 @@
+package reconcile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// State records what the importer last applied to each entry, so we can later tell
+// whether a difference between source and catalog came from a change in source or from
+// someone editing the entry in the catalog.
+type State struct {
+	SyncID string `json:"sync_id"`
+	// Outputs maps catalog type name to what we last applied to its entries.
+	Outputs map[string]*OutputState `json:"outputs"`
+
+	filename string
+}
+
+// OutputState is what we last applied to the entries of a single catalog type.
+type OutputState struct {
+	AppliedAt time.Time               `json:"applied_at"`
+	Entries   map[string]AppliedEntry `json:"entries"` // by external ID
+}
+
+// AppliedEntry is the part of an entry the importer controls: attributes that are
+// shared with the dashboard aren't included, as changing those isn't drift.
+type AppliedEntry struct {
+	Name            string                                               `json:"name"`
+	Aliases         []string                                             `json:"aliases"`
+	Rank            int32                                                `json:"rank"`
+	AttributeValues map[string]client.CatalogEngineParamBindingPayloadV3 `json:"attribute_values"`
+}
+
+// Hash summarises the entry, such that two entries with the same hash are the same.
+func (e AppliedEntry) Hash() string {
+	data, _ := json.Marshal(e) // map keys are sorted, so this is stable
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// managedAttributes returns the attributes of the output whose values the importer alone
+// controls.
+func managedAttributes(outputType *output.Output) []*output.Attribute {
+	return lo.Filter(outputType.Attributes, func(attr *output.Attribute, _ int) bool {
+		return attr.IncludeInPayload() && !attr.IsSharedWithDashboard()
+	})
+}
+
+// AppliedEntriesFromModels builds the applied entries for models built from source, by
+// external ID.
+func AppliedEntriesFromModels(outputType *output.Output, models []*output.CatalogEntryModel) map[string]AppliedEntry {
+	attributes := managedAttributes(outputType)
+
+	entries := map[string]AppliedEntry{}
+	for _, model := range models {
+		entries[model.ExternalID] = newAppliedEntry(model.Name, model.Aliases, model.Rank, attributes, func(attributeID string) client.CatalogEngineParamBindingPayloadV3 {
+			return model.AttributeValues[attributeID]
+		})
+	}
+
+	return entries
+}
+
+// AppliedEntriesFromCatalog builds the applied entries for entries as they are in the
+// catalog, by external ID. Entries without an external ID were never applied by us, so
+// are ignored.
+func AppliedEntriesFromCatalog(outputType *output.Output, catalogEntries []client.CatalogEntryV3) map[string]AppliedEntry {
+	attributes := managedAttributes(outputType)
+
+	entries := map[string]AppliedEntry{}
+	for _, entry := range catalogEntries {
+		if entry.ExternalId == nil {
+			continue
+		}
+		entries[*entry.ExternalId] = newAppliedEntry(entry.Name, entry.Aliases, entry.Rank, attributes, func(attributeID string) client.CatalogEngineParamBindingPayloadV3 {
+			return bindingToPayload(entry.AttributeValues[attributeID])
+		})
+	}
+
+	return entries
+}
+
+func newAppliedEntry(name string, aliases []string, rank int32, attributes []*output.Attribute, getValue func(attributeID string) client.CatalogEngineParamBindingPayloadV3) AppliedEntry {
+	entry := AppliedEntry{
+		Name:            name,
+		Aliases:         lo.Ternary(aliases == nil, []string{}, aliases),
+		Rank:            rank,
+		AttributeValues: map[string]client.CatalogEngineParamBindingPayloadV3{},
+	}
+	for _, attr := range attributes {
+		if binding := getValue(attr.ID); !bindingIsEmpty(binding) {
+			entry.AttributeValues[attr.ID] = binding
+		}
+	}
+
+	return entry
+}
+
+// ErrStateSyncIDMismatch is returned when loading state that was recorded by a different
+// sync ID, which tells us nothing about what this sync applied.
+var ErrStateSyncIDMismatch = errors.New("state belongs to a different sync ID")
+
+// NewState creates an empty state that will be saved to filename.
+func NewState(filename, syncID string) *State {
+	return &State{
+		SyncID:   syncID,
+		Outputs:  map[string]*OutputState{},
+		filename: filename,
+	}
+}
+
+// LoadState reads the state from disk, returning an empty state if there is none yet.
+func LoadState(filename, syncID string) (*State, error) {
+	state := NewState(filename, syncID)
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+
+		return nil, errors.Wrap(err, "reading state")
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, errors.Wrap(err, "parsing state")
+	}
+	if state.SyncID != syncID {
+		return nil, errors.Wrapf(ErrStateSyncIDMismatch, "state file %s belongs to sync ID '%s', not '%s'", filename, state.SyncID, syncID)
+	}
+
+	return state, nil
+}
+
+// Applied returns what we last applied to the entries of the catalog type, if anything.
+func (s *State) Applied(typeName string) (map[string]AppliedEntry, bool) {
+	outputState, ok := s.Outputs[typeName]
+	if !ok {
+		return nil, false
+	}
+
+	return outputState.Entries, true
+}
+
+// Record replaces what we last applied to the entries of the catalog type, and saves the
+// state to disk. It does nothing on a nil state, so callers such as a dry-run can opt out.
+func (s *State) Record(typeName string, entries map[string]AppliedEntry) error {
+	if s == nil {
+		return nil
+	}
+
+	s.Outputs[typeName] = &OutputState{
+		AppliedAt: time.Now(),
+		Entries:   entries,
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshalling state")
+	}
+
+	tmp := s.filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return errors.Wrap(err, "writing state")
+	}
+	if err := os.Rename(tmp, s.filename); err != nil {
+		return errors.Wrap(err, "writing state")
+	}
+
+	return nil
+}
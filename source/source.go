@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
+	"strings"
 
 	kitlog "github.com/go-kit/kit/log"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/hashicorp/go-cleanhttp"
+	"github.com/samber/lo"
 )
 
 // SourceEntry is an entry that has been discovered in a source, with the contents of the
@@ -18,25 +20,54 @@ type SourceEntry struct {
 	Origin   string // the source origin e.g. inline
 	Filename string // the filename that it should be evaluated under e.g. app/main.jsonnet
 	Content  []byte // the content of the source
+	Source   string // the type of source that produced it e.g. github, set by Source.Load
+	// Metadata is any detail about where the entry came from that's specific to the type
+	// of source, such as the repo and ref of a GitHub file.
+	Metadata map[string]any
 }
 
+// MetaKey is the reserved key under which each parsed entry has its provenance, so
+// expressions can refer to where an entry came from with $["$meta"].
+const MetaKey = "$meta"
+
+// Parse extracts the entries from the content, adding the provenance of each under
+// MetaKey, replacing anything the content had there.
 func (e SourceEntry) Parse() ([]Entry, error) {
 	entries := Parse(e.Filename, e.Content)
 	if len(entries) == 0 {
 		return entries, fmt.Errorf("failed to parse any entries")
 	}
 
+	for _, entry := range entries {
+		entry[MetaKey] = e.Meta()
+	}
+
 	return entries, nil
 }
 
+// Meta returns the provenance of the entry, which is the same for every entry parsed from
+// it.
+func (e SourceEntry) Meta() map[string]any {
+	return lo.Assign(map[string]any{
+		"source":   e.Source,
+		"origin":   e.Origin,
+		"filename": e.Filename,
+	}, e.Metadata)
+}
+
 // Source is instantiated from configuration and represents a source of catalog files.
 type Source struct {
-	Local     *SourceLocal     `json:"local,omitempty"`
-	Inline    *SourceInline    `json:"inline,omitempty"`
-	Exec      *SourceExec      `json:"exec,omitempty"`
-	Backstage *SourceBackstage `json:"backstage,omitempty"`
-	GitHub    *SourceGitHub    `json:"github,omitempty"`
-	GraphQL   *SourceGraphQL   `json:"graphql,omitempty"`
+	Local      *SourceLocal      `json:"local,omitempty"`
+	Inline     *SourceInline     `json:"inline,omitempty"`
+	Exec       *SourceExec       `json:"exec,omitempty"`
+	Backstage  *SourceBackstage  `json:"backstage,omitempty"`
+	GitHub     *SourceGitHub     `json:"github,omitempty"`
+	GitLab     *SourceGitLab     `json:"gitlab,omitempty"`
+	Git        *SourceGit        `json:"git,omitempty"`
+	GraphQL    *SourceGraphQL    `json:"graphql,omitempty"`
+	Kubernetes *SourceKubernetes `json:"kubernetes,omitempty"`
+	SQL        *SourceSQL        `json:"sql,omitempty"`
+	LDAP       *SourceLDAP       `json:"ldap,omitempty"`
 }
 
 func (s Source) Validate() error {
@@ -75,20 +106,58 @@ func (s Source) Backend() (SourceBackend, error) {
 	if s.GitHub != nil {
 		return s.GitHub, nil
 	}
+	if s.GitLab != nil {
+		return s.GitLab, nil
+	}
+	if s.Git != nil {
+		return s.Git, nil
+	}
 	if s.GraphQL != nil {
 		return s.GraphQL, nil
 	}
+	if s.Kubernetes != nil {
+		return s.Kubernetes, nil
+	}
+	if s.SQL != nil {
+		return s.SQL, nil
+	}
+	if s.LDAP != nil {
+		return s.LDAP, nil
+	}
 
 	return nil, ErrInvalidSourceEmpty
 }
 
 var ErrInvalidSourceEmpty = fmt.Errorf("invalid source, must specify at least one type of source configuration")
 
+// Type returns the name of the type of source configured, as used in the config e.g.
+// github.
+func (s Source) Type() string {
+	value := reflect.ValueOf(s)
+	for idx := range value.NumField() {
+		if !value.Field(idx).IsNil() {
+			name, _, _ := strings.Cut(value.Type().Field(idx).Tag.Get("json"), ",")
+			return name
+		}
+	}
+
+	return ""
+}
+
 func (s Source) Load(ctx context.Context, logger kitlog.Logger) ([]*SourceEntry, error) {
 	source, err := s.Backend()
 	if err != nil {
 		return nil, err
 	}
 
-	return source.Load(ctx, logger, cleanhttp.DefaultClient())
+	entries, err := source.Load(ctx, logger, cleanhttp.DefaultClient())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		entry.Source = s.Type()
+	}
+
+	return entries, nil
 }
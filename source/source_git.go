@@ -0,0 +1,354 @@
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	kitlog "github.com/go-kit/kit/log"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+type SourceGit struct {
+	Repos    []SourceGitRepo `json:"repos"`
+	Files    []string        `json:"files"`
+	CacheDir string          `json:"cache_dir,omitempty"` // defaults to the user's cache directory
+	Username Credential      `json:"username,omitempty"`  // for HTTPS, defaults to git
+	Password Credential      `json:"password,omitempty"`  // for HTTPS, normally an access token
+	SSHKey   Credential      `json:"ssh_key,omitempty"`   // PEM private key for SSH, defaults to ssh-agent
+}
+
+type SourceGitRepo struct {
+	URL string `json:"url"`           // e.g. https://git.example.com/platform/catalog.git
+	Ref string `json:"ref,omitempty"` // branch, tag or commit SHA, defaults to the remote HEAD
+}
+
+func (s SourceGit) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Repos, validation.Length(1, 0).
+			Error("must provide at least one repo when using git source")),
+		validation.Field(&s.Files, validation.Length(1, 0).
+			Error("must provide at least one file pattern when using git source")),
+	)
+}
+
+func (r SourceGitRepo) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.URL, validation.Required),
+	)
+}
+
+func (s SourceGit) String() string {
+	urls := []string{}
+	for _, repo := range s.Repos {
+		urls = append(urls, repo.URL)
+	}
+
+	return fmt.Sprintf("git (repos=%s files=%s)", urls, s.Files)
+}
+
+var commitSHA = regexp.MustCompile("^[0-9a-f]{40}$")
+
+func (s SourceGit) Load(ctx context.Context, logger kitlog.Logger, _ *http.Client) ([]*SourceEntry, error) {
+	cacheDir := s.CacheDir
+	if cacheDir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, errors.Wrap(err, "finding cache directory, set cache_dir instead")
+		}
+		cacheDir = filepath.Join(userCacheDir, "catalog-importer", "git")
+	}
+
+	// Use this whenever we're modifying structures that are race unsafe.
+	var mu sync.Mutex
+	synchronise := func(do func()) {
+		defer mu.Unlock()
+		mu.Lock()
+
+		do()
+	}
+
+	// Repos are cached by URL, so we must only fetch one ref of each at a time.
+	repoLocks := map[string]*sync.Mutex{}
+	for _, repo := range s.Repos {
+		repoLocks[repo.URL] = &sync.Mutex{}
+	}
+
+	entries := []*SourceEntry{}
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(10)
+
+	for _, repo := range s.Repos {
+		g.Go(func() error {
+			logger := kitlog.With(logger, "url", repo.URL, "ref", repo.Ref)
+
+			repoLocks[repo.URL].Lock()
+			commit, err := s.fetch(ctx, logger, cacheDir, repo)
+			repoLocks[repo.URL].Unlock()
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("fetching '%s'", repo.URL))
+			}
+
+			repoEntries, err := s.matchFiles(repo, commit)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("reading files from '%s' at %s", repo.URL, commit.Hash))
+			}
+
+			synchronise(func() {
+				logger.Log("msg", "found matching git files", "commit", commit.Hash.String(), "count", len(repoEntries))
+				entries = append(entries, repoEntries...)
+			})
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// fetch brings the cached copy of the repo up to date with the ref, returning the commit
+// it points at.
+//
+// Each repo is cached as a bare repository, so after the first run we only fetch the
+// objects that have changed. Branches and tags are fetched shallow, as we only ever read
+// the latest commit, while commit SHAs need the full history as not every server lets us
+// ask for an arbitrary commit.
+func (s SourceGit) fetch(ctx context.Context, logger kitlog.Logger, cacheDir string, repo SourceGitRepo) (*object.Commit, error) {
+	key := sha256.Sum256([]byte(repo.URL))
+	dir := filepath.Join(cacheDir, hex.EncodeToString(key[:8]))
+
+	local, err := git.PlainOpen(dir)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		logger.Log("msg", "cloning git repo into cache", "dir", dir)
+		local, err = git.PlainInit(dir, true)
+		if err == nil {
+			_, err = local.CreateRemote(&config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{repo.URL}})
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "opening cached repo")
+	}
+
+	auth, err := s.auth(repo.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	fetchOpts := &git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		Auth:       auth,
+		Tags:       git.NoTags,
+		Force:      true,
+	}
+
+	var target plumbing.ReferenceName
+	if commitSHA.MatchString(repo.Ref) {
+		// If we already have the commit there's nothing to fetch, as commits never change.
+		if commit, err := commitFor(local, plumbing.NewHash(repo.Ref)); err == nil {
+			return commit, nil
+		}
+
+		fetchOpts.RefSpecs = []config.RefSpec{
+			"+refs/heads/*:refs/remotes/origin/*",
+			"+refs/tags/*:refs/tags/*",
+		}
+	} else {
+		remoteRef, err := s.resolveRef(ctx, local, auth, repo.Ref)
+		if err != nil {
+			return nil, err
+		}
+
+		// Fetch into a ref named after the one we asked for, so we can find it next time.
+		target = plumbing.ReferenceName("refs/catalog-importer/" + strings.TrimPrefix(remoteRef.Name().String(), "refs/"))
+		if existing, err := local.Reference(target, true); err == nil && existing.Hash() == remoteRef.Hash() {
+			logger.Log("msg", "cached git repo is up to date", "commit", existing.Hash().String())
+			return commitFor(local, existing.Hash())
+		}
+
+		fetchOpts.RefSpecs = []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", remoteRef.Name(), target))}
+		fetchOpts.Depth = 1
+	}
+
+	logger.Log("msg", "fetching git repo", "dir", dir)
+	if err := local.FetchContext(ctx, fetchOpts); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil, errors.Wrap(err, "fetching")
+	}
+
+	if target == "" {
+		return commitFor(local, plumbing.NewHash(repo.Ref))
+	}
+
+	ref, err := local.Reference(target, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "finding fetched ref")
+	}
+
+	return commitFor(local, ref.Hash())
+}
+
+// commitFor returns the commit for a hash, peeling annotated tags.
+func commitFor(local *git.Repository, hash plumbing.Hash) (*object.Commit, error) {
+	if tag, err := local.TagObject(hash); err == nil {
+		return tag.Commit()
+	}
+
+	return local.CommitObject(hash)
+}
+
+// resolveRef finds the remote branch or tag for a ref, or what HEAD points to if no ref is
+// given.
+func (s SourceGit) resolveRef(ctx context.Context, local *git.Repository, auth transport.AuthMethod, ref string) (*plumbing.Reference, error) {
+	remote, err := local.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing remote refs")
+	}
+
+	candidates := []plumbing.ReferenceName{
+		plumbing.NewBranchReferenceName(ref),
+		plumbing.NewTagReferenceName(ref),
+	}
+	if ref == "" {
+		candidates = []plumbing.ReferenceName{plumbing.HEAD}
+	}
+
+	byName := map[plumbing.ReferenceName]*plumbing.Reference{}
+	for _, remoteRef := range refs {
+		byName[remoteRef.Name()] = remoteRef
+	}
+
+	for _, candidate := range candidates {
+		remoteRef, ok := byName[candidate]
+		if !ok {
+			continue
+		}
+
+		// HEAD is normally a symbolic reference to the default branch, which is what we
+		// need to fetch.
+		if remoteRef.Type() == plumbing.SymbolicReference {
+			if target, ok := byName[remoteRef.Target()]; ok {
+				return target, nil
+			}
+			continue
+		}
+
+		return remoteRef, nil
+	}
+
+	if ref == "" {
+		return nil, errors.New("remote has no HEAD, so must set a ref")
+	}
+
+	return nil, fmt.Errorf("no branch or tag named '%s'", ref)
+}
+
+func (s SourceGit) auth(url string) (transport.AuthMethod, error) {
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing repo URL")
+	}
+
+	switch endpoint.Protocol {
+	case "http", "https":
+		if s.Password == "" {
+			return nil, nil
+		}
+
+		username := string(s.Username)
+		if username == "" {
+			username = "git"
+		}
+
+		return &githttp.BasicAuth{Username: username, Password: string(s.Password)}, nil
+	case "ssh":
+		user := endpoint.User
+		if user == "" {
+			user = "git"
+		}
+		if s.SSHKey != "" {
+			return gitssh.NewPublicKeys(user, []byte(s.SSHKey), "")
+		}
+
+		return gitssh.NewSSHAgentAuth(user)
+	default:
+		return nil, nil
+	}
+}
+
+// matchFiles reads the files in the commit that match any of our patterns.
+func (s SourceGit) matchFiles(repo SourceGitRepo, commit *object.Commit) ([]*SourceEntry, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*SourceEntry{}
+	err = tree.Files().ForEach(func(file *object.File) error {
+		for _, pattern := range s.Files {
+			match, err := doublestar.Match(pattern, file.Name)
+			if err != nil {
+				return errors.Wrap(err, "matching file pattern")
+			}
+			if !match {
+				continue
+			}
+
+			reader, err := file.Reader()
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("reading '%s'", file.Name))
+			}
+
+			entries = append(entries, &SourceEntry{
+				Origin:   fmt.Sprintf("git (repo=%s commit=%s path=%s)", repo.URL, commit.Hash, file.Name),
+				Filename: file.Name,
+				Content:  data,
+				Metadata: map[string]any{
+					"repo":   repo.URL,
+					"ref":    repo.Ref,
+					"commit": commit.Hash.String(),
+				},
+			})
+
+			return nil // each file only once, however many patterns it matches
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,122 @@
+package source_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/incident-io/catalog-importer/v2/source"
+	"github.com/samber/lo"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SourceGit", func() {
+	var (
+		ctx      context.Context
+		logger   kitlog.Logger
+		s        source.SourceGit
+		upstream *git.Repository
+		dir      string
+	)
+
+	// commit writes the files into the upstream repo and commits them.
+	commit := func(files map[string]string) plumbing.Hash {
+		worktree, err := upstream.Worktree()
+		Expect(err).NotTo(HaveOccurred())
+
+		for name, content := range files {
+			Expect(os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)).To(Succeed())
+			_, err := worktree.Add(name)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		hash, err := worktree.Commit("update", &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		return hash
+	}
+
+	load := func() map[string]string {
+		entries, err := s.Load(ctx, logger, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		return lo.SliceToMap(entries, func(entry *source.SourceEntry) (string, string) {
+			return entry.Origin, string(entry.Content)
+		})
+	}
+
+	var first plumbing.Hash
+	BeforeEach(func() {
+		ctx = context.Background()
+		logger = kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stderr))
+
+		dir = GinkgoT().TempDir()
+		var err error
+		upstream, err = git.PlainInit(dir, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		first = commit(map[string]string{
+			"catalog-info.yaml":         "name: root",
+			"services/api/catalog.yaml": "name: api",
+			"services/api/README.md":    "# API",
+		})
+
+		s = source.SourceGit{
+			Repos:    []source.SourceGitRepo{{URL: "file://" + dir}},
+			Files:    []string{"**/*.yaml"},
+			CacheDir: GinkgoT().TempDir(),
+		}
+	})
+
+	It("loads matching files from the default branch", func() {
+		Expect(load()).To(Equal(map[string]string{
+			"git (repo=file://" + dir + " commit=" + first.String() + " path=catalog-info.yaml)":         "name: root",
+			"git (repo=file://" + dir + " commit=" + first.String() + " path=services/api/catalog.yaml)": "name: api",
+		}))
+	})
+
+	It("fetches new commits on later runs", func() {
+		Expect(load()).To(HaveLen(2))
+
+		second := commit(map[string]string{"services/web/catalog.yaml": "name: web"})
+		entries := load()
+		Expect(entries).To(HaveLen(3))
+		Expect(entries).To(HaveKeyWithValue(
+			"git (repo=file://"+dir+" commit="+second.String()+" path=services/web/catalog.yaml)", "name: web"))
+	})
+
+	It("loads from a tag", func() {
+		_, err := upstream.CreateTag("v1", first, &git.CreateTagOptions{
+			Tagger:  &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+			Message: "v1",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		commit(map[string]string{"catalog-info.yaml": "name: changed"})
+
+		s.Repos[0].Ref = "v1"
+		Expect(load()).To(ContainElement("name: root"))
+	})
+
+	It("loads from a commit SHA", func() {
+		commit(map[string]string{"catalog-info.yaml": "name: changed"})
+
+		s.Repos[0].Ref = first.String()
+		Expect(load()).To(ContainElement("name: root"))
+	})
+
+	It("errors for a ref that doesn't exist", func() {
+		s.Repos[0].Ref = "missing"
+		_, err := s.Load(ctx, logger, nil)
+		Expect(err).To(MatchError(ContainSubstring("no branch or tag named 'missing'")))
+	})
+})
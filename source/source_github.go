@@ -5,51 +5,209 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net/http"
+	"path"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/bmatcuk/doublestar/v4"
 	kitlog "github.com/go-kit/kit/log"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
 	"github.com/google/go-github/v52/github"
 	"github.com/pkg/errors"
+	"github.com/samber/lo"
 	"golang.org/x/oauth2"
 	"golang.org/x/sync/errgroup"
 )
 
 type SourceGitHub struct {
+	// Repos are of the form owner/repo, where repo can be a glob such as * or service-*,
+	// optionally followed by @ref to read a branch, tag or SHA instead of the default
+	// branch.
 	Repos []string   `json:"repos"`
 	Files []string   `json:"files"`
 	Token Credential `json:"token"`
+	// App authenticates as a GitHub App installation instead of with a token.
+	App *SourceGitHubApp `json:"app,omitempty"`
+	// BaseURL is the API URL of a GitHub Enterprise Server, e.g.
+	// https://github.example.com/api/v3/. Defaults to github.com.
+	BaseURL string `json:"base_url,omitempty"`
 	// ExcludeArchived, if true, will cause the source to exclude files from
 	// repositories that have been archived. Defaults to false (archived repositories are included).
 	ExcludeArchived bool `json:"excludeArchived,omitempty"`
+	// ExcludeRepos are globs of owner/repo to skip, e.g. example-org/sandbox-*.
+	ExcludeRepos []string `json:"exclude_repos,omitempty"`
+	// Topics, if set, only includes repositories with at least one of these topics.
+	Topics []string `json:"topics,omitempty"`
+	// ExcludeTopics skips repositories with any of these topics.
+	ExcludeTopics []string `json:"exclude_topics,omitempty"`
+	// Visibility, if set, only includes repositories with one of these visibilities:
+	// public, private or internal.
+	Visibility []string `json:"visibility,omitempty"`
+	// ExcludeForks, if true, skips repositories that are forks.
+	ExcludeForks bool `json:"exclude_forks,omitempty"`
+	// PushedWithinDays, if set, skips repositories that haven't been pushed to in this
+	// many days.
+	PushedWithinDays int `json:"pushed_within_days,omitempty"`
+	// Discovery is how we find matching files: tree (the default) lists every file in
+	// each repository, while search uses GitHub code search, which needs far fewer
+	// requests for large organizations but requires each files pattern to end in a fixed
+	// filename.
+	Discovery string `json:"discovery,omitempty"`
+	// CacheDir is where GitHub responses and file contents are kept between runs,
+	// defaulting to the user's cache directory.
+	CacheDir string `json:"cache_dir,omitempty"`
+	// DisableCache, if true, stops us caching anything on disk.
+	DisableCache bool `json:"disable_cache,omitempty"`
+	// Mode is what we load: files (the default) reads the files matching Files from each
+	// repository, while metadata describes the repositories themselves, the teams of the
+	// organizations that own them, and the rules in their CODEOWNERS files.
+	Mode string `json:"mode,omitempty"`
 }
 
+const (
+	GitHubDiscoveryTree   = "tree"
+	GitHubDiscoverySearch = "search"
+
+	GitHubModeFiles    = "files"
+	GitHubModeMetadata = "metadata"
+)
+
 func (s SourceGitHub) Validate() error {
 	return validation.ValidateStruct(&s,
+		validation.Field(&s.Token, validation.By(func(value any) error {
+			if s.Token != "" && s.App != nil {
+				return errors.New("must provide either a token or app, not both")
+			}
+
+			return nil
+		})),
+		validation.Field(&s.App),
+		validation.Field(&s.BaseURL, is.URL),
 		validation.Field(&s.Repos, validation.Each(
-			validation.Match(regexp.MustCompile("^[^/]/.+$")).
-				Error("repos must be of the form owner/repo, or owner/* for matching all repos under that organization"),
+			validation.Match(regexp.MustCompile("^[^/@]+/[^/@]+(@.+)?$")).
+				Error("repos must be of the form owner/repo, or owner/* for matching all repos under that organization, optionally followed by @ref"),
+		)),
+		validation.Field(&s.ExcludeRepos, validation.Each(
+			validation.By(func(value any) error {
+				if _, err := path.Match(value.(string), ""); err != nil {
+					return errors.Wrap(err, "invalid glob")
+				}
+
+				return nil
+			}),
 		)),
+		validation.Field(&s.Visibility, validation.Each(
+			validation.In("public", "private", "internal"),
+		)),
+		validation.Field(&s.PushedWithinDays, validation.Min(0)),
+		validation.Field(&s.Discovery, validation.In(GitHubDiscoveryTree, GitHubDiscoverySearch)),
+		validation.Field(&s.Mode, validation.In(GitHubModeFiles, GitHubModeMetadata)),
+		validation.Field(&s.Files, validation.Each(validation.By(func(value any) error {
+			if s.Discovery != GitHubDiscoverySearch {
+				return nil
+			}
+			if _, ok := searchFilename(value.(string)); !ok {
+				return errors.New("search discovery needs files patterns to end in a fixed filename, e.g. **/catalog-info.yaml")
+			}
+
+			return nil
+		}))),
 	)
 }
 
+// searchFilename returns the filename that a files pattern must match, if it has a fixed
+// one that we can use with code search.
+func searchFilename(pattern string) (string, bool) {
+	filename := path.Base(pattern)
+	if strings.ContainsAny(filename, `*?[{\`) {
+		return "", false
+	}
+
+	return filename, true
+}
+
+// excludeReason returns why a repository should be skipped, or an empty string if it
+// should be included.
+func (s SourceGitHub) excludeReason(repo *github.Repository, now time.Time) string {
+	if repo.GetArchived() && s.ExcludeArchived {
+		return "archived, excludeArchived is true"
+	}
+	if repo.GetFork() && s.ExcludeForks {
+		return "fork, exclude_forks is true"
+	}
+	for _, pattern := range s.ExcludeRepos {
+		if match, _ := path.Match(pattern, repo.GetFullName()); match {
+			return fmt.Sprintf("matches exclude_repos pattern %s", pattern)
+		}
+	}
+	if len(s.Topics) > 0 && !lo.Some(repo.Topics, s.Topics) {
+		return "has none of the topics"
+	}
+	if topics := lo.Intersect(repo.Topics, s.ExcludeTopics); len(topics) > 0 {
+		return fmt.Sprintf("has excluded topics %s", strings.Join(topics, ", "))
+	}
+	if len(s.Visibility) > 0 && !lo.Contains(s.Visibility, repo.GetVisibility()) {
+		return fmt.Sprintf("visibility is %s", repo.GetVisibility())
+	}
+	if s.PushedWithinDays > 0 && repo.GetPushedAt().Before(now.AddDate(0, 0, -s.PushedWithinDays)) {
+		return fmt.Sprintf("not pushed to since %s", repo.GetPushedAt().Format(time.DateOnly))
+	}
+
+	return ""
+}
+
 func (s SourceGitHub) String() string {
 	return fmt.Sprintf("github (repos=%s files=%s)", s.Repos, s.Files)
 }
 
-func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger, _ *http.Client) ([]*SourceEntry, error) {
-	client := github.NewClient(oauth2.NewClient(ctx, oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: string(s.Token)},
-	)))
+type githubTarget struct {
+	Owner    string // e.g. incident-io
+	Repo     string // e.g. catalog-importer
+	Ref      string // e.g. main or master
+	Pinned   bool   // if the ref was chosen with @ref, rather than the default branch
+	Wildcard bool   // if the repo was found by a wildcard, rather than named explicitly
+	HTMLURL  string // e.g. https://github.com/incident-io/catalog-importer
+	Matches  []*github.TreeEntry
+
+	Repository *github.Repository
+}
+
+// entry builds the source entry for a matching file, with metadata that lets expressions
+// link back to it.
+func (t *githubTarget) entry(match *github.TreeEntry, data []byte) *SourceEntry {
+	return &SourceEntry{
+		Origin:   fmt.Sprintf("github (repo=%s/%s path=%s)", t.Owner, t.Repo, match.GetPath()),
+		Filename: match.GetPath(),
+		Content:  data,
+		Metadata: map[string]any{
+			"owner": t.Owner,
+			"repo":  t.Repo,
+			"ref":   t.Ref,
+			"sha":   match.GetSHA(),
+			"url":   fmt.Sprintf("%s/blob/%s/%s", t.HTMLURL, t.Ref, match.GetPath()),
+		},
+	}
+}
 
-	type Target struct {
-		Owner   string // e.g. incident-io
-		Repo    string // e.g. catalog-importer
-		Ref     string // e.g. main or master
-		Matches []*github.TreeEntry
+func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger, httpClient *http.Client) ([]*SourceEntry, error) {
+	// The cache only saves requests, so if we can't use it we carry on without.
+	var cache *githubCache
+	if !s.DisableCache {
+		var err error
+		cache, err = newGitHubCache(s.CacheDir, s.credential())
+		if err != nil {
+			logger.Log("msg", "GitHub cache is unavailable, continuing without it", "error", err)
+			cache = nil
+		}
+	}
+
+	client, err := s.client(ctx, logger, httpClient, cache)
+	if err != nil {
+		return nil, err
 	}
 
 	// Use this whenever we're modifying structures that are race unsafe.
@@ -62,25 +220,39 @@ func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger, _ *http.Cl
 	}
 
 	// Expand any repo wildcards so we have a full list of repos for each owner we want to
-	// scan.
-	targets := []*Target{}
-	addTarget := func(logger kitlog.Logger, repo *github.Repository) {
+	// scan. A repo can be matched by more than one pattern, in which case we prefer the
+	// pattern that named it explicitly, as that's where you'd pin a ref.
+	targetsByName := map[string]*githubTarget{}
+	explicitTargets := map[string]bool{}
+	now := time.Now()
+	addTarget := func(logger kitlog.Logger, repo *github.Repository, ref string, explicit bool) {
 		synchronise(func() {
-			if repo.GetArchived() && s.ExcludeArchived {
-				logger.Log("msg", "skipping archived repo, excludeArchived is true",
+			if reason := s.excludeReason(repo, now); reason != "" {
+				logger.Log("msg", "skipping repo", "reason", reason,
 					"owner", repo.Owner.GetLogin(), "repo", repo.GetName())
 				return
 			}
+			if explicitTargets[repo.GetFullName()] || (targetsByName[repo.GetFullName()] != nil && !explicit) {
+				return
+			}
+
+			target := &githubTarget{
+				Owner:    repo.Owner.GetLogin(),
+				Repo:     repo.GetName(),
+				Ref:      repo.GetDefaultBranch(),
+				Wildcard: !explicit,
+				HTMLURL:  repo.GetHTMLURL(),
 
-			target := &Target{
-				Owner: repo.Owner.GetLogin(),
-				Repo:  repo.GetName(),
-				Ref:   repo.GetDefaultBranch(),
+				Repository: repo,
+			}
+			if ref != "" {
+				target.Ref, target.Pinned = ref, true
 			}
 
 			logger.Log("msg", "found GitHub repo",
 				"owner", target.Owner, "repo", target.Repo, "ref", target.Ref)
-			targets = append(targets, target)
+			targetsByName[repo.GetFullName()] = target
+			explicitTargets[repo.GetFullName()] = explicit
 		})
 	}
 
@@ -89,6 +261,7 @@ func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger, _ *http.Cl
 		g.SetLimit(10)
 
 		for _, providedRepo := range s.Repos {
+			providedRepo, ref, _ := strings.Cut(providedRepo, "@")
 			components := strings.SplitN(providedRepo, "/", 2)
 			if len(components) != 2 {
 				return nil, fmt.Errorf("invalid format for repo must be owner/repo but got '%s'", providedRepo)
@@ -97,9 +270,10 @@ func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger, _ *http.Cl
 			g.Go(func() error {
 				owner, repoNameOrWildcard := components[0], components[1]
 
-				// We've found an owner/* repo, so we must find all the repos under this organisation.
-				if repoNameOrWildcard == "*" {
-					logger.Log("msg", "found repo wildcard, resolving repo list", "owner", owner)
+				// We've found an owner/* repo, or another glob, so we must find all the repos
+				// under this organisation.
+				if strings.ContainsAny(repoNameOrWildcard, "*?[") {
+					logger.Log("msg", "found repo wildcard, resolving repo list", "owner", owner, "pattern", repoNameOrWildcard)
 					opts := &github.RepositoryListByOrgOptions{
 						ListOptions: github.ListOptions{PerPage: 100},
 					}
@@ -110,7 +284,9 @@ func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger, _ *http.Cl
 							return errors.Wrap(err, fmt.Sprintf("listing GitHub repos for organization '%s'", owner))
 						}
 						for _, repo := range page {
-							addTarget(logger, repo)
+							if match, _ := path.Match(repoNameOrWildcard, repo.GetName()); match {
+								addTarget(logger, repo, ref, false)
+							}
 						}
 						if resp.NextPage == 0 {
 							break
@@ -125,7 +301,7 @@ func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger, _ *http.Cl
 						return errors.Wrap(err, fmt.Sprintf("accessing '%s/%s'", owner, repoNameOrWildcard))
 					}
 
-					addTarget(logger, resolved)
+					addTarget(logger, resolved, ref, true)
 				}
 
 				return nil
@@ -137,18 +313,46 @@ func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger, _ *http.Cl
 		}
 	}
 
+	targets := lo.Values(targetsByName)
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Owner+"/"+targets[i].Repo < targets[j].Owner+"/"+targets[j].Repo
+	})
+
+	if s.Mode == GitHubModeMetadata {
+		return s.loadMetadata(ctx, logger, client, targets)
+	}
+
+	// Code search only indexes the default branch, so repos with a pinned ref always list
+	// their tree.
+	treeTargets := targets
+	if s.Discovery == GitHubDiscoverySearch {
+		searchTargets, rest := lo.FilterReject(targets, func(target *githubTarget, _ int) bool {
+			return !target.Pinned
+		})
+		if err := s.search(ctx, logger, client, searchTargets); err != nil {
+			return nil, err
+		}
+
+		treeTargets = rest
+	}
+
 	{
 		g, ctx := errgroup.WithContext(ctx)
 		g.SetLimit(10)
 
-		for idx := range targets {
-			target := targets[idx]
+		for idx := range treeTargets {
+			target := treeTargets[idx]
 
 			g.Go(func() error {
 				logger.Log("msg", "listing GitHub tree",
 					"owner", target.Owner, "repo", target.Repo, "ref", target.Ref)
 				tree, _, err := client.Git.GetTree(ctx, target.Owner, target.Repo, target.Ref, true)
 				if err != nil {
+					// A pinned ref that can't be found is most likely a typo, or a tag that was
+					// removed, rather than an empty repo.
+					if target.Pinned {
+						return errors.Wrap(err, fmt.Sprintf("getting tree for '%s/%s' at pinned ref %s, check the ref exists", target.Owner, target.Repo, target.Ref))
+					}
 					if repositoryEmpty(err) {
 						logger.Log("msg", "GitHub repository is empty, skipping",
 							"owner", target.Owner, "repo", target.Repo, "ref", target.Ref)
@@ -197,6 +401,16 @@ func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger, _ *http.Cl
 				match := target.Matches[jdx]
 
 				g.Go(func() error {
+					if data, ok := cache.Blob(match.GetSHA()); ok {
+						synchronise(func() {
+							logger.Log("msg", "found matching GitHub file in cache",
+								"owner", target.Owner, "repo", target.Repo, "ref", target.Ref, "path", match.GetPath())
+							entries = append(entries, target.entry(match, data))
+						})
+
+						return nil
+					}
+
 					blob, _, err := client.Git.GetBlob(ctx, target.Owner, target.Repo, match.GetSHA())
 					if err != nil {
 						return errors.Wrap(err,
@@ -215,14 +429,14 @@ func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger, _ *http.Cl
 							fmt.Sprintf("decoding base64 blob for '%s' from repo '%s/%s' at SHA %s", match.GetPath(), target.Owner, target.Repo, target.Ref))
 					}
 
+					if err := cache.SaveBlob(match.GetSHA(), data); err != nil {
+						return errors.Wrap(err, "caching GitHub blob")
+					}
+
 					synchronise(func() {
 						logger.Log("msg", "found matching GitHub file",
 							"owner", target.Owner, "repo", target.Repo, "ref", target.Ref, "path", match.GetPath())
-						entries = append(entries, &SourceEntry{
-							Origin:   fmt.Sprintf("github (repo=%s/%s path=%s)", target.Owner, target.Repo, match.GetPath()),
-							Filename: match.GetPath(),
-							Content:  []byte(data),
-						})
+						entries = append(entries, target.entry(match, data))
 					})
 
 					return nil
@@ -238,6 +452,125 @@ func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger, _ *http.Cl
 	return entries, nil
 }
 
+// credential identifies what we authenticate as. Installation tokens change every hour,
+// so for apps we use the installation instead.
+func (s SourceGitHub) credential() string {
+	if s.App != nil {
+		return fmt.Sprintf("app:%s:%s", s.App.AppID, s.App.InstallationID)
+	}
+
+	return fmt.Sprintf("token:%s", s.Token)
+}
+
+// client builds a GitHub client authenticated with either the token or the app, which
+// waits out rate limits and caches responses.
+func (s SourceGitHub) client(ctx context.Context, logger kitlog.Logger, httpClient *http.Client, cache *githubCache) (*github.Client, error) {
+	rateLimited := &http.Client{
+		Transport: githubRateLimitTransport(logger, httpClient.Transport),
+		Timeout:   httpClient.Timeout,
+	}
+
+	var tokenSource oauth2.TokenSource = oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: string(s.Token)},
+	)
+	if s.App != nil {
+		var err error
+		tokenSource, err = s.App.TokenSource(ctx, rateLimited, s.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newGitHubClient(&http.Client{
+		Transport: &oauth2.Transport{Source: tokenSource, Base: cache.Transport(rateLimited.Transport)},
+		Timeout:   httpClient.Timeout,
+	}, s.BaseURL)
+}
+
+// search finds files matching our patterns in the targets using code search, which needs
+// a few requests per organization rather than several per repo.
+// https://docs.github.com/en/rest/search/search#search-code
+func (s SourceGitHub) search(ctx context.Context, logger kitlog.Logger, client *github.Client, targets []*githubTarget) error {
+	targetsByName := lo.KeyBy(targets, func(target *githubTarget) string {
+		return target.Owner + "/" + target.Repo
+	})
+
+	// Search whole organizations we found with a wildcard, and explicit repos individually.
+	qualifiers := []string{}
+	for _, target := range targets {
+		if target.Wildcard {
+			qualifiers = append(qualifiers, "org:"+target.Owner)
+		}
+	}
+	for _, target := range targets {
+		if !target.Wildcard && !lo.Contains(qualifiers, "org:"+target.Owner) {
+			qualifiers = append(qualifiers, fmt.Sprintf("repo:%s/%s", target.Owner, target.Repo))
+		}
+	}
+	qualifiers = lo.Uniq(qualifiers)
+
+	filenames := lo.Uniq(lo.FilterMap(s.Files, func(pattern string, _ int) (string, bool) {
+		return searchFilename(pattern)
+	}))
+
+	seen := map[string]bool{}
+	for _, filename := range filenames {
+		for _, qualifier := range qualifiers {
+			query := fmt.Sprintf("filename:%s %s", filename, qualifier)
+			logger.Log("msg", "searching GitHub code", "query", query)
+
+			opts := &github.SearchOptions{ListOptions: github.ListOptions{PerPage: 100}}
+			for {
+				result, resp, err := client.Search.Code(ctx, query, opts)
+				if err != nil {
+					return errors.Wrap(err, fmt.Sprintf("searching GitHub code for '%s'", query))
+				}
+				if result.GetTotal() > 1000 {
+					return fmt.Errorf("GitHub code search found %d files for '%s' but only returns the first 1000, so use tree discovery or narrow the repos", result.GetTotal(), query)
+				}
+				if result.GetIncompleteResults() {
+					logger.Log("msg", "GitHub code search timed out and may be missing files", "query", query)
+				}
+
+				for _, code := range result.CodeResults {
+					target, ok := targetsByName[code.GetRepository().GetFullName()]
+					if !ok {
+						continue // the repo was filtered out
+					}
+
+					key := fmt.Sprintf("%s/%s", code.GetRepository().GetFullName(), code.GetPath())
+					if seen[key] {
+						continue
+					}
+					seen[key] = true
+
+					for _, pattern := range s.Files {
+						match, err := doublestar.Match(pattern, code.GetPath())
+						if err != nil {
+							return errors.Wrap(err, "matching file pattern")
+						}
+						if match {
+							target.Matches = append(target.Matches, &github.TreeEntry{
+								Path: code.Path,
+								SHA:  code.SHA,
+								Type: github.String("blob"),
+							})
+							break
+						}
+					}
+				}
+
+				if resp.NextPage == 0 {
+					break
+				}
+				opts.Page = resp.NextPage
+			}
+		}
+	}
+
+	return nil
+}
+
 func repositoryEmpty(err error) bool {
 	if err == nil {
 		return false
@@ -0,0 +1,141 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/go-github/v52/github"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// SourceGitHubApp authenticates as a GitHub App installation, rather than with a
+// long-lived personal access token. Installation tokens last an hour and are refreshed
+// automatically.
+type SourceGitHubApp struct {
+	AppID          Credential `json:"app_id"`          // e.g. 123456 or $(GITHUB_APP_ID)
+	InstallationID Credential `json:"installation_id"` // from the URL of the installation's settings page
+	PrivateKey     Credential `json:"private_key"`     // PEM private key generated for the app
+}
+
+func (a SourceGitHubApp) Validate() error {
+	isInt := validation.By(func(value any) error {
+		if _, err := strconv.ParseInt(string(value.(Credential)), 10, 64); err != nil {
+			return errors.New("must be a number")
+		}
+
+		return nil
+	})
+
+	return validation.ValidateStruct(&a,
+		validation.Field(&a.AppID, validation.Required, isInt),
+		validation.Field(&a.InstallationID, validation.Required, isInt),
+		validation.Field(&a.PrivateKey, validation.Required),
+	)
+}
+
+// TokenSource returns a source of installation tokens, refreshing them before they
+// expire. Requests for tokens are made with the given GitHub client, which must not
+// itself authenticate.
+func (a SourceGitHubApp) TokenSource(ctx context.Context, client *http.Client, baseURL string) (oauth2.TokenSource, error) {
+	appID, err := strconv.ParseInt(string(a.AppID), 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing GitHub App ID")
+	}
+	installationID, err := strconv.ParseInt(string(a.InstallationID), 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing GitHub App installation ID")
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(a.PrivateKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing GitHub App private key")
+	}
+
+	// Apps authenticate as themselves with a short-lived JWT, which they exchange for an
+	// installation token.
+	appClient, err := newGitHubClient(&http.Client{
+		Transport: &githubAppTransport{base: client.Transport, appID: appID, sign: func(claims jwt.Claims) (string, error) {
+			return jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+		}},
+		Timeout: client.Timeout,
+	}, baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return oauth2.ReuseTokenSource(nil, &githubAppTokenSource{
+		ctx:            ctx,
+		client:         appClient,
+		installationID: installationID,
+	}), nil
+}
+
+type githubAppTokenSource struct {
+	ctx            context.Context
+	client         *github.Client
+	installationID int64
+}
+
+func (s *githubAppTokenSource) Token() (*oauth2.Token, error) {
+	token, _, err := s.client.Apps.CreateInstallationToken(s.ctx, s.installationID, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("creating GitHub App installation token for installation %d", s.installationID))
+	}
+
+	return &oauth2.Token{
+		AccessToken: token.GetToken(),
+		TokenType:   "Bearer",
+		Expiry:      token.GetExpiresAt().Time,
+	}, nil
+}
+
+// githubAppTransport signs each request with a fresh JWT for the app.
+type githubAppTransport struct {
+	base  http.RoundTripper
+	appID int64
+	sign  func(jwt.Claims) (string, error)
+}
+
+func (t *githubAppTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// GitHub allows JWTs to last at most 10 minutes, and recommends backdating them to
+	// allow for clock drift.
+	now := time.Now()
+	signed, err := t.sign(jwt.RegisteredClaims{
+		Issuer:    strconv.FormatInt(t.appID, 10),
+		IssuedAt:  jwt.NewNumericDate(now.Add(-time.Minute)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(9 * time.Minute)),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "signing GitHub App JWT")
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+signed)
+
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return base.RoundTrip(req)
+}
+
+// newGitHubClient builds a client for github.com, or for GitHub Enterprise Server if a
+// base URL is given.
+func newGitHubClient(client *http.Client, baseURL string) (*github.Client, error) {
+	if baseURL == "" {
+		return github.NewClient(client), nil
+	}
+
+	ghClient, err := github.NewEnterpriseClient(baseURL, baseURL, client)
+	if err != nil {
+		return nil, errors.Wrap(err, "building GitHub Enterprise client")
+	}
+
+	return ghClient, nil
+}
@@ -0,0 +1,337 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/google/go-github/v52/github"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"golang.org/x/sync/errgroup"
+)
+
+// codeownersPaths are where GitHub looks for a CODEOWNERS file, in the order it checks.
+// https://docs.github.com/en/repositories/managing-your-repositorys-settings-and-features/customizing-your-repository/about-code-owners#codeowners-file-location
+var codeownersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// loadMetadata emits an entry for each repository, for each team in the organizations
+// that own them, and for each rule in their CODEOWNERS files. Each entry has a kind of
+// repository, team or codeowners so outputs can filter for the ones they want.
+func (s SourceGitHub) loadMetadata(ctx context.Context, logger kitlog.Logger, client *github.Client, targets []*githubTarget) ([]*SourceEntry, error) {
+	// Use this whenever we're modifying structures that are race unsafe.
+	var mu sync.Mutex
+	synchronise := func(do func()) {
+		defer mu.Unlock()
+		mu.Lock()
+
+		do()
+	}
+
+	entries := []*SourceEntry{}
+	addEntry := func(origin string, metadata map[string]any, entry map[string]any) error {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return errors.Wrap(err, "marshalling GitHub metadata")
+		}
+
+		synchronise(func() {
+			entries = append(entries, &SourceEntry{
+				Origin:   origin,
+				Content:  data,
+				Metadata: metadata,
+			})
+		})
+
+		return nil
+	}
+
+	// Users can own repos too, but only organizations have teams.
+	orgs := lo.Uniq(lo.FilterMap(targets, func(target *githubTarget, _ int) (string, bool) {
+		return target.Owner, target.Repository.GetOwner().GetType() != "User"
+	}))
+
+	type orgTeam struct {
+		Org  string
+		Team *github.Team
+	}
+
+	teams := []orgTeam{}
+	{
+		g, ctx := errgroup.WithContext(ctx)
+		g.SetLimit(10)
+
+		for _, target := range targets {
+			repo := target.Repository
+			err := addEntry(fmt.Sprintf("github (repo=%s/%s)", target.Owner, target.Repo), map[string]any{
+				"owner": target.Owner,
+				"repo":  target.Repo,
+				"ref":   target.Ref,
+				"url":   target.HTMLURL,
+			}, map[string]any{
+				"kind":           "repository",
+				"owner":          target.Owner,
+				"name":           target.Repo,
+				"full_name":      repo.GetFullName(),
+				"description":    repo.GetDescription(),
+				"url":            repo.GetHTMLURL(),
+				"topics":         lo.Ternary(repo.Topics != nil, repo.Topics, []string{}),
+				"language":       repo.GetLanguage(),
+				"visibility":     repo.GetVisibility(),
+				"archived":       repo.GetArchived(),
+				"fork":           repo.GetFork(),
+				"default_branch": repo.GetDefaultBranch(),
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			g.Go(func() error {
+				rules, path, err := s.getCodeowners(ctx, client, target)
+				if err != nil {
+					return errors.Wrap(err, fmt.Sprintf("getting CODEOWNERS for '%s/%s'", target.Owner, target.Repo))
+				}
+				if path == "" {
+					logger.Log("msg", "GitHub repo has no CODEOWNERS file", "owner", target.Owner, "repo", target.Repo)
+					return nil
+				}
+
+				logger.Log("msg", "found GitHub CODEOWNERS file",
+					"owner", target.Owner, "repo", target.Repo, "path", path, "rules", len(rules))
+				for _, rule := range rules {
+					err := addEntry(fmt.Sprintf("github (repo=%s/%s path=%s line=%d)", target.Owner, target.Repo, path, rule.Line), map[string]any{
+						"owner": target.Owner,
+						"repo":  target.Repo,
+						"ref":   target.Ref,
+						"url":   fmt.Sprintf("%s/blob/%s/%s#L%d", target.HTMLURL, target.Ref, path, rule.Line),
+					}, map[string]any{
+						"kind":      "codeowners",
+						"owner":     target.Owner,
+						"repo":      target.Repo,
+						"full_name": target.Owner + "/" + target.Repo,
+						"path":      path,
+						"line":      rule.Line,
+						"pattern":   rule.Pattern,
+						"owners":    rule.Owners,
+						"users":     rule.Users,
+						"teams":     rule.Teams,
+						"emails":    rule.Emails,
+					})
+					if err != nil {
+						return err
+					}
+				}
+
+				return nil
+			})
+		}
+
+		for _, org := range orgs {
+			g.Go(func() error {
+				orgTeams, err := s.listTeams(ctx, client, org)
+				if err != nil {
+					// Owners can be users, which we can't tell from a repo we found by name.
+					var errResp *github.ErrorResponse
+					if errors.As(err, &errResp) && errResp.Response.StatusCode == http.StatusNotFound {
+						logger.Log("msg", "GitHub owner has no teams, so is probably a user, skipping", "owner", org)
+						return nil
+					}
+
+					return errors.Wrap(err, fmt.Sprintf("listing GitHub teams for organization '%s'", org))
+				}
+
+				logger.Log("msg", "found GitHub teams", "org", org, "count", len(orgTeams))
+				synchronise(func() {
+					for _, team := range orgTeams {
+						teams = append(teams, orgTeam{Org: org, Team: team})
+					}
+				})
+
+				return nil
+			})
+		}
+
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+	}
+
+	{
+		g, ctx := errgroup.WithContext(ctx)
+		g.SetLimit(10)
+
+		for _, team := range teams {
+			org, team := team.Org, team.Team
+
+			g.Go(func() error {
+				members, err := s.listTeamMembers(ctx, client, org, team.GetSlug())
+				if err != nil {
+					return errors.Wrap(err, fmt.Sprintf("listing members of GitHub team '%s/%s'", org, team.GetSlug()))
+				}
+
+				var parent any
+				if team.Parent != nil {
+					parent = team.Parent.GetSlug()
+				}
+
+				return addEntry(fmt.Sprintf("github (org=%s team=%s)", org, team.GetSlug()), map[string]any{
+					"owner": org,
+					"url":   team.GetHTMLURL(),
+				}, map[string]any{
+					"kind":        "team",
+					"org":         org,
+					"slug":        team.GetSlug(),
+					"full_slug":   org + "/" + team.GetSlug(),
+					"name":        team.GetName(),
+					"description": team.GetDescription(),
+					"url":         team.GetHTMLURL(),
+					"privacy":     team.GetPrivacy(),
+					"parent":      parent,
+					"members":     members,
+				})
+			})
+		}
+
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+	}
+
+	// We load concurrently, but want entries in a stable order.
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Origin < entries[j].Origin
+	})
+
+	return entries, nil
+}
+
+func (s SourceGitHub) listTeams(ctx context.Context, client *github.Client, org string) ([]*github.Team, error) {
+	teams := []*github.Team{}
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := client.Teams.ListTeams(ctx, org, opts)
+		if err != nil {
+			return nil, err
+		}
+		teams = append(teams, page...)
+		if resp.NextPage == 0 {
+			return teams, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// listTeamMembers returns the logins of everyone in the team, including those in child
+// teams, which is how GitHub treats membership when requesting reviews.
+func (s SourceGitHub) listTeamMembers(ctx context.Context, client *github.Client, org, slug string) ([]string, error) {
+	members := []string{}
+	opts := &github.TeamListTeamMembersOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		page, resp, err := client.Teams.ListTeamMembersBySlug(ctx, org, slug, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range page {
+			members = append(members, member.GetLogin())
+		}
+		if resp.NextPage == 0 {
+			return members, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// getCodeowners finds and parses the CODEOWNERS file of the repo, returning the path it
+// was found at, or an empty path if there isn't one.
+func (s SourceGitHub) getCodeowners(ctx context.Context, client *github.Client, target *githubTarget) ([]codeownersRule, string, error) {
+	for _, path := range codeownersPaths {
+		file, _, resp, err := client.Repositories.GetContents(ctx, target.Owner, target.Repo, path,
+			&github.RepositoryContentGetOptions{Ref: target.Ref})
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if file == nil {
+			continue // it's a directory
+		}
+
+		content, err := file.GetContent()
+		if err != nil {
+			return nil, "", errors.Wrap(err, fmt.Sprintf("decoding '%s'", path))
+		}
+
+		return parseCodeowners([]byte(content)), path, nil
+	}
+
+	return nil, "", nil
+}
+
+// codeownersRule is a line of a CODEOWNERS file, assigning owners to files matching a
+// pattern. Rules with no owners are valid, and mean the files have no owner.
+type codeownersRule struct {
+	Line    int
+	Pattern string
+	Owners  []string // as written, e.g. @user, @org/team or user@example.com
+	Users   []string // logins, without the @
+	Teams   []string // org/team, without the @
+	Emails  []string
+}
+
+// parseCodeowners parses the rules of a CODEOWNERS file, in the order they're written.
+// Later rules take precedence, as with .gitignore.
+// https://docs.github.com/en/repositories/managing-your-repositorys-settings-and-features/customizing-your-repository/about-code-owners#codeowners-syntax
+func parseCodeowners(data []byte) []codeownersRule {
+	rules := []codeownersRule{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		// Comments start with the first #, unless it's escaped as part of a pattern.
+		text := scanner.Text()
+		for idx := 0; idx < len(text); idx++ {
+			if text[idx] == '\\' {
+				idx++ // skip whatever is escaped
+				continue
+			}
+			if text[idx] == '#' {
+				text = text[:idx]
+				break
+			}
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		rule := codeownersRule{
+			Line:    line,
+			Pattern: fields[0],
+			Owners:  fields[1:],
+			Users:   []string{},
+			Teams:   []string{},
+			Emails:  []string{},
+		}
+		for _, owner := range rule.Owners {
+			switch login, ok := strings.CutPrefix(owner, "@"); {
+			case ok && strings.Contains(login, "/"):
+				rule.Teams = append(rule.Teams, login)
+			case ok:
+				rule.Users = append(rule.Users, login)
+			default:
+				rule.Emails = append(rule.Emails, owner)
+			}
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
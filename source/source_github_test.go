@@ -0,0 +1,544 @@
+package source_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/incident-io/catalog-importer/v2/source"
+	"github.com/jarcoal/httpmock"
+	"github.com/samber/lo"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SourceGitHub", func() {
+	var (
+		ctx    context.Context
+		logger kitlog.Logger
+		s      source.SourceGitHub
+		client *http.Client
+		mock   *httpmock.MockTransport
+	)
+
+	const api = "https://api.github.com"
+
+	repo := func(name string, attrs map[string]any) map[string]any {
+		return lo.Assign(map[string]any{
+			"name":           name,
+			"full_name":      "example-org/" + name,
+			"owner":          map[string]any{"login": "example-org"},
+			"default_branch": "main",
+			"visibility":     "private",
+			"pushed_at":      time.Now().Add(-24 * time.Hour).Format(time.RFC3339),
+		}, attrs)
+	}
+
+	// serveRepo responds to the tree and blob requests for a repo with a single
+	// catalog-info.yaml, whose content names the ref it was read from.
+	serveRepo := func(name, ref string) {
+		mock.RegisterResponder(http.MethodGet, api+"/repos/example-org/"+name+"/git/trees/"+ref,
+			httpmock.NewJsonResponderOrPanic(http.StatusOK, map[string]any{
+				"tree": []map[string]any{
+					{"path": "catalog-info.yaml", "type": "blob", "sha": name + "-" + ref},
+				},
+			}))
+		mock.RegisterResponder(http.MethodGet, api+"/repos/example-org/"+name+"/git/blobs/"+name+"-"+ref,
+			httpmock.NewJsonResponderOrPanic(http.StatusOK, map[string]any{
+				"encoding": "base64",
+				"content":  base64.StdEncoding.EncodeToString([]byte("name: " + name + "@" + ref)),
+			}))
+	}
+
+	BeforeEach(func() {
+		logger = kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stderr))
+
+		ctx = context.Background()
+		client = cleanhttp.DefaultClient()
+		mock = httpmock.NewMockTransport()
+		client.Transport = mock
+
+		s = source.SourceGitHub{
+			Repos:    []string{"example-org/*"},
+			Files:    []string{"**/catalog-info.yaml"},
+			Token:    "token",
+			CacheDir: GinkgoT().TempDir(),
+		}
+
+		mock.RegisterResponder(http.MethodGet, api+"/orgs/example-org/repos",
+			httpmock.NewJsonResponderOrPanic(http.StatusOK, []map[string]any{
+				repo("api", map[string]any{"topics": []string{"catalog", "go"}}),
+				repo("web", map[string]any{"topics": []string{"catalog"}, "visibility": "public"}),
+				repo("sandbox-tools", map[string]any{"topics": []string{"sandbox"}}),
+				repo("forked-lib", map[string]any{"fork": true}),
+				repo("abandoned", map[string]any{"pushed_at": time.Now().AddDate(-2, 0, 0).Format(time.RFC3339)}),
+			}))
+		mock.RegisterResponder(http.MethodGet, api+"/repos/example-org/api",
+			httpmock.NewJsonResponderOrPanic(http.StatusOK, repo("api", nil)))
+
+		for _, name := range []string{"api", "web", "sandbox-tools", "forked-lib", "abandoned"} {
+			serveRepo(name, "main")
+		}
+		serveRepo("api", "v1.2.0")
+	})
+
+	load := func() []string {
+		entries, err := s.Load(ctx, logger, client)
+		Expect(err).NotTo(HaveOccurred())
+
+		return lo.Map(entries, func(entry *source.SourceEntry, _ int) string {
+			return string(entry.Content)
+		})
+	}
+
+	It("loads from the default branch of every repo", func() {
+		Expect(load()).To(ConsistOf(
+			"name: api@main", "name: web@main", "name: sandbox-tools@main", "name: forked-lib@main", "name: abandoned@main",
+		))
+	})
+
+	It("reads the pinned ref, preferring the repo that was named explicitly", func() {
+		s.Repos = []string{"example-org/*", "example-org/api@v1.2.0"}
+		Expect(load()).To(ContainElement("name: api@v1.2.0"))
+		Expect(load()).NotTo(ContainElement("name: api@main"))
+	})
+
+	It("errors when a pinned ref doesn't exist, rather than treating the repo as empty", func() {
+		s.Repos = []string{"example-org/api@v9.9.9"}
+		mock.RegisterResponder(http.MethodGet, api+"/repos/example-org/api/git/trees/v9.9.9",
+			httpmock.NewJsonResponderOrPanic(http.StatusNotFound, map[string]any{"message": "Not Found"}))
+
+		_, err := s.Load(ctx, logger, client)
+		Expect(err).To(MatchError(ContainSubstring("at pinned ref v9.9.9")))
+	})
+
+	It("skips empty repos on the default branch", func() {
+		mock.RegisterResponder(http.MethodGet, api+"/repos/example-org/web/git/trees/main",
+			httpmock.NewJsonResponderOrPanic(http.StatusNotFound, map[string]any{"message": "Not Found"}))
+
+		Expect(load()).NotTo(ContainElement("name: web@main"))
+	})
+
+	It("describes where each file came from", func() {
+		s.Repos = []string{"example-org/api@v1.2.0"}
+		mock.RegisterResponder(http.MethodGet, api+"/repos/example-org/api",
+			httpmock.NewJsonResponderOrPanic(http.StatusOK, repo("api", map[string]any{
+				"html_url": "https://github.com/example-org/api",
+			})))
+
+		entries, err := s.Load(ctx, logger, client)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Meta()).To(Equal(map[string]any{
+			"source":   "",
+			"origin":   "github (repo=example-org/api path=catalog-info.yaml)",
+			"filename": "catalog-info.yaml",
+			"owner":    "example-org",
+			"repo":     "api",
+			"ref":      "v1.2.0",
+			"sha":      "api-v1.2.0",
+			"url":      "https://github.com/example-org/api/blob/v1.2.0/catalog-info.yaml",
+		}))
+	})
+
+	It("matches repo globs", func() {
+		s.Repos = []string{"example-org/sandbox-*"}
+		Expect(load()).To(ConsistOf("name: sandbox-tools@main"))
+	})
+
+	It("filters repos", func() {
+		s.ExcludeRepos = []string{"example-org/sandbox-*"}
+		s.ExcludeForks = true
+		s.PushedWithinDays = 90
+		Expect(load()).To(ConsistOf("name: api@main", "name: web@main"))
+	})
+
+	It("filters repos by topic and visibility", func() {
+		s.Topics = []string{"catalog"}
+		s.ExcludeTopics = []string{"go"}
+		Expect(load()).To(ConsistOf("name: web@main"))
+
+		s.ExcludeTopics = nil
+		s.Visibility = []string{"private"}
+		Expect(load()).To(ConsistOf("name: api@main"))
+	})
+
+	It("validates repos", func() {
+		s.Repos = []string{"example-org/api@main", "example-org"}
+		Expect(s.Validate()).To(MatchError(ContainSubstring("repos must be of the form owner/repo")))
+	})
+
+	Describe("with search discovery", func() {
+		var queries []string
+
+		BeforeEach(func() {
+			s.Discovery = source.GitHubDiscoverySearch
+			s.Repos = []string{"example-org/*", "example-org/api@v1.2.0"}
+			s.ExcludeForks = true
+
+			queries = nil
+			mock.RegisterResponder(http.MethodGet, api+"/search/code",
+				func(req *http.Request) (*http.Response, error) {
+					queries = append(queries, req.URL.Query().Get("q"))
+
+					item := func(name, path string) map[string]any {
+						return map[string]any{
+							"path": path, "sha": name + "-main",
+							"repository": map[string]any{"full_name": "example-org/" + name},
+						}
+					}
+
+					return httpmock.NewJsonResponse(http.StatusOK, map[string]any{
+						"total_count": 4,
+						"items": []map[string]any{
+							item("api", "catalog-info.yaml"),
+							item("web", "catalog-info.yaml"),
+							item("forked-lib", "catalog-info.yaml"),
+							item("web", "catalog-info.yaml.bak"),
+						},
+					})
+				})
+		})
+
+		It("finds files with code search, listing the tree only for pinned refs", func() {
+			Expect(load()).To(ConsistOf(
+				"name: api@v1.2.0", "name: web@main",
+			))
+			Expect(queries).To(ConsistOf("filename:catalog-info.yaml org:example-org"))
+			Expect(mock.GetCallCountInfo()).To(HaveKeyWithValue("GET "+api+"/repos/example-org/web/git/trees/main", 0))
+		})
+
+		It("searches explicitly named repos individually", func() {
+			s.Repos = []string{"example-org/web"}
+			mock.RegisterResponder(http.MethodGet, api+"/repos/example-org/web",
+				httpmock.NewJsonResponderOrPanic(http.StatusOK, repo("web", nil)))
+
+			Expect(load()).To(ConsistOf("name: web@main"))
+			Expect(queries).To(ConsistOf("filename:catalog-info.yaml repo:example-org/web"))
+		})
+
+		It("requires patterns with a fixed filename", func() {
+			s.Files = []string{"**/*.yaml"}
+			Expect(s.Validate()).To(MatchError(ContainSubstring("search discovery needs files patterns to end in a fixed filename")))
+		})
+	})
+
+	Describe("in metadata mode", func() {
+		var parsed func() map[string][]source.Entry
+
+		BeforeEach(func() {
+			s.Mode = source.GitHubModeMetadata
+			s.Files = nil
+			s.Topics = []string{"catalog"}
+
+			// parsed loads the entries, grouping them by kind.
+			parsed = func() map[string][]source.Entry {
+				entries, err := s.Load(ctx, logger, client)
+				Expect(err).NotTo(HaveOccurred())
+
+				byKind := map[string][]source.Entry{}
+				for _, entry := range entries {
+					parsedEntries, err := entry.Parse()
+					Expect(err).NotTo(HaveOccurred())
+					for _, parsedEntry := range parsedEntries {
+						kind := parsedEntry["kind"].(string)
+						byKind[kind] = append(byKind[kind], parsedEntry)
+					}
+				}
+
+				return byKind
+			}
+
+			mock.RegisterRegexpResponder(http.MethodGet, regexp.MustCompile(`/contents/`),
+				httpmock.NewStringResponder(http.StatusNotFound, `{"message": "Not Found"}`))
+			mock.RegisterResponder(http.MethodGet, api+"/repos/example-org/api/contents/CODEOWNERS",
+				httpmock.NewJsonResponderOrPanic(http.StatusOK, map[string]any{
+					"type":     "file",
+					"encoding": "base64",
+					"content": base64.StdEncoding.EncodeToString([]byte(
+						"# Platform owns everything by default\n" +
+							"* @example-org/platform\n" +
+							"\n" +
+							"/payments/ @example-org/payments @alice payments@example.com # money\n" +
+							"/vendor/\n" +
+							"/docs/\\#notes.md @bob # escaped\n",
+					)),
+				}))
+
+			mock.RegisterResponder(http.MethodGet, api+"/orgs/example-org/teams",
+				httpmock.NewJsonResponderOrPanic(http.StatusOK, []map[string]any{
+					{"slug": "platform", "name": "Platform"},
+					{"slug": "payments", "name": "Payments", "parent": map[string]any{"slug": "platform"}},
+				}))
+			mock.RegisterResponder(http.MethodGet, api+"/orgs/example-org/teams/platform/members",
+				httpmock.NewJsonResponderOrPanic(http.StatusOK, []map[string]any{{"login": "alice"}, {"login": "bob"}}))
+			mock.RegisterResponder(http.MethodGet, api+"/orgs/example-org/teams/payments/members",
+				httpmock.NewJsonResponderOrPanic(http.StatusOK, []map[string]any{{"login": "alice"}}))
+		})
+
+		It("emits an entry for each repository", func() {
+			repos := parsed()["repository"]
+			Expect(lo.Map(repos, func(entry source.Entry, _ int) any { return entry["full_name"] })).To(
+				ConsistOf("example-org/api", "example-org/web"))
+			Expect(repos[0]).To(HaveKeyWithValue("topics", ConsistOf("catalog", "go")))
+			Expect(repos[0]).To(HaveKeyWithValue("visibility", "private"))
+			Expect(repos[0]).To(HaveKeyWithValue("default_branch", "main"))
+			Expect(repos[0]).To(HaveKeyWithValue("archived", false))
+		})
+
+		It("emits an entry for each team, with its members and parent", func() {
+			teams := parsed()["team"]
+			Expect(teams).To(HaveLen(2))
+			Expect(teams[0]).To(HaveKeyWithValue("full_slug", "example-org/payments"))
+			Expect(teams[0]).To(HaveKeyWithValue("parent", "platform"))
+			Expect(teams[0]).To(HaveKeyWithValue("members", ConsistOf("alice")))
+			Expect(teams[1]).To(HaveKeyWithValue("full_slug", "example-org/platform"))
+			Expect(teams[1]).To(HaveKeyWithValue("parent", BeNil()))
+			Expect(teams[1]).To(HaveKeyWithValue("members", ConsistOf("alice", "bob")))
+		})
+
+		It("emits an entry for each CODEOWNERS rule", func() {
+			rules := parsed()["codeowners"]
+			Expect(rules).To(HaveLen(4))
+			Expect(rules[0]).To(HaveKeyWithValue("pattern", "*"))
+			Expect(rules[0]).To(HaveKeyWithValue("teams", ConsistOf("example-org/platform")))
+			Expect(rules[1]).To(HaveKeyWithValue("pattern", "/payments/"))
+			Expect(rules[1]).To(HaveKeyWithValue("teams", ConsistOf("example-org/payments")))
+			Expect(rules[1]).To(HaveKeyWithValue("users", ConsistOf("alice")))
+			Expect(rules[1]).To(HaveKeyWithValue("emails", ConsistOf("payments@example.com")))
+			Expect(rules[2]).To(HaveKeyWithValue("pattern", "/vendor/"))
+			Expect(rules[2]).To(HaveKeyWithValue("owners", BeEmpty()))
+			Expect(rules[2]["$meta"]).To(HaveKeyWithValue("url", HaveSuffix("/blob/main/CODEOWNERS#L5")))
+			Expect(rules[3]).To(HaveKeyWithValue("pattern", `/docs/\#notes.md`))
+			Expect(rules[3]).To(HaveKeyWithValue("owners", ConsistOf("@bob")))
+		})
+
+		It("skips teams for owners that aren't organizations", func() {
+			mock.RegisterResponder(http.MethodGet, api+"/orgs/example-org/teams",
+				httpmock.NewStringResponder(http.StatusNotFound, `{"message": "Not Found"}`))
+			Expect(parsed()).NotTo(HaveKey("team"))
+		})
+	})
+
+	Describe("caching", func() {
+		BeforeEach(func() {
+			s.Repos = []string{"example-org/api"}
+		})
+
+		It("makes conditional requests, replaying responses that haven't changed", func() {
+			var notModified int
+			mock.RegisterResponder(http.MethodGet, api+"/repos/example-org/api",
+				func(req *http.Request) (*http.Response, error) {
+					if req.Header.Get("If-None-Match") == `"v1"` {
+						notModified++
+						return httpmock.NewStringResponse(http.StatusNotModified, ""), nil
+					}
+
+					resp, err := httpmock.NewJsonResponse(http.StatusOK, repo("api", nil))
+					resp.Header.Set("ETag", `"v1"`)
+
+					return resp, err
+				})
+
+			Expect(load()).To(ConsistOf("name: api@main"))
+			Expect(load()).To(ConsistOf("name: api@main"))
+			Expect(notModified).To(Equal(1))
+		})
+
+		It("only fetches each blob once", func() {
+			Expect(load()).To(ConsistOf("name: api@main"))
+			Expect(load()).To(ConsistOf("name: api@main"))
+			Expect(mock.GetCallCountInfo()).To(HaveKeyWithValue("GET "+api+"/repos/example-org/api/git/blobs/api-main", 1))
+		})
+
+		It("doesn't replay responses fetched with a different token", func() {
+			var conditional int
+			mock.RegisterResponder(http.MethodGet, api+"/repos/example-org/api",
+				func(req *http.Request) (*http.Response, error) {
+					if req.Header.Get("If-None-Match") != "" {
+						conditional++
+					}
+
+					resp, err := httpmock.NewJsonResponse(http.StatusOK, repo("api", nil))
+					resp.Header.Set("ETag", `"v1"`)
+
+					return resp, err
+				})
+
+			Expect(load()).To(ConsistOf("name: api@main"))
+			s.Token = "other-token"
+			Expect(load()).To(ConsistOf("name: api@main"))
+			Expect(conditional).To(Equal(0))
+		})
+
+		It("carries on without the cache if its directory can't be created", func() {
+			s.CacheDir = filepath.Join(GinkgoT().TempDir(), "file")
+			Expect(os.WriteFile(s.CacheDir, nil, 0o600)).To(Succeed())
+			Expect(load()).To(ConsistOf("name: api@main"))
+		})
+
+		It("fetches blobs every time when disabled", func() {
+			s.DisableCache = true
+			Expect(load()).To(ConsistOf("name: api@main"))
+			Expect(load()).To(ConsistOf("name: api@main"))
+			Expect(mock.GetCallCountInfo()).To(HaveKeyWithValue("GET "+api+"/repos/example-org/api/git/blobs/api-main", 2))
+		})
+	})
+
+	Describe("rate limits", func() {
+		BeforeEach(func() {
+			s.Repos = []string{"example-org/api"}
+		})
+
+		It("waits and retries when rate limited", func() {
+			responses := []*http.Response{
+				httpmock.NewStringResponse(http.StatusForbidden, `{"message": "You have exceeded a secondary rate limit."}`),
+				httpmock.NewStringResponse(http.StatusForbidden, `{"message": "API rate limit exceeded"}`),
+			}
+			responses[0].Header.Set("Retry-After", "0")
+			responses[1].Header.Set("X-RateLimit-Remaining", "0")
+			responses[1].Header.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+
+			mock.RegisterResponder(http.MethodGet, api+"/repos/example-org/api",
+				func(req *http.Request) (*http.Response, error) {
+					if len(responses) > 0 {
+						resp := responses[0]
+						responses = responses[1:]
+						return resp, nil
+					}
+
+					return httpmock.NewJsonResponse(http.StatusOK, repo("api", nil))
+				})
+
+			Expect(load()).To(ConsistOf("name: api@main"))
+			Expect(responses).To(BeEmpty())
+		})
+
+		It("waits for the rate limit to reset once a response has used it up", func() {
+			reset := time.Now().Add(time.Second).Truncate(time.Second)
+
+			resp, err := httpmock.NewJsonResponse(http.StatusOK, repo("api", nil))
+			Expect(err).NotTo(HaveOccurred())
+			resp.Header.Set("X-RateLimit-Limit", "5000")
+			resp.Header.Set("X-RateLimit-Remaining", "0")
+			resp.Header.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+			mock.RegisterResponder(http.MethodGet, api+"/repos/example-org/api", httpmock.ResponderFromResponse(resp))
+
+			var treeRequestedAt time.Time
+			mock.RegisterResponder(http.MethodGet, api+"/repos/example-org/api/git/trees/main",
+				func(req *http.Request) (*http.Response, error) {
+					treeRequestedAt = time.Now()
+					return httpmock.NewJsonResponse(http.StatusOK, map[string]any{
+						"tree": []map[string]any{
+							{"path": "catalog-info.yaml", "type": "blob", "sha": "api-main"},
+						},
+					})
+				})
+
+			Expect(load()).To(ConsistOf("name: api@main"))
+			Expect(treeRequestedAt).To(BeTemporally(">", reset))
+		})
+
+		It("fails on other forbidden responses", func() {
+			mock.RegisterResponder(http.MethodGet, api+"/repos/example-org/api",
+				httpmock.NewStringResponder(http.StatusForbidden, `{"message": "Resource not accessible by integration"}`))
+
+			_, err := s.Load(ctx, logger, client)
+			Expect(err).To(MatchError(ContainSubstring("Resource not accessible by integration")))
+			Expect(mock.GetCallCountInfo()).To(HaveKeyWithValue("GET "+api+"/repos/example-org/api", 1))
+		})
+	})
+
+	Describe("as a GitHub App on GitHub Enterprise Server", func() {
+		const ghes = "https://github.example.com/api/v3"
+
+		var (
+			key         *rsa.PrivateKey
+			tokenExpiry time.Duration
+			tokenCount  int
+		)
+
+		BeforeEach(func() {
+			var err error
+			key, err = rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).NotTo(HaveOccurred())
+
+			s = source.SourceGitHub{
+				Repos:    []string{"example-org/api"},
+				Files:    []string{"**/catalog-info.yaml"},
+				BaseURL:  ghes + "/",
+				CacheDir: GinkgoT().TempDir(),
+				App: &source.SourceGitHubApp{
+					AppID:          "123",
+					InstallationID: "42",
+					PrivateKey: source.Credential(pem.EncodeToMemory(&pem.Block{
+						Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key),
+					})),
+				},
+			}
+
+			tokenExpiry, tokenCount = time.Hour, 0
+			mock.RegisterResponder(http.MethodPost, ghes+"/app/installations/42/access_tokens",
+				func(req *http.Request) (*http.Response, error) {
+					tokenCount++
+
+					// The app authenticates with a JWT signed by its private key.
+					claims := jwt.RegisteredClaims{}
+					_, err := jwt.ParseWithClaims(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "), &claims,
+						func(*jwt.Token) (any, error) { return &key.PublicKey, nil })
+					Expect(err).NotTo(HaveOccurred())
+					Expect(claims.Issuer).To(Equal("123"))
+
+					return httpmock.NewJsonResponse(http.StatusCreated, map[string]any{
+						"token":      "ghs_installation",
+						"expires_at": time.Now().Add(tokenExpiry).Format(time.RFC3339),
+					})
+				})
+
+			// Every other request must use the installation token.
+			responses := map[string]any{
+				"/repos/example-org/api":                repo("api", nil),
+				"/repos/example-org/api/git/trees/main": map[string]any{"tree": []map[string]any{{"path": "catalog-info.yaml", "type": "blob", "sha": "api-main"}}},
+				"/repos/example-org/api/git/blobs/api-main": map[string]any{
+					"encoding": "base64", "content": base64.StdEncoding.EncodeToString([]byte("name: api")),
+				},
+			}
+			for path, body := range responses {
+				mock.RegisterResponder(http.MethodGet, ghes+path, func(req *http.Request) (*http.Response, error) {
+					Expect(req.Header.Get("Authorization")).To(Equal("Bearer ghs_installation"))
+					return httpmock.NewJsonResponse(http.StatusOK, body)
+				})
+			}
+		})
+
+		It("loads files using a single installation token", func() {
+			Expect(load()).To(ConsistOf("name: api"))
+			Expect(tokenCount).To(Equal(1))
+		})
+
+		It("refreshes the installation token when it expires", func() {
+			tokenExpiry = time.Second // within oauth2's expiry margin, so always refreshed
+			Expect(load()).To(ConsistOf("name: api"))
+			Expect(tokenCount).To(Equal(3))
+		})
+
+		It("doesn't allow both a token and an app", func() {
+			s.Token = "token"
+			Expect(s.Validate()).To(MatchError(ContainSubstring("must provide either a token or app, not both")))
+		})
+	})
+})
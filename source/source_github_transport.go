@@ -0,0 +1,318 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+// githubCache keeps GitHub responses on disk between runs, so we can make conditional
+// requests that don't count against the rate limit, and never fetch the same blob twice.
+// A nil cache caches nothing.
+//
+// Responses are keyed by the credential as well as the URL, so one credential is never
+// given a response that was fetched with another. Blobs are shared, as they can only be
+// found through a tree the credential could already read.
+type githubCache struct {
+	dir        string
+	credential string // hash of whatever identifies the credential
+}
+
+func newGitHubCache(dir, credential string) (*githubCache, error) {
+	if dir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, errors.Wrap(err, "finding cache directory, set cache_dir instead")
+		}
+		dir = filepath.Join(userCacheDir, "catalog-importer", "github")
+	}
+
+	for _, subdir := range []string{"blobs", "responses"} {
+		if err := os.MkdirAll(filepath.Join(dir, subdir), 0o700); err != nil {
+			return nil, errors.Wrap(err, "creating GitHub cache directory")
+		}
+	}
+
+	credentialHash := sha256.Sum256([]byte(credential))
+
+	return &githubCache{dir: dir, credential: hex.EncodeToString(credentialHash[:])}, nil
+}
+
+// Blob returns the content of a blob we've already fetched. Blobs are addressed by the
+// SHA of their content, so never change.
+func (c *githubCache) Blob(sha string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(filepath.Join(c.dir, "blobs", filepath.Base(sha)))
+	if err != nil {
+		return nil, false
+	}
+
+	return data, true
+}
+
+func (c *githubCache) SaveBlob(sha string, data []byte) error {
+	if c == nil {
+		return nil
+	}
+
+	return writeFileAtomic(filepath.Join(c.dir, "blobs", filepath.Base(sha)), data)
+}
+
+// cachedResponse is a response we can replay when GitHub tells us it hasn't changed.
+type cachedResponse struct {
+	ETag   string      `json:"etag"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// Transport makes GET requests conditional on the ETag of the last response we saw for
+// the same URL, replaying that response if GitHub says it's not modified.
+func (c *githubCache) Transport(base http.RoundTripper) http.RoundTripper {
+	if c == nil {
+		return base
+	}
+
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method != http.MethodGet {
+			return base.RoundTrip(req)
+		}
+
+		key := sha256.Sum256([]byte(c.credential + " " + req.URL.String()))
+		filename := filepath.Join(c.dir, "responses", hex.EncodeToString(key[:]))
+
+		var cached *cachedResponse
+		if data, err := os.ReadFile(filename); err == nil {
+			cached = &cachedResponse{}
+			if err := json.Unmarshal(data, cached); err != nil {
+				cached = nil
+			}
+		}
+
+		if cached != nil {
+			req = req.Clone(req.Context())
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+
+		resp, err := base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusNotModified && cached != nil {
+			resp.Body.Close()
+
+			header := cached.Header.Clone()
+			// Keep the rate limit headers from the real response, as they're current.
+			for name, values := range resp.Header {
+				header[name] = values
+			}
+
+			return &http.Response{
+				Status:        "200 OK",
+				StatusCode:    http.StatusOK,
+				Proto:         resp.Proto,
+				ProtoMajor:    resp.ProtoMajor,
+				ProtoMinor:    resp.ProtoMinor,
+				Header:        header,
+				Body:          io.NopCloser(bytes.NewReader(cached.Body)),
+				ContentLength: int64(len(cached.Body)),
+				Request:       resp.Request,
+			}, nil
+		}
+
+		etag := resp.Header.Get("ETag")
+		if resp.StatusCode != http.StatusOK || etag == "" {
+			return resp, nil
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		data, err := json.Marshal(cachedResponse{ETag: etag, Header: resp.Header, Body: body})
+		if err == nil {
+			err = writeFileAtomic(filename, data)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "caching GitHub response")
+		}
+
+		return resp, nil
+	})
+}
+
+// maxRateLimitRetries is how many times we'll wait for a rate limit before giving up.
+const maxRateLimitRetries = 5
+
+// githubRateLimitTransport waits and retries when GitHub tells us we've hit either its
+// primary rate limit (requests per hour) or a secondary one (e.g. too many concurrent
+// requests).
+//
+// When a successful response uses up the last of the primary rate limit, we wait for it
+// to reset before returning it. Otherwise the GitHub client, which tracks the limit
+// itself, would fail the next request without ever sending it.
+// https://docs.github.com/en/rest/using-the-rest-api/rate-limits-for-the-rest-api
+func githubRateLimitTransport(logger kitlog.Logger, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		for attempt := 0; ; attempt++ {
+			resp, err := base.RoundTrip(req)
+			if err != nil {
+				return nil, err
+			}
+
+			wait, limited := githubRateLimitWait(resp, attempt, time.Now())
+			if !limited && resp.StatusCode < http.StatusBadRequest {
+				if wait, exhausted := githubRateLimitReset(resp, time.Now()); exhausted {
+					logger.Log("msg", "used up GitHub rate limit, waiting for it to reset",
+						"url", req.URL.String(), "wait", wait.String())
+					if err := sleepContext(req.Context(), wait); err != nil {
+						resp.Body.Close()
+						return nil, err
+					}
+				}
+			}
+			if !limited || attempt >= maxRateLimitRetries {
+				return resp, nil
+			}
+
+			// We can only replay requests whose body we can rewind.
+			if req.Body != nil && req.GetBody == nil {
+				return resp, nil
+			}
+
+			resp.Body.Close()
+			logger.Log("msg", "hit GitHub rate limit, waiting before retrying",
+				"url", req.URL.String(), "wait", wait.String(), "attempt", attempt+1)
+
+			if err := sleepContext(req.Context(), wait); err != nil {
+				return nil, err
+			}
+
+			if req.GetBody != nil {
+				req = req.Clone(req.Context())
+				req.Body, err = req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	})
+}
+
+// githubRateLimitWait returns how long to wait before retrying a response, if it was
+// rate limited.
+func githubRateLimitWait(resp *http.Response, attempt int, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	// Secondary rate limits tell us how long to wait.
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		seconds, err := strconv.Atoi(retryAfter)
+		if err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+
+	// The primary rate limit tells us when it resets.
+	if wait, exhausted := githubRateLimitReset(resp, now); exhausted {
+		return wait, true
+	}
+
+	// Otherwise, a 429 is always a rate limit, as is a 403 that says it's a secondary
+	// rate limit. GitHub recommends waiting at least a minute, backing off exponentially
+	// if we keep hitting it. Any other 403 is a permissions problem.
+	if resp.StatusCode == http.StatusTooManyRequests || isSecondaryRateLimit(resp) {
+		return time.Minute << attempt, true
+	}
+
+	return 0, false
+}
+
+// githubRateLimitReset returns how long until the primary rate limit resets, if the
+// response says we've used it up.
+func githubRateLimitReset(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return 0, false
+	}
+
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return max(time.Unix(reset, 0).Sub(now), 0) + time.Second, true
+}
+
+// isSecondaryRateLimit checks the response body for GitHub's secondary rate limit
+// message, leaving the body to be read again.
+func isSecondaryRateLimit(resp *http.Response) bool {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+
+	return bytes.Contains(bytes.ToLower(body), []byte("secondary rate limit"))
+}
+
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// writeFileAtomic writes via a temporary file, so concurrent readers never see a partial
+// file.
+func writeFileAtomic(filename string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), fmt.Sprintf(".%s-*", filepath.Base(filename)))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
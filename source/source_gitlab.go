@@ -0,0 +1,352 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bmatcuk/doublestar/v4"
+	kitlog "github.com/go-kit/kit/log"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+type SourceGitLab struct {
+	BaseURL  string     `json:"base_url,omitempty"` // defaults to https://gitlab.com
+	Token    Credential `json:"token"`
+	Projects []string   `json:"projects"`
+	Files    []string   `json:"files"`
+	// ExcludeArchived, if true, will cause the source to exclude files from
+	// projects that have been archived. Defaults to false (archived projects are included).
+	ExcludeArchived bool `json:"exclude_archived,omitempty"`
+}
+
+func (s SourceGitLab) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.BaseURL, is.URL),
+		validation.Field(&s.Projects, validation.Each(
+			validation.Match(regexp.MustCompile("^[^/]+/.+$")).
+				Error("projects must be of the form group/project, or group/* for matching all projects under that group and its subgroups"),
+		)),
+	)
+}
+
+func (s SourceGitLab) String() string {
+	return fmt.Sprintf("gitlab (projects=%s files=%s)", s.Projects, s.Files)
+}
+
+// gitlabProject is the subset of the GitLab project API we care about.
+// https://docs.gitlab.com/ee/api/projects.html
+type gitlabProject struct {
+	ID                int    `json:"id"`
+	PathWithNamespace string `json:"path_with_namespace"` // e.g. platform/payments/api
+	DefaultBranch     string `json:"default_branch"`      // empty if the project has no commits
+	Archived          bool   `json:"archived"`
+	WebURL            string `json:"web_url"` // e.g. https://gitlab.com/platform/payments/api
+}
+
+type gitlabTreeEntry struct {
+	ID   string `json:"id"`   // SHA of the blob
+	Type string `json:"type"` // blob or tree
+	Path string `json:"path"`
+}
+
+func (s SourceGitLab) Load(ctx context.Context, logger kitlog.Logger, client *http.Client) ([]*SourceEntry, error) {
+	type Target struct {
+		Project *gitlabProject
+		Matches []gitlabTreeEntry
+	}
+
+	// Use this whenever we're modifying structures that are race unsafe.
+	var mu sync.Mutex
+	synchronise := func(do func()) {
+		defer mu.Unlock()
+		mu.Lock()
+
+		do()
+	}
+
+	// Expand any group wildcards so we have a full list of projects to scan. A project can
+	// be matched by more than one pattern, so we dedupe them by ID.
+	targets := []*Target{}
+	seen := map[int]bool{}
+	addTarget := func(logger kitlog.Logger, project *gitlabProject) {
+		synchronise(func() {
+			if seen[project.ID] {
+				return
+			}
+			seen[project.ID] = true
+
+			if project.Archived && s.ExcludeArchived {
+				logger.Log("msg", "skipping archived project, exclude_archived is true",
+					"project", project.PathWithNamespace)
+				return
+			}
+			if project.DefaultBranch == "" {
+				logger.Log("msg", "GitLab project has no default branch, so is probably empty, skipping",
+					"project", project.PathWithNamespace)
+				return
+			}
+
+			logger.Log("msg", "found GitLab project",
+				"project", project.PathWithNamespace, "ref", project.DefaultBranch)
+			targets = append(targets, &Target{Project: project})
+		})
+	}
+
+	{
+		g, ctx := errgroup.WithContext(ctx)
+		g.SetLimit(10)
+
+		for _, providedProject := range s.Projects {
+			g.Go(func() error {
+				// We've found a group/* pattern, so we must find all the projects under this group,
+				// including those in its subgroups.
+				if group, ok := strings.CutSuffix(providedProject, "/*"); ok {
+					logger.Log("msg", "found project wildcard, resolving project list", "group", group)
+					query := url.Values{}
+					query.Set("include_subgroups", "true")
+					if s.ExcludeArchived {
+						query.Set("archived", "false")
+					}
+
+					path := fmt.Sprintf("groups/%s/projects", url.PathEscape(group))
+					err := s.paginate(ctx, client, path, query, func(data []byte) (int, error) {
+						page := []*gitlabProject{}
+						if err := json.Unmarshal(data, &page); err != nil {
+							return 0, err
+						}
+						for _, project := range page {
+							addTarget(logger, project)
+						}
+
+						return len(page), nil
+					})
+					if err != nil {
+						return errors.Wrap(err, fmt.Sprintf("listing GitLab projects for group '%s'", group))
+					}
+				} else {
+					// The project is specified, so we just need to resolve it so we can find the
+					// default branch.
+					project := &gitlabProject{}
+					if err := s.get(ctx, client, fmt.Sprintf("projects/%s", url.PathEscape(providedProject)), nil, project); err != nil {
+						return errors.Wrap(err, fmt.Sprintf("accessing '%s'", providedProject))
+					}
+
+					addTarget(logger, project)
+				}
+
+				return nil
+			})
+		}
+
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+	}
+
+	{
+		g, ctx := errgroup.WithContext(ctx)
+		g.SetLimit(10)
+
+		for idx := range targets {
+			target := targets[idx]
+
+			g.Go(func() error {
+				logger.Log("msg", "listing GitLab tree",
+					"project", target.Project.PathWithNamespace, "ref", target.Project.DefaultBranch)
+
+				query := url.Values{}
+				query.Set("recursive", "true")
+				query.Set("ref", target.Project.DefaultBranch)
+
+				tree := []gitlabTreeEntry{}
+				path := fmt.Sprintf("projects/%d/repository/tree", target.Project.ID)
+				err := s.paginate(ctx, client, path, query, func(data []byte) (int, error) {
+					page := []gitlabTreeEntry{}
+					if err := json.Unmarshal(data, &page); err != nil {
+						return 0, err
+					}
+					tree = append(tree, page...)
+
+					return len(page), nil
+				})
+				if err != nil {
+					return errors.Wrap(err, fmt.Sprintf("getting tree for '%s' at ref %s",
+						target.Project.PathWithNamespace, target.Project.DefaultBranch))
+				}
+
+				for _, pattern := range s.Files {
+					for _, treeEntry := range tree {
+						if treeEntry.Type != "blob" {
+							continue // we're only interested in files
+						}
+
+						match, err := doublestar.Match(pattern, treeEntry.Path)
+						if err != nil {
+							return errors.Wrap(err, "matching file pattern")
+						}
+
+						if match {
+							synchronise(func() {
+								target.Matches = append(target.Matches, treeEntry)
+							})
+						}
+					}
+				}
+
+				return nil
+			})
+		}
+
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+	}
+
+	entries := []*SourceEntry{}
+	{
+		g, ctx := errgroup.WithContext(ctx)
+		g.SetLimit(10)
+
+		for idx := range targets {
+			target := targets[idx]
+
+			for jdx := range target.Matches {
+				match := target.Matches[jdx]
+
+				g.Go(func() error {
+					data, err := s.getRaw(ctx, client, fmt.Sprintf("projects/%d/repository/blobs/%s/raw", target.Project.ID, match.ID))
+					if err != nil {
+						return errors.Wrap(err,
+							fmt.Sprintf("getting blob for '%s' from project '%s' at SHA %s", match.Path, target.Project.PathWithNamespace, match.ID))
+					}
+
+					synchronise(func() {
+						logger.Log("msg", "found matching GitLab file",
+							"project", target.Project.PathWithNamespace, "ref", target.Project.DefaultBranch, "path", match.Path)
+						entries = append(entries, &SourceEntry{
+							Origin:   fmt.Sprintf("gitlab (project=%s path=%s)", target.Project.PathWithNamespace, match.Path),
+							Filename: match.Path,
+							Content:  data,
+							Metadata: map[string]any{
+								"project": target.Project.PathWithNamespace,
+								"ref":     target.Project.DefaultBranch,
+								"sha":     match.ID,
+								"url":     fmt.Sprintf("%s/-/blob/%s/%s", target.Project.WebURL, target.Project.DefaultBranch, match.Path),
+							},
+						})
+					})
+
+					return nil
+				})
+			}
+		}
+
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
+
+// paginate requests each page of a GitLab list endpoint in turn, until GitLab tells us
+// there are no more.
+// https://docs.gitlab.com/ee/api/rest/index.html#pagination
+func (s SourceGitLab) paginate(ctx context.Context, client *http.Client, path string, query url.Values, handle func(data []byte) (int, error)) error {
+	query.Set("per_page", "100")
+	for page := 1; ; {
+		query.Set("page", strconv.Itoa(page))
+
+		resp, err := s.do(ctx, client, path, query)
+		if err != nil {
+			return err
+		}
+
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return errors.Wrap(err, "reading GitLab response")
+		}
+
+		count, err := handle(data)
+		if err != nil {
+			return errors.Wrap(err, "parsing GitLab response")
+		}
+
+		// GitLab sets X-Next-Page to empty on the last page.
+		next := resp.Header.Get("X-Next-Page")
+		if next == "" || count == 0 {
+			return nil
+		}
+		page, err = strconv.Atoi(next)
+		if err != nil {
+			return errors.Wrap(err, "parsing X-Next-Page header")
+		}
+	}
+}
+
+func (s SourceGitLab) get(ctx context.Context, client *http.Client, path string, query url.Values, result any) error {
+	resp, err := s.do(ctx, client, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return errors.Wrap(err, "parsing GitLab response")
+	}
+
+	return nil
+}
+
+func (s SourceGitLab) getRaw(ctx context.Context, client *http.Client, path string) ([]byte, error) {
+	resp, err := s.do(ctx, client, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+func (s SourceGitLab) do(ctx context.Context, client *http.Client, path string, query url.Values) (*http.Response, error) {
+	baseURL := s.BaseURL
+	if baseURL == "" {
+		baseURL = "https://gitlab.com"
+	}
+
+	endpoint := fmt.Sprintf("%s/api/v4/%s", strings.TrimSuffix(baseURL, "/"), path)
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "building GitLab URL")
+	}
+	if s.Token != "" {
+		req.Header.Set("PRIVATE-TOKEN", string(s.Token))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "requesting GitLab API")
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("received error from GitLab: %s", resp.Status)
+	}
+
+	return resp, nil
+}
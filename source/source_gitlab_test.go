@@ -0,0 +1,141 @@
+package source_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/incident-io/catalog-importer/v2/source"
+	"github.com/jarcoal/httpmock"
+	"github.com/samber/lo"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SourceGitLab", func() {
+	var (
+		ctx    context.Context
+		logger kitlog.Logger
+		s      source.SourceGitLab
+		client *http.Client
+		mock   *httpmock.MockTransport
+	)
+
+	const baseURL = "https://gitlab.example.com/api/v4"
+
+	// respond returns a JSON response, with the X-Next-Page header set to next.
+	respond := func(body any, next string) httpmock.Responder {
+		return func(req *http.Request) (*http.Response, error) {
+			Expect(req.Header.Get("PRIVATE-TOKEN")).To(Equal("token"))
+
+			resp, err := httpmock.NewJsonResponse(http.StatusOK, body)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Header.Set("X-Next-Page", next)
+
+			return resp, nil
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		logger = kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stderr))
+
+		s = source.SourceGitLab{
+			BaseURL:  "https://gitlab.example.com",
+			Token:    "token",
+			Projects: []string{"platform/*", "platform/payments/api"},
+			Files:    []string{"**/catalog-info.yaml"},
+		}
+
+		client = cleanhttp.DefaultClient()
+		mock = httpmock.NewMockTransport()
+		client.Transport = mock
+
+		// Two pages of projects in the platform group, including its subgroups.
+		mock.RegisterResponderWithQuery(http.MethodGet, baseURL+"/groups/platform/projects",
+			"include_subgroups=true&per_page=100&page=1",
+			respond([]map[string]any{
+				{"id": 1, "path_with_namespace": "platform/payments/api", "default_branch": "main"},
+				{"id": 2, "path_with_namespace": "platform/legacy", "default_branch": "master", "archived": true},
+			}, "2"))
+		mock.RegisterResponderWithQuery(http.MethodGet, baseURL+"/groups/platform/projects",
+			"include_subgroups=true&per_page=100&page=2",
+			respond([]map[string]any{
+				{"id": 3, "path_with_namespace": "platform/empty"},
+			}, ""))
+		mock.RegisterResponder(http.MethodGet, baseURL+"/projects/platform%2Fpayments%2Fapi",
+			respond(map[string]any{"id": 1, "path_with_namespace": "platform/payments/api", "default_branch": "main"}, ""))
+
+		mock.RegisterResponderWithQuery(http.MethodGet, baseURL+"/projects/1/repository/tree",
+			"recursive=true&ref=main&per_page=100&page=1",
+			respond([]map[string]any{
+				{"id": "sha-1", "type": "blob", "path": "catalog-info.yaml"},
+				{"id": "sha-2", "type": "tree", "path": "docs"},
+				{"id": "sha-3", "type": "blob", "path": "docs/README.md"},
+			}, ""))
+		mock.RegisterResponderWithQuery(http.MethodGet, baseURL+"/projects/2/repository/tree",
+			"recursive=true&ref=master&per_page=100&page=1",
+			respond([]map[string]any{
+				{"id": "sha-4", "type": "blob", "path": "services/legacy/catalog-info.yaml"},
+			}, ""))
+
+		mock.RegisterResponder(http.MethodGet, baseURL+"/projects/1/repository/blobs/sha-1/raw",
+			httpmock.NewStringResponder(http.StatusOK, "name: api"))
+		mock.RegisterResponder(http.MethodGet, baseURL+"/projects/2/repository/blobs/sha-4/raw",
+			httpmock.NewStringResponder(http.StatusOK, "name: legacy"))
+	})
+
+	origins := func(entries []*source.SourceEntry) []string {
+		return lo.Map(entries, func(entry *source.SourceEntry, _ int) string {
+			return entry.Origin
+		})
+	}
+
+	It("loads matching files from every project, skipping empty ones", func() {
+		entries, err := s.Load(ctx, logger, client)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(origins(entries)).To(ConsistOf(
+			"gitlab (project=platform/payments/api path=catalog-info.yaml)",
+			"gitlab (project=platform/legacy path=services/legacy/catalog-info.yaml)",
+		))
+
+		entry, _ := lo.Find(entries, func(entry *source.SourceEntry) bool {
+			return entry.Filename == "catalog-info.yaml"
+		})
+		Expect(string(entry.Content)).To(Equal("name: api"))
+	})
+
+	When("excluding archived projects", func() {
+		BeforeEach(func() {
+			s.ExcludeArchived = true
+
+			// GitLab filters archived projects for us, but we check too.
+			mock.RegisterResponderWithQuery(http.MethodGet, baseURL+"/groups/platform/projects",
+				"include_subgroups=true&archived=false&per_page=100&page=1",
+				respond([]map[string]any{
+					{"id": 1, "path_with_namespace": "platform/payments/api", "default_branch": "main"},
+					{"id": 2, "path_with_namespace": "platform/legacy", "default_branch": "master", "archived": true},
+				}, ""))
+		})
+
+		It("skips them", func() {
+			entries, err := s.Load(ctx, logger, client)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(origins(entries)).To(ConsistOf(
+				"gitlab (project=platform/payments/api path=catalog-info.yaml)",
+			))
+		})
+	})
+
+	It("errors if GitLab does", func() {
+		s.Projects = []string{"platform/missing"}
+		mock.RegisterResponder(http.MethodGet, baseURL+"/projects/platform%2Fmissing",
+			httpmock.NewStringResponder(http.StatusNotFound, `{"message":"404 Project Not Found"}`))
+
+		_, err := s.Load(ctx, logger, client)
+		Expect(err).To(MatchError(ContainSubstring("accessing 'platform/missing': received error from GitLab: 404")))
+	})
+})
@@ -0,0 +1,254 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	kitlog "github.com/go-kit/kit/log"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"golang.org/x/sync/errgroup"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+type SourceKubernetes struct {
+	// Kubeconfig is the path to a kubeconfig file, defaulting to $KUBECONFIG or
+	// ~/.kube/config. If neither exist, we use the in-cluster config.
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	// Context is the kubeconfig context to use, defaulting to the current context.
+	Context string `json:"context,omitempty"`
+	// InCluster, if true, always uses the service account of the pod we're running in.
+	InCluster bool                       `json:"in_cluster,omitempty"`
+	Resources []SourceKubernetesResource `json:"resources"`
+}
+
+// SourceKubernetesResource is a kind of object to list, which is either one of the
+// built-in kinds by name, or any resource, such as a custom resource, by group, version
+// and resource.
+type SourceKubernetesResource struct {
+	Kind     string `json:"kind,omitempty"`     // e.g. Deployment or deployments
+	Group    string `json:"group,omitempty"`    // e.g. example.com, empty for the core group
+	Version  string `json:"version,omitempty"`  // e.g. v1
+	Resource string `json:"resource,omitempty"` // plural, e.g. widgets
+	// Namespaces to list from, defaulting to all of them. Must be empty for resources
+	// that aren't namespaced.
+	Namespaces    []string `json:"namespaces,omitempty"`
+	LabelSelector string   `json:"label_selector,omitempty"` // e.g. app.kubernetes.io/part-of=payments
+}
+
+// kubernetesKinds are the built-in kinds you can refer to by name, rather than by group,
+// version and resource.
+var kubernetesKinds = map[string]schema.GroupVersionResource{
+	"Namespace":   {Version: "v1", Resource: "namespaces"},
+	"Service":     {Version: "v1", Resource: "services"},
+	"Pod":         {Version: "v1", Resource: "pods"},
+	"ConfigMap":   {Version: "v1", Resource: "configmaps"},
+	"Deployment":  {Group: "apps", Version: "v1", Resource: "deployments"},
+	"StatefulSet": {Group: "apps", Version: "v1", Resource: "statefulsets"},
+	"DaemonSet":   {Group: "apps", Version: "v1", Resource: "daemonsets"},
+	"Job":         {Group: "batch", Version: "v1", Resource: "jobs"},
+	"CronJob":     {Group: "batch", Version: "v1", Resource: "cronjobs"},
+	"Ingress":     {Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"},
+}
+
+func (s SourceKubernetes) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Kubeconfig, validation.When(s.InCluster, validation.Empty.
+			Error("can't set a kubeconfig when using the in-cluster config"))),
+		validation.Field(&s.Context, validation.When(s.InCluster, validation.Empty.
+			Error("can't set a context when using the in-cluster config"))),
+		validation.Field(&s.Resources, validation.Length(1, 0).
+			Error("must provide at least one resource when using kubernetes source")),
+	)
+}
+
+func (r SourceKubernetesResource) Validate() error {
+	return validation.Validate(r, validation.By(func(any) error {
+		gvr, err := r.GroupVersionResource()
+		if err != nil {
+			return err
+		}
+		if gvr == kubernetesKinds["Namespace"] && len(r.Namespaces) > 0 {
+			return errors.New("namespaces aren't namespaced, so can't set namespaces")
+		}
+		if _, err := labels.Parse(r.LabelSelector); err != nil {
+			return errors.Wrap(err, "invalid label_selector")
+		}
+
+		return nil
+	}))
+}
+
+// GroupVersionResource returns the resource to list, looking up the kind if given.
+func (r SourceKubernetesResource) GroupVersionResource() (schema.GroupVersionResource, error) {
+	if r.Kind == "" {
+		if r.Version == "" || r.Resource == "" {
+			return schema.GroupVersionResource{}, errors.New("must provide either a kind, or a version and resource")
+		}
+
+		return schema.GroupVersionResource{Group: r.Group, Version: r.Version, Resource: r.Resource}, nil
+	}
+	if r.Group != "" || r.Version != "" || r.Resource != "" {
+		return schema.GroupVersionResource{}, errors.New("must provide either a kind, or a group, version and resource, not both")
+	}
+
+	for kind, gvr := range kubernetesKinds {
+		if strings.EqualFold(r.Kind, kind) || strings.EqualFold(r.Kind, gvr.Resource) {
+			return gvr, nil
+		}
+	}
+
+	return schema.GroupVersionResource{}, fmt.Errorf("unsupported kind '%s', use group, version and resource instead", r.Kind)
+}
+
+func (s SourceKubernetes) String() string {
+	resources := lo.Map(s.Resources, func(resource SourceKubernetesResource, _ int) string {
+		gvr, _ := resource.GroupVersionResource()
+		return gvr.String()
+	})
+
+	return fmt.Sprintf("kubernetes (context=%s resources=%s)", s.Context, resources)
+}
+
+func (s SourceKubernetes) Load(ctx context.Context, logger kitlog.Logger, _ *http.Client) ([]*SourceEntry, error) {
+	config, contextName, err := s.restConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "building Kubernetes client")
+	}
+
+	// Use this whenever we're modifying structures that are race unsafe.
+	var mu sync.Mutex
+	synchronise := func(do func()) {
+		defer mu.Unlock()
+		mu.Lock()
+
+		do()
+	}
+
+	entries := []*SourceEntry{}
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(10)
+
+	for _, resource := range s.Resources {
+		gvr, err := resource.GroupVersionResource()
+		if err != nil {
+			return nil, err
+		}
+
+		// Listing without a namespace lists across all of them, and is also how we list
+		// resources that aren't namespaced.
+		namespaces := resource.Namespaces
+		if len(namespaces) == 0 {
+			namespaces = []string{metav1.NamespaceAll}
+		}
+
+		for _, namespace := range namespaces {
+			g.Go(func() error {
+				logger.Log("msg", "listing Kubernetes resources",
+					"resource", gvr.String(), "namespace", namespace, "label_selector", resource.LabelSelector)
+
+				opts := metav1.ListOptions{LabelSelector: resource.LabelSelector, Limit: 500}
+				for {
+					list, err := client.Resource(gvr).Namespace(namespace).List(ctx, opts)
+					if err != nil {
+						return errors.Wrap(err, fmt.Sprintf("listing Kubernetes %s", gvr.String()))
+					}
+
+					for _, item := range list.Items {
+						// Managed fields are bookkeeping for server-side apply, which is never useful
+						// in the catalog and often most of the object.
+						item.SetManagedFields(nil)
+
+						data, err := json.Marshal(item.Object)
+						if err != nil {
+							return errors.Wrap(err, "marshalling Kubernetes object")
+						}
+
+						synchronise(func() {
+							entries = append(entries, &SourceEntry{
+								Origin: fmt.Sprintf("kubernetes (context=%s resource=%s namespace=%s name=%s)",
+									contextName, gvr.String(), item.GetNamespace(), item.GetName()),
+								Content: data,
+								Metadata: map[string]any{
+									"context":   contextName,
+									"resource":  gvr.Resource,
+									"group":     gvr.Group,
+									"version":   gvr.Version,
+									"namespace": item.GetNamespace(),
+									"name":      item.GetName(),
+								},
+							})
+						})
+					}
+
+					if list.GetContinue() == "" {
+						break
+					}
+					opts.Continue = list.GetContinue()
+				}
+
+				return nil
+			})
+		}
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// restConfig loads the config for connecting to the cluster, returning the name of the
+// kubeconfig context it came from.
+func (s SourceKubernetes) restConfig() (*rest.Config, string, error) {
+	if s.InCluster {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, "", errors.Wrap(err, "loading in-cluster Kubernetes config")
+		}
+
+		return config, "in-cluster", nil
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if s.Kubeconfig != "" {
+		rules.ExplicitPath = s.Kubeconfig
+	}
+
+	// This falls back to the in-cluster config if there's no kubeconfig to load.
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules,
+		&clientcmd.ConfigOverrides{CurrentContext: s.Context})
+
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, "", errors.Wrap(err, "loading Kubernetes config")
+	}
+
+	contextName := s.Context
+	if contextName == "" {
+		if raw, err := clientConfig.RawConfig(); err == nil {
+			contextName = raw.CurrentContext
+		}
+	}
+	if contextName == "" {
+		contextName = "in-cluster"
+	}
+
+	return config, contextName, nil
+}
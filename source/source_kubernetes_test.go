@@ -0,0 +1,201 @@
+package source_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/incident-io/catalog-importer/v2/source"
+	"github.com/samber/lo"
+	"k8s.io/apimachinery/pkg/labels"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SourceKubernetes", func() {
+	var (
+		ctx      context.Context
+		logger   kitlog.Logger
+		s        source.SourceKubernetes
+		server   *httptest.Server
+		objects  map[string][]map[string]any // by path, e.g. /apis/apps/v1/deployments
+		pageSize int
+		tokens   []string
+		mu       sync.Mutex
+	)
+
+	object := func(apiVersion, kind, namespace, name string, labels map[string]string) map[string]any {
+		metadata := map[string]any{"name": name, "labels": labels, "managedFields": []any{map[string]any{"manager": "kubectl"}}}
+		if namespace != "" {
+			metadata["namespace"] = namespace
+		}
+
+		return map[string]any{"apiVersion": apiVersion, "kind": kind, "metadata": metadata}
+	}
+
+	// addObject makes the object available from the cluster-wide path of its resource, and
+	// from the path for its namespace if it has one.
+	addObject := func(prefix, resource string, obj map[string]any) {
+		objects[prefix+"/"+resource] = append(objects[prefix+"/"+resource], obj)
+		if namespace, ok := obj["metadata"].(map[string]any)["namespace"].(string); ok {
+			path := fmt.Sprintf("%s/namespaces/%s/%s", prefix, namespace, resource)
+			objects[path] = append(objects[path], obj)
+		}
+	}
+
+	load := func() []source.Entry {
+		sourceEntries, err := s.Load(ctx, logger, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		entries := []source.Entry{}
+		for _, sourceEntry := range sourceEntries {
+			parsed, err := sourceEntry.Parse()
+			Expect(err).NotTo(HaveOccurred())
+			entries = append(entries, parsed...)
+		}
+
+		return entries
+	}
+
+	names := func(entries []source.Entry) []string {
+		return lo.Map(entries, func(entry source.Entry, _ int) string {
+			return entry["metadata"].(map[string]any)["name"].(string)
+		})
+	}
+
+	BeforeEach(func() {
+		logger = kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stderr))
+		ctx = context.Background()
+
+		objects, pageSize, tokens = map[string][]map[string]any{}, 100, nil
+		addObject("/api/v1", "namespaces", object("v1", "Namespace", "", "payments", nil))
+		addObject("/api/v1", "namespaces", object("v1", "Namespace", "", "platform", nil))
+		addObject("/apis/apps/v1", "deployments", object("apps/v1", "Deployment", "payments", "api", map[string]string{"tier": "backend"}))
+		addObject("/apis/apps/v1", "deployments", object("apps/v1", "Deployment", "payments", "web", map[string]string{"tier": "frontend"}))
+		addObject("/apis/apps/v1", "deployments", object("apps/v1", "Deployment", "platform", "ingress", map[string]string{"tier": "backend"}))
+		addObject("/apis/example.com/v1", "widgets", object("example.com/v1", "Widget", "platform", "sprocket", nil))
+
+		// A stand-in for the Kubernetes API server, which serves lists of the objects. Clients
+		// only send tokens over TLS.
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			mu.Lock()
+			defer mu.Unlock()
+
+			tokens = append(tokens, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+
+			items, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+			Expect(err).NotTo(HaveOccurred())
+			items = lo.Filter(items, func(item map[string]any, _ int) bool {
+				itemLabels, _ := item["metadata"].(map[string]any)["labels"].(map[string]string)
+				return selector.Matches(labels.Set(itemLabels))
+			})
+
+			offset, _ := strconv.Atoi(r.URL.Query().Get("continue"))
+			end, next := min(offset+pageSize, len(items)), ""
+			if end < len(items) {
+				next = strconv.Itoa(end)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			Expect(json.NewEncoder(w).Encode(map[string]any{
+				"apiVersion": "v1",
+				"kind":       "List",
+				"metadata":   map[string]any{"continue": next},
+				"items":      items[offset:end],
+			})).To(Succeed())
+		}))
+		DeferCleanup(server.Close)
+
+		kubeconfig := filepath.Join(GinkgoT().TempDir(), "config")
+		Expect(os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`
+apiVersion: v1
+kind: Config
+clusters:
+  - name: test
+    cluster:
+      server: %s
+      insecure-skip-tls-verify: true
+contexts:
+  - name: staging
+    context: {cluster: test, user: staging}
+  - name: production
+    context: {cluster: test, user: production}
+current-context: staging
+users:
+  - name: staging
+    user: {token: staging-token}
+  - name: production
+    user: {token: production-token}
+`, server.URL)), 0o600)).To(Succeed())
+
+		s = source.SourceKubernetes{
+			Kubeconfig: kubeconfig,
+			Resources: []source.SourceKubernetesResource{
+				{Kind: "Deployment"},
+			},
+		}
+	})
+
+	It("lists objects across all namespaces", func() {
+		entries := load()
+		Expect(names(entries)).To(ConsistOf("api", "web", "ingress"))
+		Expect(entries[0]).To(HaveKeyWithValue("kind", "Deployment"))
+		Expect(entries[0]["metadata"]).NotTo(HaveKey("managedFields"))
+		Expect(entries[0]["$meta"]).To(HaveKeyWithValue("context", "staging"))
+		Expect(tokens).To(HaveEach("staging-token"))
+	})
+
+	It("lists from the given namespaces, filtered by labels", func() {
+		s.Resources = []source.SourceKubernetesResource{
+			{Kind: "deployments", Namespaces: []string{"payments", "platform"}, LabelSelector: "tier=backend"},
+		}
+		Expect(names(load())).To(ConsistOf("api", "ingress"))
+	})
+
+	It("lists cluster-scoped and custom resources", func() {
+		s.Resources = []source.SourceKubernetesResource{
+			{Kind: "Namespace"},
+			{Group: "example.com", Version: "v1", Resource: "widgets"},
+		}
+		Expect(names(load())).To(ConsistOf("payments", "platform", "sprocket"))
+	})
+
+	It("pages through long lists", func() {
+		pageSize = 1
+		Expect(names(load())).To(ConsistOf("api", "web", "ingress"))
+	})
+
+	It("uses the given context", func() {
+		s.Context = "production"
+		entries := load()
+		Expect(entries[0]["$meta"]).To(HaveKeyWithValue("context", "production"))
+		Expect(tokens).To(HaveEach("production-token"))
+	})
+
+	It("validates resources", func() {
+		s.Resources = []source.SourceKubernetesResource{{Kind: "Gadget"}}
+		Expect(s.Validate()).To(MatchError(ContainSubstring("unsupported kind 'Gadget'")))
+
+		s.Resources = []source.SourceKubernetesResource{{Kind: "Deployment", Version: "v1"}}
+		Expect(s.Validate()).To(MatchError(ContainSubstring("not both")))
+
+		s.Resources = []source.SourceKubernetesResource{{Kind: "Namespace", Namespaces: []string{"payments"}}}
+		Expect(s.Validate()).To(MatchError(ContainSubstring("namespaces aren't namespaced")))
+	})
+})
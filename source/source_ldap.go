@@ -0,0 +1,385 @@
+package source
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-ldap/ldap/v3"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+type SourceLDAP struct {
+	URL          Credential `json:"url"`                     // ldap://ad.example.com or ldaps://ad.example.com:636
+	BindDN       Credential `json:"bind_dn,omitempty"`       // e.g. cn=catalog-importer,ou=Service Accounts,dc=example,dc=com
+	BindPassword Credential `json:"bind_password,omitempty"` // binds anonymously if there's no bind_dn
+	// StartTLS, if true, upgrades an ldap:// connection to TLS before binding.
+	StartTLS bool `json:"start_tls,omitempty"`
+	// InsecureSkipVerify, if true, doesn't check the server's certificate. Only use this
+	// when testing.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+
+	BaseDN     string   `json:"base_dn"`              // e.g. ou=People,dc=example,dc=com
+	Scope      string   `json:"scope,omitempty"`      // sub (the default), one or base
+	Filter     string   `json:"filter,omitempty"`     // defaults to (objectClass=*)
+	Attributes []string `json:"attributes,omitempty"` // defaults to all of them
+	// ArrayAttributes are always arrays, even with a single value, so expressions can
+	// treat them the same way for every entry. Others are only arrays when they have more
+	// than one value.
+	ArrayAttributes []string `json:"array_attributes,omitempty"`
+	// DNAttributes hold DNs, such as member, so each entry also has the name of each DN
+	// they refer to under <attribute>_rdn.
+	DNAttributes []string `json:"dn_attributes,omitempty"`
+	PageSize     int      `json:"page_size,omitempty"` // defaults to 500
+	Timeout      string   `json:"timeout,omitempty"`   // for each request, defaults to 30s
+}
+
+var (
+	defaultLDAPArrayAttributes = []string{"objectClass", "member", "memberOf", "uniqueMember", "memberUid", "directReports"}
+	defaultLDAPDNAttributes    = []string{"member", "memberOf", "uniqueMember", "manager", "directReports"}
+)
+
+var ldapScopes = map[string]int{
+	"sub":  ldap.ScopeWholeSubtree,
+	"one":  ldap.ScopeSingleLevel,
+	"base": ldap.ScopeBaseObject,
+}
+
+func (s SourceLDAP) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.URL, validation.Required, validation.By(func(value any) error {
+			parsed, err := url.Parse(string(s.URL))
+			if err != nil || (parsed.Scheme != "ldap" && parsed.Scheme != "ldaps") {
+				return errors.New("must be an ldap:// or ldaps:// URL")
+			}
+			if parsed.Scheme == "ldaps" && s.StartTLS {
+				return errors.New("can't use start_tls with ldaps://, which is already TLS")
+			}
+
+			return nil
+		})),
+		validation.Field(&s.BindPassword, validation.When(s.BindDN != "", validation.Required)),
+		validation.Field(&s.BaseDN, validation.Required, validation.By(func(value any) error {
+			_, err := ldap.ParseDN(s.BaseDN)
+			return err
+		})),
+		validation.Field(&s.Scope, validation.In("sub", "one", "base")),
+		validation.Field(&s.Filter, validation.By(func(value any) error {
+			if s.Filter == "" {
+				return nil
+			}
+			_, err := ldap.CompileFilter(s.Filter)
+			return err
+		})),
+		validation.Field(&s.PageSize, validation.Min(0)),
+		validation.Field(&s.Timeout, validation.By(func(value any) error {
+			if s.Timeout == "" {
+				return nil
+			}
+			if _, err := time.ParseDuration(s.Timeout); err != nil {
+				return errors.Wrap(err, "must be a duration such as 30s")
+			}
+
+			return nil
+		})),
+	)
+}
+
+func (s SourceLDAP) String() string {
+	return fmt.Sprintf("ldap (base_dn=%s filter=%s)", s.BaseDN, s.filter())
+}
+
+func (s SourceLDAP) filter() string {
+	if s.Filter == "" {
+		return "(objectClass=*)"
+	}
+
+	return s.Filter
+}
+
+func (s SourceLDAP) Load(ctx context.Context, logger kitlog.Logger, _ *http.Client) ([]*SourceEntry, error) {
+	pageSize := s.PageSize
+	if pageSize == 0 {
+		pageSize = 500
+	}
+	timeout := 30 * time.Second
+	if s.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(s.Timeout)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing timeout")
+		}
+	}
+	scope := ldap.ScopeWholeSubtree
+	if s.Scope != "" {
+		scope = ldapScopes[s.Scope]
+	}
+
+	conn, err := s.connect(logger, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// The LDAP client doesn't take a context, so we close the connection to stop it.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	logger.Log("msg", "searching LDAP", "base_dn", s.BaseDN, "filter", s.filter(), "page_size", pageSize)
+	result, err := conn.SearchWithPaging(ldap.NewSearchRequest(
+		s.BaseDN, scope, ldap.NeverDerefAliases, 0, 0, false, s.filter(), s.Attributes, nil,
+	), uint32(pageSize))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		return nil, errors.Wrap(err, "searching LDAP")
+	}
+
+	entries := []*SourceEntry{}
+	for _, ldapEntry := range result.Entries {
+		if err := s.completeRanges(conn, ldapEntry); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			return nil, errors.Wrap(err, fmt.Sprintf("reading LDAP entry '%s'", ldapEntry.DN))
+		}
+
+		entry, err := s.entry(ldapEntry)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("reading LDAP entry '%s'", ldapEntry.DN))
+		}
+
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return nil, errors.Wrap(err, "marshalling LDAP entry")
+		}
+
+		entries = append(entries, &SourceEntry{
+			Origin:   fmt.Sprintf("ldap (dn=%s)", ldapEntry.DN),
+			Content:  data,
+			Metadata: map[string]any{"dn": ldapEntry.DN},
+		})
+	}
+
+	logger.Log("msg", "found LDAP entries", "count", len(entries))
+
+	return entries, nil
+}
+
+func (s SourceLDAP) connect(logger kitlog.Logger, timeout time.Duration) (*ldap.Conn, error) {
+	parsed, err := url.Parse(string(s.URL))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing LDAP URL")
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         parsed.Hostname(),
+		InsecureSkipVerify: s.InsecureSkipVerify,
+	}
+
+	conn, err := ldap.DialURL(string(s.URL),
+		ldap.DialWithDialer(&net.Dialer{Timeout: timeout}), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to LDAP server")
+	}
+	conn.SetTimeout(timeout)
+
+	if s.StartTLS {
+		logger.Log("msg", "upgrading LDAP connection with StartTLS")
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "starting TLS")
+		}
+	}
+
+	if s.BindDN == "" {
+		err = conn.UnauthenticatedBind("")
+	} else {
+		err = conn.Bind(string(s.BindDN), string(s.BindPassword))
+	}
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "binding to LDAP server")
+	}
+
+	return conn, nil
+}
+
+// entry converts an LDAP entry into a map, with the parts of its DN alongside its
+// attributes:
+//
+//   - dn is the full DN, e.g. cn=Alice,ou=People,dc=example,dc=com
+//   - rdn is the value of its first component, e.g. Alice
+//   - parent_dn is the DN of its parent, e.g. ou=People,dc=example,dc=com
+//   - dn_components are the values of each type of component, in order, e.g.
+//     {"cn": ["Alice"], "ou": ["People"], "dc": ["example", "com"]}
+func (s SourceLDAP) entry(ldapEntry *ldap.Entry) (map[string]any, error) {
+	dn, err := ldap.ParseDN(ldapEntry.DN)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing DN")
+	}
+
+	entry := map[string]any{
+		"dn":            ldapEntry.DN,
+		"rdn":           ldapRDN(dn),
+		"parent_dn":     ldapParentDN(dn),
+		"dn_components": ldapDNComponents(dn),
+	}
+
+	arrayAttributes := lo.Ternary(s.ArrayAttributes != nil, s.ArrayAttributes, defaultLDAPArrayAttributes)
+	dnAttributes := lo.Ternary(s.DNAttributes != nil, s.DNAttributes, defaultLDAPDNAttributes)
+	isOneOf := func(name string, names []string) bool {
+		return lo.ContainsBy(names, func(candidate string) bool {
+			return strings.EqualFold(candidate, name)
+		})
+	}
+
+	for _, attribute := range ldapEntry.Attributes {
+		values := ldapValues(attribute)
+		isArray := len(values) != 1 || isOneOf(attribute.Name, arrayAttributes)
+		if isArray {
+			entry[attribute.Name] = values
+		} else {
+			entry[attribute.Name] = values[0]
+		}
+
+		if isOneOf(attribute.Name, dnAttributes) {
+			rdns := []string{}
+			for _, value := range values {
+				valueDN, err := ldap.ParseDN(value)
+				if err != nil {
+					return nil, errors.Wrap(err, fmt.Sprintf("parsing DN in '%s'", attribute.Name))
+				}
+				rdns = append(rdns, ldapRDN(valueDN))
+			}
+
+			if isArray {
+				entry[attribute.Name+"_rdn"] = rdns
+			} else {
+				entry[attribute.Name+"_rdn"] = rdns[0]
+			}
+		}
+	}
+
+	return entry, nil
+}
+
+// ldapValues returns the values of an attribute as strings. Binary values, such as
+// objectGUID and objectSid in Active Directory, are base64 encoded.
+func ldapValues(attribute *ldap.EntryAttribute) []string {
+	return lo.Map(attribute.ByteValues, func(value []byte, _ int) string {
+		if !utf8.Valid(value) {
+			return base64.StdEncoding.EncodeToString(value)
+		}
+
+		return string(value)
+	})
+}
+
+// completeRanges fetches the rest of any attribute the server only returned some of the
+// values for. Active Directory returns at most 1500 values of an attribute at once,
+// naming the attribute with the range it holds (e.g. member;range=0-1499), so we ask for
+// the next range until we reach the end (member;range=1500-*). The attribute is then
+// renamed to drop the range.
+func (s SourceLDAP) completeRanges(conn *ldap.Conn, ldapEntry *ldap.Entry) error {
+	for _, attribute := range ldapEntry.Attributes {
+		name, end, ok := ldapRange(attribute.Name)
+		if !ok {
+			continue
+		}
+
+		for end != "*" {
+			last, err := strconv.Atoi(end)
+			if err != nil {
+				return fmt.Errorf("parsing range of attribute '%s'", attribute.Name)
+			}
+
+			result, err := conn.Search(ldap.NewSearchRequest(
+				ldapEntry.DN, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)",
+				[]string{fmt.Sprintf("%s;range=%d-*", name, last+1)}, nil,
+			))
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("fetching range of attribute '%s'", name))
+			}
+			if len(result.Entries) == 0 {
+				break
+			}
+
+			next, found := lo.Find(result.Entries[0].Attributes, func(candidate *ldap.EntryAttribute) bool {
+				candidateName, _, ok := ldapRange(candidate.Name)
+				return ok && strings.EqualFold(candidateName, name)
+			})
+			if !found {
+				break
+			}
+
+			attribute.Values = append(attribute.Values, next.Values...)
+			attribute.ByteValues = append(attribute.ByteValues, next.ByteValues...)
+			_, end, _ = ldapRange(next.Name)
+		}
+
+		attribute.Name = name
+	}
+
+	return nil
+}
+
+// ldapRange parses an attribute name with a range option, such as member;range=0-1499,
+// into the attribute's name and the end of the range, which is * for the last one.
+func ldapRange(attributeName string) (string, string, bool) {
+	name, options, ok := strings.Cut(attributeName, ";")
+	if !ok {
+		return attributeName, "", false
+	}
+
+	for _, option := range strings.Split(options, ";") {
+		if rng, ok := strings.CutPrefix(strings.ToLower(option), "range="); ok {
+			_, end, ok := strings.Cut(rng, "-")
+			return name, end, ok
+		}
+	}
+
+	return attributeName, "", false
+}
+
+func ldapRDN(dn *ldap.DN) string {
+	if len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+		return ""
+	}
+
+	return dn.RDNs[0].Attributes[0].Value
+}
+
+func ldapParentDN(dn *ldap.DN) string {
+	if len(dn.RDNs) <= 1 {
+		return ""
+	}
+
+	return (&ldap.DN{RDNs: dn.RDNs[1:]}).String()
+}
+
+func ldapDNComponents(dn *ldap.DN) map[string][]string {
+	components := map[string][]string{}
+	for _, rdn := range dn.RDNs {
+		for _, attribute := range rdn.Attributes {
+			name := strings.ToLower(attribute.Type)
+			components[name] = append(components[name], attribute.Value)
+		}
+	}
+
+	return components
+}
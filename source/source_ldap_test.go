@@ -0,0 +1,353 @@
+package source_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/incident-io/catalog-importer/v2/source"
+	"github.com/samber/lo"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// ldapObject is an object in the directory served by fakeLDAPServer.
+type ldapObject struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// fakeLDAPServer is a stand-in for an LDAP server, supporting just enough of the
+// protocol for simple binds and paged searches with equality or presence filters.
+type fakeLDAPServer struct {
+	BindDN, BindPassword string
+	Objects              []ldapObject
+	// MaxValues, if set, limits how many values of an attribute are returned at once,
+	// like Active Directory's range retrieval (e.g. member;range=0-1499).
+	MaxValues int
+
+	mu        sync.Mutex
+	pageSizes []uint32 // of each search request
+	listener  net.Listener
+}
+
+func (f *fakeLDAPServer) Start() string {
+	var err error
+	f.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+
+	go func() {
+		for {
+			conn, err := f.listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+
+	return "ldap://" + f.listener.Addr().String()
+}
+
+func (f *fakeLDAPServer) Close() {
+	f.listener.Close()
+}
+
+func (f *fakeLDAPServer) serve(conn net.Conn) {
+	defer GinkgoRecover()
+	defer conn.Close()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		messageID, op := packet.Children[0].Value.(int64), packet.Children[1]
+		respond := func(tag ber.Tag, children ...*ber.Packet) {
+			envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+			envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+			response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+			for _, child := range children {
+				response.AppendChild(child)
+			}
+			envelope.AppendChild(response)
+			_, err := conn.Write(envelope.Bytes())
+			Expect(err).NotTo(HaveOccurred())
+		}
+		result := func(code int64) []*ber.Packet {
+			return []*ber.Packet{
+				ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "resultCode"),
+				ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"),
+				ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"),
+			}
+		}
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			name, password := op.Children[1].Value.(string), op.Children[2].Data.String()
+			respond(ldap.ApplicationBindResponse, result(lo.Ternary[int64](
+				name == f.BindDN && password == f.BindPassword, ldap.LDAPResultSuccess, ldap.LDAPResultInvalidCredentials))...)
+
+		case ldap.ApplicationSearchRequest:
+			baseDN := strings.ToLower(op.Children[0].Value.(string))
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			Expect(err).NotTo(HaveOccurred())
+			attributes := lo.Map(op.Children[7].Children, func(child *ber.Packet, _ int) string {
+				return child.Value.(string)
+			})
+
+			matches := lo.Filter(f.Objects, func(object ldapObject, _ int) bool {
+				return strings.HasSuffix(strings.ToLower(object.DN), baseDN) && matchesFilter(object, filter)
+			})
+
+			// Page through the matches, with the cookie being the offset of the next page.
+			// Searches without controls, such as for the rest of a range, aren't paged.
+			paging := &ldap.ControlPaging{}
+			requestControls := []*ber.Packet{}
+			if len(packet.Children) > 2 {
+				requestControls = packet.Children[2].Children
+			}
+			for _, control := range requestControls {
+				decoded, err := ldap.DecodeControl(control)
+				Expect(err).NotTo(HaveOccurred())
+				if decoded, ok := decoded.(*ldap.ControlPaging); ok {
+					paging = decoded
+				}
+			}
+			f.mu.Lock()
+			f.pageSizes = append(f.pageSizes, paging.PagingSize)
+			f.mu.Unlock()
+
+			offset, _ := strconv.Atoi(string(paging.Cookie))
+			end := min(offset+int(paging.PagingSize), len(matches))
+			if paging.PagingSize == 0 {
+				end = len(matches)
+			}
+
+			for _, object := range matches[offset:end] {
+				attributeList := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+				for name, values := range object.Attributes {
+					// Requests for a range of values ask for e.g. member;range=2-*.
+					start, ranged := 0, false
+					for _, requested := range attributes {
+						if from, ok := strings.CutPrefix(requested, name+";range="); ok {
+							start, _ = strconv.Atoi(strings.TrimSuffix(from, "-*"))
+							ranged = true
+						}
+					}
+					if len(attributes) > 0 && !lo.Contains(attributes, name) && !ranged {
+						continue
+					}
+					if f.MaxValues > 0 && (ranged || len(values) > f.MaxValues) {
+						end := min(start+f.MaxValues, len(values))
+						last := lo.Ternary(end == len(values), "*", strconv.Itoa(end-1))
+						name, values = fmt.Sprintf("%s;range=%d-%s", name, start, last), values[start:end]
+					}
+
+					attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+					attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+					set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "values")
+					for _, value := range values {
+						set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "value"))
+					}
+					attribute.AppendChild(set)
+					attributeList.AppendChild(attribute)
+				}
+
+				respond(ldap.ApplicationSearchResultEntry,
+					ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, object.DN, "objectName"),
+					attributeList)
+			}
+
+			next := ldap.NewControlPaging(paging.PagingSize)
+			if end < len(matches) {
+				next.SetCookie([]byte(strconv.Itoa(end)))
+			}
+
+			// Controls follow the response in the envelope, so we build this one by hand.
+			envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+			envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+			done := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultDone, nil, "Search Result Done")
+			for _, child := range result(ldap.LDAPResultSuccess) {
+				done.AppendChild(child)
+			}
+			envelope.AppendChild(done)
+			controls := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+			controls.AppendChild(next.Encode())
+			envelope.AppendChild(controls)
+			_, err = conn.Write(envelope.Bytes())
+			Expect(err).NotTo(HaveOccurred())
+
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+// matchesFilter supports presence filters, e.g. (objectClass=*), and equality filters,
+// e.g. (objectClass=group).
+func matchesFilter(object ldapObject, filter string) bool {
+	name, value, _ := strings.Cut(strings.Trim(filter, "()"), "=")
+	values := lo.Map(object.Attributes[name], func(value string, _ int) string { return strings.ToLower(value) })
+
+	return (value == "*" && len(values) > 0) || lo.Contains(values, strings.ToLower(value))
+}
+
+var _ = Describe("SourceLDAP", func() {
+	var (
+		ctx    context.Context
+		logger kitlog.Logger
+		s      source.SourceLDAP
+		server *fakeLDAPServer
+	)
+
+	BeforeEach(func() {
+		logger = kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stderr))
+		ctx = context.Background()
+
+		server = &fakeLDAPServer{
+			BindDN:       "cn=catalog-importer,ou=Service Accounts,dc=example,dc=com",
+			BindPassword: "secret",
+			Objects: []ldapObject{
+				{DN: "cn=Alice Smith,ou=People,dc=example,dc=com", Attributes: map[string][]string{
+					"objectClass": {"person"}, "cn": {"Alice Smith"}, "mail": {"alice@example.com"},
+					"memberOf": {"cn=Platform,ou=Groups,dc=example,dc=com"},
+				}},
+				{DN: "cn=Bob Jones,ou=People,dc=example,dc=com", Attributes: map[string][]string{
+					"objectClass": {"person"}, "cn": {"Bob Jones"}, "mail": {"bob@example.com", "bobby@example.com"},
+					"manager": {"cn=Alice Smith,ou=People,dc=example,dc=com"},
+				}},
+				{DN: "cn=Platform,ou=Groups,dc=example,dc=com", Attributes: map[string][]string{
+					"objectClass": {"group"}, "cn": {"Platform"},
+					"member": {"cn=Alice Smith,ou=People,dc=example,dc=com"},
+				}},
+				{DN: "cn=Payments,ou=Groups,dc=example,dc=com", Attributes: map[string][]string{
+					"objectClass": {"group"}, "cn": {"Payments"},
+					"member": {"cn=Alice Smith,ou=People,dc=example,dc=com", "cn=Bob Jones,ou=People,dc=example,dc=com"},
+				}},
+			},
+		}
+		url := server.Start()
+		DeferCleanup(server.Close)
+
+		s = source.SourceLDAP{
+			URL:          source.Credential(url),
+			BindDN:       source.Credential(server.BindDN),
+			BindPassword: source.Credential(server.BindPassword),
+			BaseDN:       "dc=example,dc=com",
+		}
+	})
+
+	load := func() []source.Entry {
+		sourceEntries, err := s.Load(ctx, logger, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		entries := []source.Entry{}
+		for _, sourceEntry := range sourceEntries {
+			parsed, err := sourceEntry.Parse()
+			Expect(err).NotTo(HaveOccurred())
+			entries = append(entries, parsed...)
+		}
+
+		return entries
+	}
+
+	byRDN := func(entries []source.Entry) map[string]source.Entry {
+		return lo.KeyBy(entries, func(entry source.Entry) string {
+			return entry["rdn"].(string)
+		})
+	}
+
+	It("returns an entry for each object, with the parts of its DN", func() {
+		entries := byRDN(load())
+		Expect(entries).To(HaveLen(4))
+
+		alice := entries["Alice Smith"]
+		Expect(alice).To(HaveKeyWithValue("dn", "cn=Alice Smith,ou=People,dc=example,dc=com"))
+		Expect(alice).To(HaveKeyWithValue("parent_dn", "ou=People,dc=example,dc=com"))
+		Expect(alice).To(HaveKeyWithValue("dn_components", map[string]any{
+			"cn": []any{"Alice Smith"}, "ou": []any{"People"}, "dc": []any{"example", "com"},
+		}))
+		Expect(alice["$meta"]).To(HaveKeyWithValue("dn", "cn=Alice Smith,ou=People,dc=example,dc=com"))
+	})
+
+	It("only uses arrays for attributes with many values, or that always have them", func() {
+		entries := byRDN(load())
+		Expect(entries["Alice Smith"]).To(HaveKeyWithValue("mail", "alice@example.com"))
+		Expect(entries["Bob Jones"]).To(HaveKeyWithValue("mail", []any{"bob@example.com", "bobby@example.com"}))
+		Expect(entries["Alice Smith"]).To(HaveKeyWithValue("objectClass", []any{"person"}))
+		Expect(entries["Platform"]).To(HaveKeyWithValue("member", []any{"cn=Alice Smith,ou=People,dc=example,dc=com"}))
+	})
+
+	It("adds the names of the DNs in DN attributes", func() {
+		entries := byRDN(load())
+		Expect(entries["Platform"]).To(HaveKeyWithValue("member_rdn", []any{"Alice Smith"}))
+		Expect(entries["Payments"]).To(HaveKeyWithValue("member_rdn", []any{"Alice Smith", "Bob Jones"}))
+		Expect(entries["Alice Smith"]).To(HaveKeyWithValue("memberOf_rdn", []any{"Platform"}))
+		Expect(entries["Bob Jones"]).To(HaveKeyWithValue("manager_rdn", "Alice Smith"))
+	})
+
+	It("fetches every value of attributes the server returns in ranges", func() {
+		server.MaxValues = 2
+		server.Objects[3].Attributes["member"] = append(server.Objects[3].Attributes["member"],
+			"cn=Carol White,ou=People,dc=example,dc=com", "cn=Dan Brown,ou=People,dc=example,dc=com", "cn=Erin Green,ou=People,dc=example,dc=com")
+
+		entries := byRDN(load())
+		Expect(entries["Payments"]).To(HaveKeyWithValue("member_rdn", []any{
+			"Alice Smith", "Bob Jones", "Carol White", "Dan Brown", "Erin Green",
+		}))
+		Expect(entries["Payments"]).NotTo(HaveKey(HavePrefix("member;range=")))
+	})
+
+	It("base64 encodes binary values, and handles attributes without values", func() {
+		server.Objects[0].Attributes["objectGUID"] = []string{"\xff\x00\x10\x80"}
+		server.Objects[0].Attributes["description"] = []string{}
+		server.Objects[0].Attributes["manager"] = []string{}
+
+		alice := byRDN(load())["Alice Smith"]
+		Expect(alice).To(HaveKeyWithValue("objectGUID", "/wAQgA=="))
+		Expect(alice).To(HaveKeyWithValue("description", BeEmpty()))
+		Expect(alice).To(HaveKeyWithValue("manager_rdn", BeEmpty()))
+	})
+
+	It("filters and selects attributes", func() {
+		s.BaseDN = "ou=Groups,dc=example,dc=com"
+		s.Filter = "(objectClass=group)"
+		s.Attributes = []string{"cn"}
+
+		entries := load()
+		Expect(lo.Map(entries, func(entry source.Entry, _ int) any { return entry["cn"] })).To(
+			ConsistOf("Platform", "Payments"))
+		Expect(entries[0]).NotTo(HaveKey("member"))
+	})
+
+	It("pages through results", func() {
+		s.PageSize = 1
+		Expect(load()).To(HaveLen(4))
+		Expect(server.pageSizes).To(Equal([]uint32{1, 1, 1, 1}))
+	})
+
+	It("fails with the wrong password", func() {
+		s.BindPassword = "wrong"
+		_, err := s.Load(ctx, logger, nil)
+		Expect(err).To(MatchError(ContainSubstring("binding to LDAP server")))
+	})
+
+	It("validates the config", func() {
+		s.URL = "https://ad.example.com"
+		s.Filter = "objectClass=group"
+		Expect(s.Validate()).To(MatchError(And(
+			ContainSubstring("url: must be an ldap:// or ldaps:// URL"),
+			ContainSubstring("filter:"),
+		)))
+	})
+})
@@ -0,0 +1,189 @@
+package source
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	_ "modernc.org/sqlite"
+)
+
+type SourceSQL struct {
+	Driver string     `json:"driver"` // postgres, mysql or sqlite
+	DSN    Credential `json:"dsn"`    // e.g. $(DATABASE_URL)
+	// Query selects the entries, one per row. It runs once, exactly as written.
+	Query    string `json:"query"`
+	PageSize int    `json:"page_size,omitempty"` // rows per source entry, defaults to 1000
+	Timeout  string `json:"timeout,omitempty"`   // for the whole query, defaults to 5m
+}
+
+// sqlDrivers maps the drivers we support to their database/sql driver names.
+var sqlDrivers = map[string]string{
+	"postgres": "pgx",
+	"mysql":    "mysql",
+	"sqlite":   "sqlite",
+}
+
+func (s SourceSQL) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Driver, validation.Required, validation.In("postgres", "mysql", "sqlite")),
+		validation.Field(&s.DSN, validation.Required),
+		validation.Field(&s.Query, validation.Required),
+		validation.Field(&s.PageSize, validation.Min(0)),
+		validation.Field(&s.Timeout, validation.By(func(value any) error {
+			if s.Timeout == "" {
+				return nil
+			}
+			if _, err := time.ParseDuration(s.Timeout); err != nil {
+				return errors.Wrap(err, "must be a duration such as 30s")
+			}
+
+			return nil
+		})),
+	)
+}
+
+func (s SourceSQL) String() string {
+	return fmt.Sprintf("sql (driver=%s)", s.Driver)
+}
+
+func (s SourceSQL) Load(ctx context.Context, logger kitlog.Logger, _ *http.Client) ([]*SourceEntry, error) {
+	pageSize := s.PageSize
+	if pageSize == 0 {
+		pageSize = 1000
+	}
+	timeout := 5 * time.Minute
+	if s.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(s.Timeout)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing timeout")
+		}
+	}
+
+	db, err := sql.Open(sqlDrivers[s.Driver], string(s.DSN))
+	if err != nil {
+		return nil, errors.Wrap(err, "opening database")
+	}
+	defer db.Close()
+
+	// The timeout covers running the query and reading every row it returns.
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	logger.Log("msg", "querying database", "driver", s.Driver)
+	rows, err := db.QueryContext(ctx, s.Query)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying database")
+	}
+	defer rows.Close()
+
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading columns")
+	}
+
+	// We put each page of rows in a single entry, which is parsed as a list of entries,
+	// rather than have the overhead of an entry for every row. Marshalling each page as
+	// soon as it fills means we only ever hold one page of parsed rows.
+	entries, page := []*SourceEntry{}, []map[string]any{}
+	flush := func() error {
+		if len(page) == 0 {
+			return nil
+		}
+
+		data, err := json.Marshal(page)
+		if err != nil {
+			return errors.Wrap(err, "marshalling rows")
+		}
+
+		number := len(entries) + 1
+		entries = append(entries, &SourceEntry{
+			Origin:   fmt.Sprintf("sql (driver=%s page=%d)", s.Driver, number),
+			Content:  data,
+			Metadata: map[string]any{"driver": s.Driver, "page": number},
+		})
+		page = []map[string]any{}
+
+		return nil
+	}
+
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for idx := range values {
+			pointers[idx] = &values[idx]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, errors.Wrap(err, "scanning row")
+		}
+
+		result := map[string]any{}
+		for idx, column := range columns {
+			result[column.Name()] = sqlValue(values[idx], column.DatabaseTypeName())
+		}
+
+		page = append(page, result)
+		if len(page) >= pageSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading rows")
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	logger.Log("msg", "read rows from database", "driver", s.Driver, "pages", len(entries))
+	return entries, nil
+}
+
+// sqlValue converts a value from the database into the type its column implies. Drivers
+// often give us text for numbers, especially MySQL, which gives us bytes for everything.
+func sqlValue(value any, databaseType string) any {
+	if data, ok := value.([]byte); ok {
+		value = string(data)
+	}
+
+	text, ok := value.(string)
+	if !ok {
+		return value // already typed, or NULL
+	}
+
+	databaseType = strings.ToUpper(databaseType)
+	switch {
+	case databaseType == "JSON" || databaseType == "JSONB":
+		var parsed any
+		if err := json.Unmarshal([]byte(text), &parsed); err == nil {
+			return parsed
+		}
+	case databaseType == "BOOL" || databaseType == "BOOLEAN":
+		if parsed, err := strconv.ParseBool(text); err == nil {
+			return parsed
+		}
+	case strings.Contains(databaseType, "INT") || strings.Contains(databaseType, "SERIAL"):
+		if parsed, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return parsed
+		}
+	case lo.Contains([]string{"DECIMAL", "NUMERIC", "FLOAT", "FLOAT4", "FLOAT8", "DOUBLE", "REAL"}, databaseType):
+		if parsed, err := strconv.ParseFloat(text, 64); err == nil {
+			return parsed
+		}
+	}
+
+	return text
+}
@@ -0,0 +1,116 @@
+package source_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/incident-io/catalog-importer/v2/source"
+	"github.com/samber/lo"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SourceSQL", func() {
+	var (
+		ctx    context.Context
+		logger kitlog.Logger
+		s      source.SourceSQL
+	)
+
+	BeforeEach(func() {
+		logger = kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stderr))
+		ctx = context.Background()
+
+		dsn := filepath.Join(GinkgoT().TempDir(), "catalog.db")
+		db, err := sql.Open("sqlite", dsn)
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+
+		_, err = db.Exec(`
+create table regions (
+  id integer primary key,
+  name text not null,
+  code text not null,
+  latency_ms real,
+  tags json
+);
+insert into regions (id, name, code, latency_ms, tags) values
+  (1, 'London', 'eu-west-2', 12.5, '["eu", "primary"]'),
+  (2, 'Dublin', 'eu-west-1', 18, '["eu"]'),
+  (3, 'Virginia', 'us-east-1', 80.25, '["us"]'),
+  (4, 'Oregon', 'us-west-2', null, null),
+  (5, 'Tokyo', 'ap-northeast-1', 210, '[]');
+`)
+		Expect(err).NotTo(HaveOccurred())
+
+		s = source.SourceSQL{
+			Driver: "sqlite",
+			DSN:    source.Credential(dsn),
+			Query:  "select * from regions order by id;",
+		}
+	})
+
+	load := func() []source.Entry {
+		sourceEntries, err := s.Load(ctx, logger, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		entries := []source.Entry{}
+		for _, sourceEntry := range sourceEntries {
+			parsed, err := sourceEntry.Parse()
+			Expect(err).NotTo(HaveOccurred())
+			entries = append(entries, parsed...)
+		}
+
+		return entries
+	}
+
+	It("returns an entry for each row, with typed columns", func() {
+		entries := load()
+		Expect(entries).To(HaveLen(5))
+		Expect(lo.OmitByKeys(entries[0], []string{source.MetaKey})).To(Equal(source.Entry{
+			"id":         float64(1),
+			"name":       "London",
+			"code":       "eu-west-2",
+			"latency_ms": 12.5,
+			"tags":       []any{"eu", "primary"},
+		}))
+		Expect(entries[3]).To(HaveKeyWithValue("latency_ms", BeNil()))
+		Expect(entries[3]).To(HaveKeyWithValue("tags", BeNil()))
+	})
+
+	It("pages through the results", func() {
+		s.PageSize = 2
+
+		sourceEntries, err := s.Load(ctx, logger, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sourceEntries).To(HaveLen(3))
+		Expect(sourceEntries[2].Metadata).To(HaveKeyWithValue("page", 3))
+
+		Expect(lo.Map(load(), func(entry source.Entry, _ int) any {
+			return entry["name"]
+		})).To(Equal([]any{"London", "Dublin", "Virginia", "Oregon", "Tokyo"}))
+	})
+
+	It("stops queries that take longer than the timeout", func() {
+		s.Timeout = "100ms"
+		s.Query = `
+with recursive forever(n) as (select 1 union all select n + 1 from forever)
+select count(*) as n from forever`
+
+		_, err := s.Load(ctx, logger, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("validates the config", func() {
+		s.Driver = "oracle"
+		s.Timeout = "soon"
+		Expect(s.Validate()).To(MatchError(And(
+			ContainSubstring("driver: must be a valid value"),
+			ContainSubstring("timeout: must be a duration"),
+		)))
+	})
+})
@@ -0,0 +1,51 @@
+package source_test
+
+import (
+	"context"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/incident-io/catalog-importer/v2/source"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SourceEntry", func() {
+	Describe("Parse", func() {
+		It("adds provenance to every entry under $meta", func() {
+			entry := source.SourceEntry{
+				Origin:   "github (repo=example-org/api path=catalog-info.yaml)",
+				Filename: "catalog-info.yaml",
+				Content:  []byte("name: api\n---\nname: web\n$meta: overwritten\n"),
+				Source:   "github",
+				Metadata: map[string]any{"repo": "api"},
+			}
+
+			entries, err := entry.Parse()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(2))
+			for _, parsed := range entries {
+				Expect(parsed).To(HaveKeyWithValue(source.MetaKey, map[string]any{
+					"source":   "github",
+					"origin":   "github (repo=example-org/api path=catalog-info.yaml)",
+					"filename": "catalog-info.yaml",
+					"repo":     "api",
+				}))
+			}
+		})
+	})
+})
+
+var _ = Describe("Source", func() {
+	It("sets the type of source on each entry", func() {
+		src := source.Source{
+			Inline: &source.SourceInline{Entries: []map[string]any{{"name": "api"}}},
+		}
+		Expect(src.Type()).To(Equal("inline"))
+
+		entries, err := src.Load(context.Background(), kitlog.NewNopLogger())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Meta()).To(HaveKeyWithValue("source", "inline"))
+	})
+})